@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// actionSettleDelay is the delay during which a server which hasn't left its
+// power state since the last action is considered to be moving toward the
+// state requested, for the modules which don't report the transitions.
+const actionSettleDelay = 15 * time.Second
+
+var (
+	// errActionSkipped is returned when the server already is in, or is
+	// moving toward, the state requested by an action
+	errActionSkipped = errors.New("action skipped")
+	// errActionConflict is returned when the state of the server prevents an
+	// action, such as switching on a server which is shutting down
+	errActionConflict = errors.New("conflicting action")
+)
+
+// stoppingActions contains the actions leaving the server switched off or
+// sleeping.
+var stoppingActions = modules.Capabilities{
+	modules.CapabilitySuspend,
+	modules.CapabilityHibernate,
+	modules.CapabilityForceOff,
+	modules.CapabilityGracefulShutdown,
+	modules.CapabilityPressAndHold,
+}
+
+// checkAction returns errActionSkipped or errActionConflict when capability
+// shouldn't be performed on a server in state. An unknown state doesn't
+// prevent any action.
+func (t *Target) checkAction(capability modules.Capability, state *modules.State) error {
+	power := state.Power
+	if capability == t.lastAction && power == t.lastPower && time.Since(t.lastActionTime) < actionSettleDelay {
+		return fmt.Errorf("%w: the %s action has just been performed", errActionSkipped, capability)
+	}
+
+	stopped := power == modules.PowerOff || power == modules.PowerSleeping
+	switch {
+	case capability == modules.CapabilityPowerOn:
+		if power == modules.PowerOn || power == modules.PoweringOn {
+			return fmt.Errorf("%w: the server is %s", errActionSkipped, power)
+		}
+		if power == modules.PoweringOff {
+			return fmt.Errorf("%w: the server is %s", errActionConflict, power)
+		}
+	case capability == modules.CapabilityPowerOff:
+		if stopped || power == modules.PoweringOff {
+			return fmt.Errorf("%w: the server is %s", errActionSkipped, power)
+		}
+	case stoppingActions.Has(capability):
+		if stopped {
+			return fmt.Errorf("%w: the server is %s", errActionSkipped, power)
+		}
+	default:
+		if stopped {
+			return fmt.Errorf("%w: the server is %s", errActionConflict, power)
+		}
+	}
+	return nil
+}
+
+// currentState retrieves the state of the server, ignoring the state cached
+// by the poller.
+func (t *Target) currentState(ctx context.Context, timeouts *TimeoutsConfig) modules.State {
+	if t.Poller != nil {
+		return t.Poller.Refresh(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeouts.State)
+	defer cancel()
+	return modules.ReadState(ctx, t.Module)
+}
+
+// Perform performs the action described by capability, unless the server
+// already is in, or is moving toward, the requested state. The actions of a
+// target are performed one at a time, and the state checked beforehand is
+// returned along with the error. The reconciliation toward another state is
+// canceled, so that it doesn't revert the action.
+func (t *Target) Perform(ctx context.Context, timeouts *TimeoutsConfig, capability modules.Capability) (modules.State, error) {
+	if t.Reconciler != nil {
+		t.Reconciler.cancelConflicting(capability)
+	}
+	return t.perform(ctx, timeouts, capability)
+}
+
+func (t *Target) perform(ctx context.Context, timeouts *TimeoutsConfig, capability modules.Capability) (modules.State, error) {
+	select {
+	case t.actions <- struct{}{}:
+	case <-ctx.Done():
+		return modules.State{}, fmt.Errorf("error waiting for the previous action: %w", ctx.Err())
+	}
+	defer func() {
+		<-t.actions
+	}()
+
+	state := t.currentState(ctx, timeouts)
+	err := t.checkAction(capability, &state)
+	if err != nil {
+		return state, err
+	}
+
+	actionCtx, cancel := context.WithTimeout(ctx, timeouts.For(capability))
+	defer cancel()
+	err = modules.Perform(actionCtx, t.Module, capability)
+	if t.Poller != nil {
+		t.Poller.Invalidate()
+	}
+	if err == nil {
+		t.lastAction = capability
+		t.lastPower = state.Power
+		t.lastActionTime = time.Now()
+	}
+	return state, err
+}
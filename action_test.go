@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+func TestCheckAction(t *testing.T) {
+	type lastAction struct {
+		capability modules.Capability
+		power      modules.PowerState
+		age        time.Duration
+	}
+	tests := []struct {
+		name       string
+		capability modules.Capability
+		power      modules.PowerState
+		last       *lastAction
+		want       error
+	}{
+		{"power on while off", modules.CapabilityPowerOn, modules.PowerOff, nil, nil},
+		{"power on while sleeping", modules.CapabilityPowerOn, modules.PowerSleeping, nil, nil},
+		{"power on while unknown", modules.CapabilityPowerOn, modules.PowerUnknown, nil, nil},
+		{"power on while on", modules.CapabilityPowerOn, modules.PowerOn, nil, errActionSkipped},
+		{"power on while powering on", modules.CapabilityPowerOn, modules.PoweringOn, nil, errActionSkipped},
+		{"power on while powering off", modules.CapabilityPowerOn, modules.PoweringOff, nil, errActionConflict},
+		{"power off while on", modules.CapabilityPowerOff, modules.PowerOn, nil, nil},
+		{"power off while powering on", modules.CapabilityPowerOff, modules.PoweringOn, nil, nil},
+		{"power off while off", modules.CapabilityPowerOff, modules.PowerOff, nil, errActionSkipped},
+		{"power off while sleeping", modules.CapabilityPowerOff, modules.PowerSleeping, nil, errActionSkipped},
+		{"power off while powering off", modules.CapabilityPowerOff, modules.PoweringOff, nil, errActionSkipped},
+		{"force off while on", modules.CapabilityForceOff, modules.PowerOn, nil, nil},
+		{"force off while off", modules.CapabilityForceOff, modules.PowerOff, nil, errActionSkipped},
+		{"suspend while sleeping", modules.CapabilitySuspend, modules.PowerSleeping, nil, errActionSkipped},
+		{"nmi while on", modules.CapabilityNmi, modules.PowerOn, nil, nil},
+		{"nmi while unknown", modules.CapabilityNmi, modules.PowerUnknown, nil, nil},
+		{"nmi while off", modules.CapabilityNmi, modules.PowerOff, nil, errActionConflict},
+		{"force restart while sleeping", modules.CapabilityForceRestart, modules.PowerSleeping, nil, errActionConflict},
+		{"same action just performed", modules.CapabilityNmi, modules.PowerOn, &lastAction{modules.CapabilityNmi, modules.PowerOn, time.Second}, errActionSkipped},
+		{"same action performed a while ago", modules.CapabilityNmi, modules.PowerOn, &lastAction{modules.CapabilityNmi, modules.PowerOn, actionSettleDelay + time.Second}, nil},
+		{"same action since a state change", modules.CapabilityPowerOn, modules.PowerOff, &lastAction{modules.CapabilityPowerOn, modules.PowerSleeping, time.Second}, nil},
+		{"other action just performed", modules.CapabilityForceOff, modules.PowerOn, &lastAction{modules.CapabilityNmi, modules.PowerOn, time.Second}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &Target{Name: "test"}
+			if tt.last != nil {
+				target.lastAction = tt.last.capability
+				target.lastPower = tt.last.power
+				target.lastActionTime = time.Now().Add(-tt.last.age)
+			}
+			err := target.checkAction(tt.capability, &modules.State{Power: tt.power})
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Errorf("checkAction() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPerform(t *testing.T) {
+	type step struct {
+		capability modules.Capability
+		want       error
+	}
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		steps  []step
+	}{
+		{
+			name:   "switched on",
+			config: nil,
+			steps: []step{
+				{modules.CapabilityPowerOn, nil},
+				{modules.CapabilityPowerOn, errActionSkipped},
+				{modules.CapabilityPowerOff, nil},
+				{modules.CapabilityPowerOff, errActionSkipped},
+			},
+		},
+		{
+			name:   "booting",
+			config: map[string]interface{}{"boot-delay": "1h"},
+			steps: []step{
+				{modules.CapabilityPowerOn, nil},
+				{modules.CapabilityPowerOn, errActionSkipped},
+				{modules.CapabilityPowerOff, nil},
+			},
+		},
+		{
+			name:   "shutting down",
+			config: map[string]interface{}{"on": true, "shutdown-delay": "1h"},
+			steps: []step{
+				{modules.CapabilityPowerOff, nil},
+				{modules.CapabilityPowerOn, errActionConflict},
+				{modules.CapabilityPowerOff, errActionSkipped},
+			},
+		},
+		{
+			name:   "sleeping",
+			config: map[string]interface{}{"on": true},
+			steps: []step{
+				{modules.CapabilitySuspend, nil},
+				{modules.CapabilityHibernate, errActionSkipped},
+				{modules.CapabilityNmi, errActionConflict},
+				{modules.CapabilityPowerOn, nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polling := PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour}
+			target := newTestTarget(t, newSimModule(t, tt.config), &polling, testTimeouts(0))
+			for i, step := range tt.steps {
+				_, err := target.Perform(testContext(t), testTimeouts(0), step.capability)
+				if step.want == nil && err != nil || !errors.Is(err, step.want) {
+					t.Errorf("step %d: Perform(%s) error = %v, want %v", i, step.capability, err, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPerformLock(t *testing.T) {
+	gate := make(chan struct{})
+	module := newTestModule(modules.PowerOn, true)
+	module.actionGate = gate
+	polling := PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour}
+	target := newTestTarget(t, module, &polling, testTimeouts(0))
+
+	first := make(chan error)
+	go func() {
+		_, err := target.Perform(testContext(t), testTimeouts(0), modules.CapabilityNmi)
+		first <- err
+	}()
+	waitUntil(t, "the first action is performed", func() bool { return len(module.receivedActions()) == 1 })
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := target.Perform(ctx, testTimeouts(0), modules.CapabilityForceRestart)
+		if err == nil || !strings.HasPrefix(err.Error(), "error waiting for the previous action") || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Perform() error = %v, want a deadline error while waiting", err)
+		}
+	})
+
+	t.Run("performed after the previous action", func(t *testing.T) {
+		second := make(chan error)
+		go func() {
+			_, err := target.Perform(testContext(t), testTimeouts(0), modules.CapabilityForceRestart)
+			second <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if actions := module.receivedActions(); len(actions) != 1 {
+			t.Fatalf("actions = %v, want the second action to wait for the first one", actions)
+		}
+
+		close(gate)
+		if err := <-first; err != nil {
+			t.Errorf("first Perform() error = %v", err)
+		}
+		if err := <-second; err != nil {
+			t.Errorf("second Perform() error = %v", err)
+		}
+		want := []modules.Capability{modules.CapabilityNmi, modules.CapabilityForceRestart}
+		if actions := module.receivedActions(); !slices.Equal(actions, want) {
+			t.Errorf("actions = %v, want %v", actions, want)
+		}
+	})
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	osexec "os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tr4cks/power/modules/agent"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/cobra"
+)
+
+type AgentConfig struct {
+	Address  string
+	Secret   string
+	Tls      *AgentTlsConfig
+	Commands AgentCommandsConfig
+}
+
+type AgentTlsConfig struct {
+	CertFile     string `yaml:"cert-file" validate:"required"`
+	KeyFile      string `yaml:"key-file" validate:"required"`
+	ClientCaFile string `yaml:"client-ca-file"`
+}
+
+type AgentCommandsConfig struct {
+	Shutdown  string
+	Suspend   string
+	Hibernate string
+	Reboot    string
+}
+
+func (c *AgentCommandsConfig) command(action agent.Action) string {
+	switch action {
+	case agent.ActionShutdown:
+		return c.Shutdown
+	case agent.ActionSuspend:
+		return c.Suspend
+	case agent.ActionHibernate:
+		return c.Hibernate
+	case agent.ActionReboot:
+		return c.Reboot
+	}
+	return ""
+}
+
+func parseAgentConfigFile(filePath string) *AgentConfig {
+	config, err := parseYAMLFile[AgentConfig](filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse YAML file %q: %s\n", filePath, err)
+		os.Exit(1)
+	}
+
+	validate := validator.New()
+	err = validate.Struct(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error during configuration validation: %s\n", err)
+		os.Exit(1)
+	}
+	if config.Secret == "" && (config.Tls == nil || config.Tls.ClientCaFile == "") {
+		fmt.Fprintln(os.Stderr, "Error during configuration validation: either a secret or a client CA file is required to authenticate the requests")
+		os.Exit(1)
+	}
+
+	if config.Address == "" {
+		config.Address = ":8009"
+	}
+	if config.Commands.Shutdown == "" {
+		config.Commands.Shutdown = "systemctl poweroff"
+	}
+	if config.Commands.Suspend == "" {
+		config.Commands.Suspend = "systemctl suspend"
+	}
+	if config.Commands.Hibernate == "" {
+		config.Commands.Hibernate = "systemctl hibernate"
+	}
+	if config.Commands.Reboot == "" {
+		config.Commands.Reboot = "systemctl reboot"
+	}
+
+	return config
+}
+
+// maxSignedBodySize bounds the size of the bodies of the requests sent to the
+// agent, which are read before their signature is verified.
+const maxSignedBodySize = 64 << 10
+
+// SignatureMiddleware rejects the requests that aren't signed with the shared
+// secret, and the replayed ones.
+func SignatureMiddleware(secret string) gin.HandlerFunc {
+	nonces := agent.NewNonceCache()
+	return func(c *gin.Context) {
+		body, err := readBody(c, maxSignedBodySize)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+					"status": "ko",
+					"error":  fmt.Sprintf("the body of the request is larger than %d bytes", maxSignedBodySize),
+				})
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		nonce := c.GetHeader(agent.NonceHeader)
+		err = agent.Verify(secret, c.Request.Method, c.Request.URL.Path,
+			c.GetHeader(agent.TimestampHeader), nonce, body, c.GetHeader(agent.SignatureHeader))
+		if err == nil && !nonces.Use(nonce, time.Now()) {
+			err = fmt.Errorf("nonce %q already used", nonce)
+		}
+		if err != nil {
+			c.Error(fmt.Errorf("rejected request: %w", err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"status": "ko",
+				"error":  "invalid signature",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func readProcFile(name string) []string {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(content))
+}
+
+func readBootTime() *time.Time {
+	fields := readProcFile("/proc/uptime")
+	if len(fields) == 0 {
+		return nil
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil
+	}
+	bootTime := time.Now().Add(-time.Duration(uptime * float64(time.Second))).Truncate(time.Second)
+	return &bootTime
+}
+
+func readLoad() []float64 {
+	fields := readProcFile("/proc/loadavg")
+	if len(fields) < 3 {
+		return nil
+	}
+	load := make([]float64, 3)
+	for i := range load {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil
+		}
+		load[i] = value
+	}
+	return load
+}
+
+func readMemory() *agent.Memory {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Values are expressed in kB
+		values[strings.TrimSuffix(fields[0], ":")] = value * 1024
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return nil
+	}
+	return &agent.Memory{Total: total, Available: values["MemAvailable"]}
+}
+
+func agentStatus() agent.Status {
+	hostname, _ := os.Hostname()
+	return agent.Status{
+		Hostname: hostname,
+		Os:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Version:  rootCmd.Version,
+		BootTime: readBootTime(),
+		Load:     readLoad(),
+		Memory:   readMemory(),
+		Actions:  agent.Actions,
+	}
+}
+
+func runAgentServer(config *AgentConfig) *http.Server {
+	router := gin.New()
+	router.Use(loggerWithZerolog(&ginLogger))
+	router.Use(gin.Recovery())
+	router.SetTrustedProxies(nil)
+
+	api := router.Group("/api")
+	if config.Secret != "" {
+		api.Use(SignatureMiddleware(config.Secret))
+	}
+	{
+		api.GET("/status", func(c *gin.Context) {
+			c.JSON(http.StatusOK, agentStatus())
+		})
+
+		for _, action := range agent.Actions {
+			command := config.Commands.command(action)
+			api.POST("/"+string(action), func(c *gin.Context) {
+				// The command is started in the background, as the agent is
+				// likely to be stopped before it returns.
+				cmd := osexec.Command("sh", "-c", command)
+				err := cmd.Start()
+				if err != nil {
+					mainLogger.Error().Err(err).Str("action", string(action)).Msg("Unable to run the command")
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"status": "ko",
+						"error":  fmt.Sprintf("a problem occurred during %s", action),
+					})
+					return
+				}
+				go func() {
+					err := cmd.Wait()
+					if err != nil {
+						mainLogger.Error().Err(err).Str("action", string(action)).Msg("Command failed")
+					}
+				}()
+
+				c.JSON(http.StatusAccepted, gin.H{
+					"status": "ok",
+				})
+			})
+		}
+	}
+
+	srv := &http.Server{
+		Addr:    config.Address,
+		Handler: router,
+	}
+
+	if config.Tls != nil && config.Tls.ClientCaFile != "" {
+		ca, err := os.ReadFile(config.Tls.ClientCaFile)
+		if err != nil {
+			mainLogger.Fatal().Err(err).Msg("Unable to read the client CA file")
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(ca) {
+			mainLogger.Fatal().Msg(fmt.Sprintf("No certificate found in the client CA file %q", config.Tls.ClientCaFile))
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+
+	go func() {
+		var err error
+		if config.Tls != nil {
+			err = srv.ListenAndServeTLS(config.Tls.CertFile, config.Tls.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			mainLogger.Fatal().Err(err).Msg("An error occurred while starting the agent")
+		}
+	}()
+
+	return srv
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run the agent on the server to shut it down, suspend it or report its status",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configureLoggers()
+
+		config := parseAgentConfigFile(configFilePath)
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		srv := runAgentServer(config)
+		mainLogger.Info().Msg(fmt.Sprintf("Agent listening on %s", config.Address))
+
+		<-ctx.Done()
+
+		stop()
+		mainLogger.Info().Msg("Shutting down gracefully, press Ctrl+C again to force")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			mainLogger.Fatal().Err(err).Msg("Agent forced to shutdown")
+		}
+
+		mainLogger.Info().Msg("Agent exiting")
+	},
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -15,8 +18,14 @@ import (
 )
 
 type DiscordBot struct {
-	config *DiscordBotConfig
-	module modules.Module
+	config   *DiscordBotConfig
+	timeouts *TimeoutsConfig
+	targets  Targets
+
+	// ctx is canceled when the bot is stopped, which interrupts the module
+	// operations still running
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	logger             zerolog.Logger
 	session            *discordgo.Session
@@ -36,13 +45,19 @@ func (d *DiscordBot) Start() error {
 	}
 
 	d.logger.Info().Msg("Adding commands...")
-	registeredCommands := make([]*discordgo.ApplicationCommand, len(commands))
-	for i, v := range commands {
-		cmd, err := d.session.ApplicationCommandCreate(d.session.State.User.ID, d.config.GuildId, v)
+	registeredCommands := make([]*discordgo.ApplicationCommand, 0, len(commands))
+	capabilities := d.targets.Capabilities()
+	for _, v := range commands {
+		// The commands performing an action supported by none of the
+		// servers aren't offered
+		if capability, ok := commandCapabilities[v.Name]; ok && !capabilities.Has(capability) {
+			continue
+		}
+		cmd, err := d.session.ApplicationCommandCreate(d.session.State.User.ID, d.config.GuildId, d.withServerOption(v))
 		if err != nil {
 			d.logger.Panic().Err(err).Msg(fmt.Sprintf("Cannot create '%v' command: %v", v.Name, err))
 		}
-		registeredCommands[i] = cmd
+		registeredCommands = append(registeredCommands, cmd)
 	}
 	d.registeredCommands = registeredCommands
 
@@ -50,6 +65,8 @@ func (d *DiscordBot) Start() error {
 }
 
 func (d *DiscordBot) Stop() {
+	d.cancel()
+
 	d.logger.Info().Msg("Removing commands...")
 
 	for _, v := range d.registeredCommands {
@@ -68,8 +85,90 @@ func (d *DiscordBot) Stop() {
 	d.logger.Info().Msg("Gracefully shutting down")
 }
 
+// withServerOption adds the option selecting the server to a command, when
+// several servers are managed.
+func (d *DiscordBot) withServerOption(command *discordgo.ApplicationCommand) *discordgo.ApplicationCommand {
+	if len(d.targets) < 2 {
+		return command
+	}
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(d.targets))
+	for _, target := range d.targets {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: target.Name, Value: target.Name})
+	}
+	withOption := *command
+	withOption.Options = append(withOption.Options, &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "server",
+		Description: fmt.Sprintf("Server targeted by the command (%s by default)", d.targets[0].Name),
+		Choices:     choices,
+	})
+	return &withOption
+}
+
+// target returns the server selected by the server option of the command, or
+// the default one when the option isn't set.
+func (d *DiscordBot) target(i *discordgo.InteractionCreate) *Target {
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name != "server" {
+			continue
+		}
+		if target := d.targets.Find(option.StringValue()); target != nil {
+			return target
+		}
+	}
+	return d.targets[0]
+}
+
+// interactionLogger returns the logger of an interaction with a server.
+func (d *DiscordBot) interactionLogger(i *discordgo.InteractionCreate, target *Target) zerolog.Logger {
+	return d.logger.With().Str("username", i.Member.User.Username).Str("server", target.Name).Logger()
+}
+
+// stateMessages contains the message describing each power state.
+var stateMessages = map[modules.PowerState]string{
+	modules.PowerOn:       "🌞 Server is awake!",
+	modules.PowerOff:      "💤 Server is asleep!",
+	modules.PoweringOn:    "🌅 Server is waking up!",
+	modules.PoweringOff:   "🌇 Server is going to bed!",
+	modules.PowerSleeping: "🌙 Server is in sleep mode!",
+}
+
+// describeState returns the message describing a known server state.
+func describeState(state *modules.State) string {
+	message := stateMessages[state.Power]
+	if state.Power == modules.PowerOn && state.Reachable != nil && !*state.Reachable {
+		message += " It isn't reachable yet"
+	}
+	if wattage, ok := state.Attributes["wattage"].(float64); ok {
+		message += fmt.Sprintf(" (⚡ %.1f W)", wattage)
+	}
+	return message
+}
+
+// checkCapability answers the interaction with an ephemeral message when the
+// module doesn't support capability.
+func (d *DiscordBot) checkCapability(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zerolog.Logger, target *Target, capability modules.Capability) bool {
+	if target.Module.Capabilities().Has(capability) {
+		return true
+	}
+
+	logger.Info().Msg(fmt.Sprintf("The %s action is not supported by the module", capability))
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: fmt.Sprintf("🚫 The %s action is not supported for this server", capability),
+		},
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to send interaction response")
+	}
+	return false
+}
+
 func (d *DiscordBot) serverStatusHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	logger := d.logger.With().Str("username", i.Member.User.Username).Logger()
+	target := d.target(i)
+	logger := d.interactionLogger(i, target)
 	logger.Info().Msg("A user tries to check the server status")
 
 	sendFollowup := func(content string) {
@@ -94,31 +193,22 @@ func (d *DiscordBot) serverStatusHandler(s *discordgo.Session, i *discordgo.Inte
 		return
 	}
 
-	powerState, ledState := d.module.State()
-
-	for _, state := range []struct {
-		err error
-		msg string
-	}{
-		{powerState.Err, "Failed to retrieve POWER state"},
-		{ledState.Err, "Failed to retrieve LED state"},
-	} {
-		if state.err != nil {
-			logger.Error().Err(state.err).Msg(state.msg)
-			sendFollowup("❌ Oops! Something went wrong while starting the server")
-			return
-		}
+	state := target.Poller.State(d.ctx)
+	if state.Power == modules.PowerUnknown {
+		sendFollowup("❌ Oops! Something went wrong while retrieving the server state")
+		return
 	}
-
-	if powerState.Value || ledState.Value {
-		sendFollowup("🌞 Server is awake!")
-	} else {
-		sendFollowup("💤 Server is asleep!")
+	message := describeState(&state)
+	if len(d.targets) > 1 {
+		message = fmt.Sprintf("**%s**: %s", target.Name, message)
 	}
+	sendFollowup(message)
 }
 
-func (d *DiscordBot) monitorServerStartup(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	logger := d.logger.With().Str("username", i.Member.User.Username).Logger()
+// monitorServerStartup notifies the user once the server has been brought to
+// the switched on state by reconciliation, or once it gave up.
+func (d *DiscordBot) monitorServerStartup(s *discordgo.Session, i *discordgo.InteractionCreate, target *Target, reconciliation *Reconciliation) {
+	logger := d.interactionLogger(i, target)
 	logger.Info().Msg("Monitoring server startup...")
 
 	sendFollowup := func(content string) {
@@ -185,44 +275,32 @@ func (d *DiscordBot) monitorServerStartup(s *discordgo.Session, i *discordgo.Int
 		}
 	}
 
-	intervals, err := modules.GenerateLogarithmicIntervals(3*time.Minute, 5*time.Second, 40*time.Second, 1.5, 1.5)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to generate intervals for server monitoring")
+	select {
+	case <-reconciliation.Done():
+	case <-d.ctx.Done():
+		logger.Info().Msg("Server monitoring interrupted")
 		return
 	}
 
-	start := time.Now()
-
-	for _, interval := range intervals {
-		logger.Debug().
-			Dur("elapsed_time", time.Since(start).Round(time.Second)).
-			Dur("next_interval", interval.Round(time.Second)).
-			Msg("Waiting before next server check")
-
-		<-time.After(interval)
-
-		powerState, ledState := d.module.State()
-		if powerState.Err != nil || ledState.Err != nil {
-			logger.Error().Msg("Failed to retrieve server state during monitoring")
-			continue
-		}
-
-		if powerState.Value && ledState.Value {
-			elapsedDuration := time.Since(start).Round(time.Second)
-			logger.Info().Msgf("Server successfully started after %s", elapsedDuration)
-			msg := getStartupMessage()
-			err := sendDM(msg)
-			if err != nil {
-				logger.Error().Err(err).Msg("Failed to send DM to the user")
-				sendFollowup(msg)
-			}
-			return
+	state := reconciliation.State()
+	switch state.Status {
+	case ReconcileReached:
+		logger.Info().Msgf("Server successfully started after %s", state.UpdatedAt.Sub(state.SetAt).Round(time.Second))
+		msg := getStartupMessage()
+		err := sendDM(msg)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to send DM to the user")
+			sendFollowup(msg)
 		}
+		return
+	case ReconcileCanceled:
+		logger.Info().Msg("Server monitoring interrupted by another desired state")
+		return
 	}
 
-	logger.Warn().Msg("Server did not start within the timeout period")
+	logger.Warn().Str("error", state.Error).Msg("Server did not start within the timeout period")
 	msg := fmt.Sprintf("😅 %s, the server is taking longer than usual. Please check it manually", getPrettyName())
-	err = sendDM(msg)
+	err := sendDM(msg)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to send DM to the user")
 		sendFollowup(msg)
@@ -230,9 +308,14 @@ func (d *DiscordBot) monitorServerStartup(s *discordgo.Session, i *discordgo.Int
 }
 
 func (d *DiscordBot) powerOnHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	logger := d.logger.With().Str("username", i.Member.User.Username).Logger()
+	target := d.target(i)
+	logger := d.interactionLogger(i, target)
 	logger.Info().Msg("A user attempts to switch on the server")
 
+	if !d.checkCapability(s, i, &logger, target, modules.CapabilityPowerOn) {
+		return
+	}
+
 	sendFollowup := func(content string) {
 		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Flags:   discordgo.MessageFlagsEphemeral,
@@ -255,29 +338,21 @@ func (d *DiscordBot) powerOnHandler(s *discordgo.Session, i *discordgo.Interacti
 		return
 	}
 
-	powerState, ledState := d.module.State()
-
-	for _, state := range []struct {
-		err error
-		msg string
-	}{
-		{powerState.Err, "Failed to retrieve POWER state"},
-		{ledState.Err, "Failed to retrieve LED state"},
-	} {
-		if state.err != nil {
-			logger.Error().Err(state.err).Msg(state.msg)
-			sendFollowup("❌ Oops! Something went wrong while starting the server")
-			return
+	state, err := target.Perform(d.ctx, d.timeouts, modules.CapabilityPowerOn)
+	if errors.Is(err, errActionSkipped) {
+		logger.Info().Err(err).Msg("The server is already switched on")
+		if state.Power == modules.PowerOn {
+			sendFollowup("✅ The server is already running!")
+		} else {
+			sendFollowup("✅ The server is already waking up!")
 		}
+		return
 	}
-
-	if powerState.Value || ledState.Value {
-		logger.Info().Msg("The server is already switched on")
-		sendFollowup("✅ The server is already running!")
+	if errors.Is(err, errActionConflict) {
+		logger.Info().Msg("The server is shutting down")
+		sendFollowup("⏳ The server is shutting down! Try again once it is stopped")
 		return
 	}
-
-	err = d.module.PowerOn()
 	if err != nil {
 		logger.Error().Err(err).Msg("A problem occurred when switching on the server")
 		sendFollowup("❌ Oops! Something went wrong while starting the server")
@@ -286,13 +361,20 @@ func (d *DiscordBot) powerOnHandler(s *discordgo.Session, i *discordgo.Interacti
 	logger.Info().Msg("Server switched on")
 	sendFollowup("✨ The server is waking up! It’ll be ready soon")
 
-	go d.monitorServerStartup(s, i)
+	// The power-on action is performed again when the server doesn't start
+	reconciliation := target.Reconciler.Set(modules.PowerOn)
+	go d.monitorServerStartup(s, i, target, reconciliation)
 }
 
 func (d *DiscordBot) powerOffHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	logger := d.logger.With().Str("username", i.Member.User.Username).Logger()
+	target := d.target(i)
+	logger := d.interactionLogger(i, target)
 	logger.Info().Msg("A user attempts to switch off the server")
 
+	if !d.checkCapability(s, i, &logger, target, modules.CapabilityPowerOff) {
+		return
+	}
+
 	sendFollowup := func(content string) {
 		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Flags:   discordgo.MessageFlagsEphemeral,
@@ -315,29 +397,16 @@ func (d *DiscordBot) powerOffHandler(s *discordgo.Session, i *discordgo.Interact
 		return
 	}
 
-	powerState, ledState := d.module.State()
-
-	for _, state := range []struct {
-		err error
-		msg string
-	}{
-		{powerState.Err, "Failed to retrieve POWER state"},
-		{ledState.Err, "Failed to retrieve LED state"},
-	} {
-		if state.err != nil {
-			logger.Error().Err(state.err).Msg(state.msg)
-			sendFollowup("❌ Oops! Something went wrong while stopping the server")
-			return
+	state, err := target.Perform(d.ctx, d.timeouts, modules.CapabilityPowerOff)
+	if errors.Is(err, errActionSkipped) {
+		logger.Info().Err(err).Msg("The server is already switched off")
+		if state.Power == modules.PowerOff || state.Power == modules.PowerSleeping {
+			sendFollowup("✅ The server is already stopped!")
+		} else {
+			sendFollowup("✅ The server is already shutting down!")
 		}
-	}
-
-	if !powerState.Value && !ledState.Value {
-		logger.Info().Msg("The server is already switched off")
-		sendFollowup("✅ The server is already stopped!")
 		return
 	}
-
-	err = d.module.PowerOff()
 	if err != nil {
 		logger.Error().Err(err).Msg("A problem occurred when switching off the server")
 		sendFollowup("❌ Oops! Something went wrong while stopping the server")
@@ -347,6 +416,94 @@ func (d *DiscordBot) powerOffHandler(s *discordgo.Session, i *discordgo.Interact
 	sendFollowup("🛌 The server is shutting down!")
 }
 
+// actionMessages contains the message sent once each action other than
+// power-on and power-off has been requested.
+var actionMessages = map[modules.Capability]string{
+	modules.CapabilitySuspend:          "🌙 The server is falling asleep! Use /power_on to wake it up",
+	modules.CapabilityHibernate:        "🌙 The server is falling asleep! Use /power_on to wake it up",
+	modules.CapabilityForceOff:         "🛌 The server has been switched off!",
+	modules.CapabilityGracefulShutdown: "🛌 The server is shutting down!",
+	modules.CapabilityForceRestart:     "🔄 The server is restarting!",
+	modules.CapabilityPowerCycle:       "🔄 The server is being power cycled!",
+	modules.CapabilityPressAndHold:     "🛌 The power button is being held down!",
+	modules.CapabilityNmi:              "⚡ The NMI has been sent to the server!",
+}
+
+// actionHandler creates the handler of a command performing an action other
+// than power-on and power-off.
+func (d *DiscordBot) actionHandler(capability modules.Capability) func(*discordgo.Session, *discordgo.InteractionCreate) {
+	action := string(capability)
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		target := d.target(i)
+		logger := d.interactionLogger(i, target)
+		logger.Info().Msg(fmt.Sprintf("A user attempts to %s the server", action))
+
+		if !d.checkCapability(s, i, &logger, target, capability) {
+			return
+		}
+
+		sendFollowup := func(content string) {
+			_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Flags:   discordgo.MessageFlagsEphemeral,
+				Content: content,
+			})
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to send follow-up message")
+			}
+		}
+
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   discordgo.MessageFlagsEphemeral,
+				Content: "⏳ Connecting to the server… Please wait",
+			},
+		})
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to send interaction response")
+			return
+		}
+
+		state, err := target.Perform(d.ctx, d.timeouts, capability)
+		if errors.Is(err, errActionSkipped) {
+			logger.Info().Err(err).Msg(fmt.Sprintf("The server doesn't need to %s", action))
+			if state.Power.Powered() {
+				// The same action has just been performed
+				sendFollowup(actionMessages[capability])
+			} else {
+				sendFollowup("✅ The server is already stopped!")
+			}
+			return
+		}
+		if errors.Is(err, errActionConflict) {
+			logger.Info().Err(err).Msg("The server is switched off or sleeping")
+			sendFollowup("💤 The server is stopped! Use /power_on to start it")
+			return
+		}
+		if errors.Is(err, modules.ErrNotSupported) {
+			logger.Info().Msg(fmt.Sprintf("The %s action is not supported by the module", action))
+			sendFollowup(fmt.Sprintf("🚫 The %s action is not supported for this server", action))
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("A problem occurred when trying to %s the server", action))
+			sendFollowup(fmt.Sprintf("❌ Oops! Something went wrong while trying to %s the server", action))
+			return
+		}
+		logger.Info().Msg(fmt.Sprintf("Server %s requested", action))
+		sendFollowup(actionMessages[capability])
+	}
+}
+
+// commandCapabilities contains the capability required by each command
+// performing an action.
+var commandCapabilities = map[string]modules.Capability{
+	"power_on":  modules.CapabilityPowerOn,
+	"power_off": modules.CapabilityPowerOff,
+	"suspend":   modules.CapabilitySuspend,
+	"hibernate": modules.CapabilityHibernate,
+}
+
 var commands = []*discordgo.ApplicationCommand{
 	{
 		Name:        "server_status",
@@ -364,9 +521,44 @@ var commands = []*discordgo.ApplicationCommand{
 			return &perms
 		}(),
 	},
+	{
+		Name:        "suspend",
+		Description: "Suspends the server to memory",
+		DefaultMemberPermissions: func() *int64 {
+			perms := int64(discordgo.PermissionAdministrator)
+			return &perms
+		}(),
+	},
+	{
+		Name:        "hibernate",
+		Description: "Hibernates the server to disk",
+		DefaultMemberPermissions: func() *int64 {
+			perms := int64(discordgo.PermissionAdministrator)
+			return &perms
+		}(),
+	},
 }
 
-func NewDiscordBot(config *DiscordBotConfig, module modules.Module) (*DiscordBot, error) {
+// commandName returns the name of the command performing an extended action.
+func commandName(capability modules.Capability) string {
+	return strings.ReplaceAll(string(capability), "-", "_")
+}
+
+func init() {
+	for _, action := range modules.ExtendedActions {
+		commandCapabilities[commandName(action)] = action
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        commandName(action),
+			Description: actionDescriptions[action],
+			DefaultMemberPermissions: func() *int64 {
+				perms := int64(discordgo.PermissionAdministrator)
+				return &perms
+			}(),
+		})
+	}
+}
+
+func NewDiscordBot(config *DiscordBotConfig, timeouts *TimeoutsConfig, targets Targets) (*DiscordBot, error) {
 	var outputWriter io.Writer = os.Stderr
 	if gin.Mode() != "release" {
 		outputWriter = zerolog.ConsoleWriter{Out: os.Stderr}
@@ -383,12 +575,18 @@ func NewDiscordBot(config *DiscordBotConfig, module modules.Module) (*DiscordBot
 		return nil, fmt.Errorf("invalid bot parameters: %w", err)
 	}
 
-	bot := &DiscordBot{config, module, logger, session, nil}
+	ctx, cancel := context.WithCancel(context.Background())
+	bot := &DiscordBot{config, timeouts, targets, ctx, cancel, logger, session, nil}
 
 	commandHandlers := map[string]func(*discordgo.Session, *discordgo.InteractionCreate){
 		"server_status": bot.serverStatusHandler,
 		"power_on":      bot.powerOnHandler,
 		"power_off":     bot.powerOffHandler,
+		"suspend":       bot.actionHandler(modules.CapabilitySuspend),
+		"hibernate":     bot.actionHandler(modules.CapabilityHibernate),
+	}
+	for _, action := range modules.ExtendedActions {
+		commandHandlers[commandName(action)] = bot.actionHandler(action)
 	}
 
 	session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
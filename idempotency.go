@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyKeyLifetime is the duration during which the response to a
+// request is replayed to the requests sent with the same idempotency key.
+const idempotencyKeyLifetime = 24 * time.Hour
+
+// maxIdempotencyKeyLength bounds the length of the idempotency keys.
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotencyKeys bounds the number of responses kept by the store.
+const maxIdempotencyKeys = 10000
+
+// maxIdempotentBodySize bounds the size of the bodies of the requests sent
+// with an idempotency key, which are read to compute their fingerprint.
+const maxIdempotentBodySize = 1 << 20
+
+// errIdempotencyStoreFull is returned when every key of the store belongs to
+// a request still running, so that no response can be evicted.
+var errIdempotencyStoreFull = errors.New("too many requests with an idempotency key are running")
+
+// idempotentResponse is the response to the first request sent with an
+// idempotency key. done is closed once the response has been recorded.
+// fingerprint identifies the route and the body of the request.
+type idempotentResponse struct {
+	fingerprint string
+	createdAt   time.Time
+	done        chan struct{}
+	kept        bool
+	status      int
+	contentType string
+	body        []byte
+}
+
+// IdempotencyStore records the responses to the requests sent with an
+// idempotency key.
+type IdempotencyStore struct {
+	mutex     sync.Mutex
+	responses map[string]*idempotentResponse
+	// capacity is the maximum number of keys, the oldest recorded response
+	// is evicted to make room for a new key
+	capacity int
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{responses: make(map[string]*idempotentResponse), capacity: maxIdempotencyKeys}
+}
+
+// begin returns the response recorded for key, or registers the request
+// identified by fingerprint as the first one sent with key, in which case
+// first is true.
+func (s *IdempotencyStore) begin(key string, fingerprint string) (response *idempotentResponse, first bool, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var oldest string
+	for k, response := range s.responses {
+		if time.Since(response.createdAt) > idempotencyKeyLifetime {
+			delete(s.responses, k)
+			continue
+		}
+		// The requests still running can't be evicted
+		if response.kept && (oldest == "" || response.createdAt.Before(s.responses[oldest].createdAt)) {
+			oldest = k
+		}
+	}
+
+	if response, ok := s.responses[key]; ok {
+		return response, false, nil
+	}
+	if len(s.responses) >= s.capacity {
+		if oldest == "" {
+			return nil, false, errIdempotencyStoreFull
+		}
+		delete(s.responses, oldest)
+	}
+	response = &idempotentResponse{fingerprint: fingerprint, createdAt: time.Now(), done: make(chan struct{})}
+	s.responses[key] = response
+	return response, true, nil
+}
+
+// finish records the response to the first request sent with key. The
+// response isn't kept when the request has been rejected before reaching the
+// module, so that it can be sent again once fixed.
+func (s *IdempotencyStore) finish(key string, response *idempotentResponse, status int, contentType string, body []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if status >= 400 && status < 500 {
+		delete(s.responses, key)
+	} else {
+		response.kept = true
+		response.status = status
+		response.contentType = contentType
+		response.body = body
+	}
+	close(response.done)
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotentReplayInFlight(t *testing.T) {
+	type request struct {
+		key  string
+		body string
+	}
+	tests := []struct {
+		name string
+		// firstStatus is the status of the response to the first request
+		firstStatus int
+		second      request
+		wantStatus  int
+		// wantReplayed tells whether the second request waits for the
+		// response to the first one, and gets it replayed
+		wantReplayed bool
+		wantHandled  int32
+	}{
+		{"replayed once answered", http.StatusOK, request{"k1", `{"power":"on"}`}, http.StatusOK, true, 1},
+		{"replayed server error", http.StatusInternalServerError, request{"k1", `{"power":"on"}`}, http.StatusInternalServerError, true, 1},
+		{"handled after a rejection", http.StatusConflict, request{"k1", `{"power":"on"}`}, http.StatusOK, false, 2},
+		{"other body", http.StatusOK, request{"k1", `{"power":"off"}`}, http.StatusUnprocessableEntity, false, 1},
+		{"other key", http.StatusOK, request{"k2", `{"power":"on"}`}, http.StatusOK, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := make(chan struct{})
+			var handled atomic.Int32
+			router := gin.New()
+			router.POST("/action", IdempotencyMiddleware(NewIdempotencyStore()), func(c *gin.Context) {
+				// Only the first request is held until the second one is sent
+				if handled.Add(1) == 1 {
+					<-gate
+					c.JSON(tt.firstStatus, gin.H{"request": 1})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"request": 2})
+			})
+
+			first := make(chan int)
+			go func() {
+				first <- sendRequest(router, "POST", "/action", "k1", `{"power":"on"}`, false).Code
+			}()
+			waitUntil(t, "the first request is handled", func() bool { return handled.Load() == 1 })
+
+			second := make(chan *httptest.ResponseRecorder)
+			go func() {
+				second <- sendRequest(router, "POST", "/action", tt.second.key, tt.second.body, false)
+			}()
+			// Let the second request reach the store before the first one is
+			// answered
+			time.Sleep(50 * time.Millisecond)
+			close(gate)
+
+			if status := <-first; status != tt.firstStatus {
+				t.Errorf("first request: status = %d, want %d", status, tt.firstStatus)
+			}
+			recorder := <-second
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("second request: status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			replayed := recorder.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tt.wantReplayed {
+				t.Errorf("second request: replayed = %t, want %t", replayed, tt.wantReplayed)
+			}
+			if replayed && !strings.Contains(recorder.Body.String(), `"request":1`) {
+				t.Errorf("second request: body = %s, want the response to the first request", recorder.Body)
+			}
+			if got := handled.Load(); got != tt.wantHandled {
+				t.Errorf("handled %d requests, want %d", got, tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestIdempotencyStoreLifetime(t *testing.T) {
+	tests := []struct {
+		name      string
+		age       time.Duration
+		wantFirst bool
+	}{
+		{"recent", time.Hour, false},
+		{"expired", idempotencyKeyLifetime + time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewIdempotencyStore()
+			response, _, err := store.begin("k1", "POST /up")
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.finish("k1", response, http.StatusOK, "application/json", nil)
+			response.createdAt = time.Now().Add(-tt.age)
+
+			if _, first, err := store.begin("k1", "POST /up"); err != nil || first != tt.wantFirst {
+				t.Errorf("begin() = %t %v, want %t", first, err, tt.wantFirst)
+			}
+		})
+	}
+}
@@ -4,21 +4,37 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/amt"
+	"github.com/tr4cks/power/modules/composite"
+	"github.com/tr4cks/power/modules/docker"
+	"github.com/tr4cks/power/modules/exec"
+	"github.com/tr4cks/power/modules/homeassistant"
 	"github.com/tr4cks/power/modules/ilo"
+	"github.com/tr4cks/power/modules/ipmi"
+	"github.com/tr4cks/power/modules/mqtt"
+	"github.com/tr4cks/power/modules/plug"
+	"github.com/tr4cks/power/modules/plugin"
+	"github.com/tr4cks/power/modules/proxmox"
+	"github.com/tr4cks/power/modules/redfish"
+	"github.com/tr4cks/power/modules/sim"
+	"github.com/tr4cks/power/modules/snmppdu"
+	"github.com/tr4cks/power/modules/ssh"
+	"github.com/tr4cks/power/modules/vsphere"
 	"github.com/tr4cks/power/modules/wakeonlan"
 
 	"github.com/gin-gonic/gin"
@@ -29,8 +45,9 @@ import (
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&configFilePath, "config", path.Join("/etc", fmt.Sprintf("%s.d", appName), "config.yaml"), "YAML configuration file")
-	rootCmd.PersistentFlags().StringVarP(&moduleName, "module", "m", "", "module for switching the server on or off")
-	rootCmd.MarkPersistentFlagRequired("module")
+	rootCmd.PersistentFlags().StringVarP(&moduleName, "module", "m", "", "module for switching the server on or off, unless the configuration file has a servers list")
+	rootCmd.PersistentFlags().StringVar(&pluginDir, "plugin-dir", path.Join("/usr/local/lib", appName, "plugins"), "directory of the external modules")
+	rootCmd.PersistentFlags().StringVarP(&targetName, "target", "t", "", "server of the servers list targeted by the command, the first one by default")
 }
 
 const appName = "power"
@@ -38,6 +55,8 @@ const appName = "power"
 var (
 	configFilePath string
 	moduleName     string
+	pluginDir      string
+	targetName     string
 	rootCmd        = &cobra.Command{
 		Use:     appName,
 		Short:   "All-in-one tool for remote server power control",
@@ -54,16 +73,98 @@ type Config struct {
 	Username string `validate:"required"`
 	Password string `validate:"required"`
 	Module   map[string]interface{}
+	// Servers replaces the module flag and the module field to manage several
+	// servers
+	Servers  []ServerConfig `validate:"unique=Name,dive"`
 	Discord  *DiscordBotConfig
+	Timeouts TimeoutsConfig
+	Polling  PollingConfig
 }
 
-func parseYAMLFile(filePath string) (*Config, error) {
+// TimeoutsConfig contains the maximum duration of the module operations. The
+// operations still running once their timeout is reached are canceled.
+type TimeoutsConfig struct {
+	// State bounds the retrieval of the server state, including the wattage
+	// and the sleep state
+	State    time.Duration `validate:"gte=0"`
+	PowerOn  time.Duration `yaml:"power-on" validate:"gte=0"`
+	PowerOff time.Duration `yaml:"power-off" validate:"gte=0"`
+	// Actions bounds the other actions (suspend, hibernate, force-off, ...)
+	Actions time.Duration `validate:"gte=0"`
+	// Reconcile bounds the time given to a server to reach its desired state
+	Reconcile time.Duration `validate:"gte=0"`
+}
+
+var defaultTimeouts = TimeoutsConfig{
+	State:     30 * time.Second,
+	PowerOn:   2 * time.Minute,
+	PowerOff:  2 * time.Minute,
+	Actions:   2 * time.Minute,
+	Reconcile: 5 * time.Minute,
+}
+
+// applyDefaults replaces the unset timeouts with the default ones.
+func (t *TimeoutsConfig) applyDefaults() {
+	if t.State == 0 {
+		t.State = defaultTimeouts.State
+	}
+	if t.PowerOn == 0 {
+		t.PowerOn = defaultTimeouts.PowerOn
+	}
+	if t.PowerOff == 0 {
+		t.PowerOff = defaultTimeouts.PowerOff
+	}
+	if t.Actions == 0 {
+		t.Actions = defaultTimeouts.Actions
+	}
+	if t.Reconcile == 0 {
+		t.Reconcile = defaultTimeouts.Reconcile
+	}
+}
+
+// For returns the timeout of the operation performing capability.
+func (t *TimeoutsConfig) For(capability modules.Capability) time.Duration {
+	switch capability {
+	case modules.CapabilityPowerOn:
+		return t.PowerOn
+	case modules.CapabilityPowerOff:
+		return t.PowerOff
+	default:
+		return t.Actions
+	}
+}
+
+// PollingConfig contains the intervals at which the state of the servers is
+// refreshed in the background.
+type PollingConfig struct {
+	Interval time.Duration `validate:"gte=0"`
+	// TransitionInterval is used while a server is being switched on or off,
+	// and right after an action
+	TransitionInterval time.Duration `yaml:"transition-interval" validate:"gte=0"`
+}
+
+var defaultPolling = PollingConfig{
+	Interval:           30 * time.Second,
+	TransitionInterval: 3 * time.Second,
+}
+
+// applyDefaults replaces the unset intervals with the default ones.
+func (p *PollingConfig) applyDefaults() {
+	if p.Interval == 0 {
+		p.Interval = defaultPolling.Interval
+	}
+	if p.TransitionInterval == 0 {
+		p.TransitionInterval = defaultPolling.TransitionInterval
+	}
+}
+
+func parseYAMLFile[T any](filePath string) (*T, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
-	config := Config{}
+	var config T
 	decoder := yaml.NewDecoder(file)
 	err = decoder.Decode(&config)
 	if err != nil {
@@ -73,7 +174,7 @@ func parseYAMLFile(filePath string) (*Config, error) {
 }
 
 func parseConfigFile(filePath string) *Config {
-	config, err := parseYAMLFile(filePath)
+	config, err := parseYAMLFile[Config](filePath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to parse YAML file %q: %s\n", filePath, err)
 		os.Exit(1)
@@ -85,33 +186,58 @@ func parseConfigFile(filePath string) *Config {
 		fmt.Fprintf(os.Stderr, "Error during configuration validation: %s\n", err)
 		os.Exit(1)
 	}
+	config.Timeouts.applyDefaults()
+	config.Polling.applyDefaults()
 
 	return config
 }
 
-func createModule(config *Config, moduleName string) modules.Module {
-	internalModules := map[string]modules.Module{
-		"ilo": ilo.New(),
-		"wol": wakeonlan.New(),
+var internalModules = map[string]func() modules.Module{
+	"amt":           amt.New,
+	"docker":        docker.New,
+	"exec":          exec.New,
+	"homeassistant": homeassistant.New,
+	"ilo":           ilo.New,
+	"ipmi":          ipmi.New,
+	"mqtt":          mqtt.New,
+	"plug":          plug.New,
+	"proxmox":       proxmox.New,
+	"redfish":       redfish.New,
+	"sim":           sim.New,
+	"snmp-pdu":      snmppdu.New,
+	"ssh":           ssh.New,
+	"vsphere":       vsphere.New,
+	"wol":           wakeonlan.New,
+}
+
+func init() {
+	internalModules["composite"] = func() modules.Module {
+		return composite.New(availableModules())
 	}
+}
 
-	module, ok := internalModules[moduleName]
-	if !ok {
-		moduleNames := make([]string, 0, len(internalModules))
-		for moduleName := range internalModules {
-			moduleNames = append(moduleNames, moduleName)
-		}
-		fmt.Fprintf(os.Stderr, "Can't find the %q module among the internal modules (available modules: %s)\n", moduleName, strings.Join(moduleNames, ", "))
-		os.Exit(1)
+// availableModules returns the internal modules and the external modules
+// found in the plugin directory. Internal modules take precedence.
+func availableModules() map[string]func() modules.Module {
+	availableModules := make(map[string]func() modules.Module, len(internalModules))
+	for moduleName, newModule := range internalModules {
+		availableModules[moduleName] = newModule
 	}
 
-	err := module.Init(config.Module)
+	plugins, err := plugin.Discover(pluginDir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error during module initialization: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Unable to load the external modules: %s\n", err)
+	}
+	for moduleName, pluginPath := range plugins {
+		if _, ok := availableModules[moduleName]; ok {
+			continue
+		}
+		availableModules[moduleName] = func() modules.Module {
+			return plugin.New(pluginPath)
+		}
 	}
 
-	return module
+	return availableModules
 }
 
 func run(cmd *cobra.Command, args []string) {
@@ -119,15 +245,31 @@ func run(cmd *cobra.Command, args []string) {
 	configureLoggers()
 
 	config := parseConfigFile(configFilePath)
-	module := createModule(config, moduleName)
+	targets := createTargets(config)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	srv := runHttpServer(config, module)
+	// The module operations of the requests are canceled once the graceful
+	// shutdown times out, so that hanging calls don't outlive the server
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	// The state of the servers is refreshed, and their desired state
+	// reconciled, in the background until the HTTP server and the Discord bot
+	// are stopped
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	for _, target := range targets {
+		target.Poller = NewStatePoller(backgroundCtx, target, &config.Polling, config.Timeouts.State, &mainLogger)
+		target.Reconciler = NewReconciler(backgroundCtx, target, &config.Timeouts, &config.Polling, &mainLogger)
+		go target.Poller.Run()
+	}
+
+	srv := runHttpServer(requestsCtx, config, targets)
 
 	if config.Discord != nil {
-		discordBot, err := NewDiscordBot(config.Discord, module)
+		discordBot, err := NewDiscordBot(config.Discord, &config.Timeouts, targets)
 		if err != nil {
 			mainLogger.Fatal().Err(err).Msg("Unable to create discord bot")
 		}
@@ -149,6 +291,7 @@ func run(cmd *cobra.Command, args []string) {
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	context.AfterFunc(ctx, cancelRequests)
 	if err := srv.Shutdown(ctx); err != nil {
 		mainLogger.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
@@ -224,7 +367,196 @@ func loggerWithZerolog(logger *zerolog.Logger) gin.HandlerFunc {
 	}
 }
 
-func runHttpServer(config *Config, module modules.Module) *http.Server {
+// stateResponse is the server state rendered by the state API and the state
+// command.
+type stateResponse struct {
+	modules.State
+	// Age is the number of seconds elapsed since the state has been retrieved
+	Age          int                  `json:"age"`
+	Capabilities modules.Capabilities `json:"capabilities"`
+}
+
+func newStateResponse(state modules.State, capabilities modules.Capabilities) stateResponse {
+	return stateResponse{state, int(stateAge(&state).Seconds()), capabilities}
+}
+
+// desiredRequest is the body of the requests setting the desired state.
+type desiredRequest struct {
+	Power modules.PowerState `json:"power" binding:"required,oneof=on off"`
+}
+
+// actionNames contains the name of power-on and power-off in the messages
+// of the API.
+var actionNames = map[modules.Capability]string{
+	modules.CapabilityPowerOn:  "startup",
+	modules.CapabilityPowerOff: "shutdown",
+}
+
+// actionHandler performs an action on the target, provided its module
+// supports it and the server isn't already in the requested state.
+func actionHandler(timeouts *TimeoutsConfig, capability modules.Capability) gin.HandlerFunc {
+	action := string(capability)
+	if name, ok := actionNames[capability]; ok {
+		action = name
+	}
+	return func(c *gin.Context) {
+		target := currentTarget(c)
+		_, err := target.Perform(c.Request.Context(), timeouts, capability)
+
+		if errors.Is(err, modules.ErrNotSupported) {
+			abortNotSupported(c, capability)
+			return
+		}
+		if errors.Is(err, errActionSkipped) {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  "ok",
+				"skipped": true,
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, errActionConflict) {
+			c.JSON(http.StatusConflict, gin.H{
+				"status": "ko",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			mainLogger.Error().Err(err).Str("server", target.Name).Msg(fmt.Sprintf("Server %s error", action))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "ko",
+				"error":  fmt.Sprintf("a problem occurred during server %s", action),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// indexData returns the data of the index page of the target.
+func indexData(c *gin.Context, targets Targets, failed bool) gin.H {
+	state := serverState(c)
+	return gin.H{
+		"state":    state,
+		"age":      int(stateAge(&state).Seconds()),
+		"target":   currentTarget(c),
+		"targets":  targets,
+		"disabled": c.GetBool("disabled"),
+		"error":    failed,
+	}
+}
+
+// registerIndexRoutes registers the web interface of the target selected by
+// TargetMiddleware.
+func registerIndexRoutes(group *gin.RouterGroup, config *Config, targets Targets) {
+	withServerState := group.Group("", ServerStateMiddleware())
+
+	// GET index.html
+	withServerState.GET("", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "index.html", indexData(c, targets, false))
+	})
+
+	// POST index.html
+	withServerState.POST("",
+		ConditionalMiddleware(func(c *gin.Context) bool { return serverState(c).Power.Powered() },
+			gin.BasicAuth(gin.Accounts{config.Username: config.Password})),
+		func(c *gin.Context) {
+			if c.GetBool("disabled") {
+				c.HTML(http.StatusNotImplemented, "index.html", indexData(c, targets, true))
+				return
+			}
+
+			target := currentTarget(c)
+			capability := toggleCapability(serverState(c).Power.Powered())
+			_, err := target.Perform(c.Request.Context(), &config.Timeouts, capability)
+			if errors.Is(err, errActionConflict) {
+				c.HTML(http.StatusConflict, "index.html", indexData(c, targets, true))
+				return
+			}
+			if err != nil && !errors.Is(err, errActionSkipped) {
+				mainLogger.Error().Err(err).Str("server", target.Name).Msg(fmt.Sprintf("Server %s error", capability))
+				c.HTML(http.StatusOK, "index.html", indexData(c, targets, true))
+				return
+			}
+
+			c.Redirect(http.StatusFound, c.Request.URL.Path)
+		})
+}
+
+// registerApiRoutes registers the API of the target selected by
+// TargetMiddleware.
+func registerApiRoutes(api *gin.RouterGroup, config *Config, idempotencyStore *IdempotencyStore) {
+	basicAuth := gin.BasicAuth(gin.Accounts{config.Username: config.Password})
+	// The idempotency keys are only looked up once the request is
+	// authenticated
+	idempotency := IdempotencyMiddleware(idempotencyStore)
+
+	api.POST("/up", idempotency, CapabilityMiddleware(modules.CapabilityPowerOn), actionHandler(&config.Timeouts, modules.CapabilityPowerOn))
+
+	api.POST("/down", basicAuth, idempotency, CapabilityMiddleware(modules.CapabilityPowerOff), actionHandler(&config.Timeouts, modules.CapabilityPowerOff))
+
+	api.POST("/suspend", basicAuth, idempotency, CapabilityMiddleware(modules.CapabilitySuspend), actionHandler(&config.Timeouts, modules.CapabilitySuspend))
+
+	api.POST("/hibernate", basicAuth, idempotency, CapabilityMiddleware(modules.CapabilityHibernate), actionHandler(&config.Timeouts, modules.CapabilityHibernate))
+
+	// POST /api/force-off, /api/graceful-shutdown, /api/force-restart, ...
+	for _, action := range modules.ExtendedActions {
+		api.POST("/"+string(action), basicAuth, idempotency, CapabilityMiddleware(action), actionHandler(&config.Timeouts, action))
+	}
+
+	// Switching off the server requires authentication, as /api/down
+	api.PUT("/desired", DesiredAuthMiddleware(basicAuth), idempotency, func(c *gin.Context) {
+		var request desiredRequest
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "ko",
+				"error":  "the power field must be \"on\" or \"off\"",
+			})
+			return
+		}
+		capability := desiredCapability(request.Power)
+		target := currentTarget(c)
+		if !target.Module.Capabilities().Has(capability) {
+			abortNotSupported(c, capability)
+			return
+		}
+
+		reconciliation := target.Reconciler.Set(request.Power)
+		c.JSON(http.StatusAccepted, reconciliation.State())
+	})
+
+	api.GET("/desired", func(c *gin.Context) {
+		reconciliation := currentTarget(c).Reconciler.Current()
+		if reconciliation == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status": "ko",
+				"error":  "no desired state has been set",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, reconciliation.State())
+	})
+
+	api.DELETE("/desired", basicAuth, func(c *gin.Context) {
+		currentTarget(c).Reconciler.Clear()
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	})
+
+	api.GET("/state", ServerStateMiddleware(), func(c *gin.Context) {
+		c.JSON(200, newStateResponse(serverState(c), currentTarget(c).Module.Capabilities()))
+	})
+}
+
+// runHttpServer starts the HTTP server. The contexts of the requests are
+// derived from baseCtx.
+func runHttpServer(baseCtx context.Context, config *Config, targets Targets) *http.Server {
 	// Configure Gin
 	router := gin.New()
 	router.Use(loggerWithZerolog(&ginLogger))
@@ -240,96 +572,35 @@ func runHttpServer(config *Config, module modules.Module) *http.Server {
 	}
 	router.StaticFS("/static", http.FS(staticSubtreeFS))
 
-	withServerState := router.Group("/", ServerStateMiddleware(module, &mainLogger))
-	{
-		// GET index.html
-		withServerState.GET("/", func(c *gin.Context) {
-			c.HTML(http.StatusOK, "index.html", gin.H{
-				"power": c.GetBool("power"),
-				"led":   c.GetBool("led"),
-			})
-		})
-
-		// POST index.html
-		withServerState.POST("/",
-			ConditionalMiddleware(func(c *gin.Context) bool { return c.GetBool("power") },
-				gin.BasicAuth(gin.Accounts{config.Username: config.Password})),
-			func(c *gin.Context) {
-				if c.GetBool("power") {
-					err := module.PowerOff()
-					if err != nil {
-						mainLogger.Error().Err(err).Msg("Server shutdown error")
-						c.HTML(http.StatusOK, "index.html", gin.H{
-							"power": c.GetBool("power"),
-							"led":   c.GetBool("led"),
-							"error": true,
-						})
-						return
-					}
-				} else {
-					err := module.PowerOn()
-					if err != nil {
-						mainLogger.Error().Err(err).Msg("Server power-up error")
-						c.HTML(http.StatusOK, "index.html", gin.H{
-							"power": c.GetBool("power"),
-							"led":   c.GetBool("led"),
-							"error": true,
-						})
-						return
-					}
-				}
-
-				c.Redirect(http.StatusFound, "/")
-			})
-	}
+	// The routes without server name act on the default server
+	registerIndexRoutes(router.Group("/", TargetMiddleware(targets)), config, targets)
+	registerIndexRoutes(router.Group("/servers/:name", TargetMiddleware(targets)), config, targets)
 
 	api := router.Group("/api")
 	{
-		api.POST("/up", func(c *gin.Context) {
-			err := module.PowerOn()
-
-			if err != nil {
-				mainLogger.Error().Err(err).Msg("Server power-up error")
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"status": "ko",
-					"error":  "a problem occurred during server startup",
+		idempotencyStore := NewIdempotencyStore()
+		registerApiRoutes(api.Group("", TargetMiddleware(targets)), config, idempotencyStore)
+		registerApiRoutes(api.Group("/servers/:name", TargetMiddleware(targets)), config, idempotencyStore)
+
+		api.GET("/servers", func(c *gin.Context) {
+			servers := make([]gin.H, 0, len(targets))
+			for _, target := range targets {
+				servers = append(servers, gin.H{
+					"name":         target.Name,
+					"module":       target.ModuleName,
+					"capabilities": target.Module.Capabilities(),
 				})
-				return
 			}
-
-			c.JSON(http.StatusOK, gin.H{
-				"status": "ok",
-			})
-		})
-
-		api.POST("/down", gin.BasicAuth(gin.Accounts{config.Username: config.Password}), func(c *gin.Context) {
-			err := module.PowerOff()
-
-			if err != nil {
-				mainLogger.Error().Err(err).Msg("Server shutdown error")
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"status": "ko",
-					"error":  "a problem occurred during server shutdown",
-				})
-				return
-			}
-
-			c.JSON(http.StatusOK, gin.H{
-				"status": "ok",
-			})
-		})
-
-		api.GET("/state", ServerStateMiddleware(module, &mainLogger), func(c *gin.Context) {
-			c.JSON(200, gin.H{
-				"power": c.GetBool("power"),
-				"led":   c.GetBool("led"),
-			})
+			c.JSON(200, servers)
 		})
 	}
 
 	srv := &http.Server{
 		Addr:    resolveAddress(),
 		Handler: router,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	go func() {
@@ -343,7 +614,86 @@ func runHttpServer(config *Config, module modules.Module) *http.Server {
 }
 
 func init() {
-	rootCmd.AddCommand(upCmd, downCmd, stateCmd)
+	rootCmd.AddCommand(upCmd, downCmd, suspendCmd, hibernateCmd, stateCmd)
+	for _, action := range modules.ExtendedActions {
+		rootCmd.AddCommand(newActionCmd(action))
+	}
+}
+
+// exitCodeNotSupported is the exit code of the commands requesting an action
+// which isn't supported by the module.
+const exitCodeNotSupported = 3
+
+// commandContext returns the context of a module operation run by a command.
+// It is canceled on SIGINT or SIGTERM, or once timeout is reached.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func exitIfNotSupported(target *Target, capability modules.Capability, err error) {
+	if !target.Module.Capabilities().Has(capability) || errors.Is(err, modules.ErrNotSupported) {
+		fmt.Fprintf(os.Stderr, "The %s action is not supported by the %q module\n", capability, target.ModuleName)
+		os.Exit(exitCodeNotSupported)
+	}
+}
+
+// actionErrorMessages contains the error message of power-on and power-off
+// in the commands.
+var actionErrorMessages = map[modules.Capability]string{
+	modules.CapabilityPowerOn:  "Server power-up error",
+	modules.CapabilityPowerOff: "Server shutdown error",
+}
+
+// runActionCommand performs an action, provided the module supports it and
+// the server isn't already in the requested state.
+func runActionCommand(capability modules.Capability) {
+	config := parseConfigFile(configFilePath)
+	target := selectTarget(config)
+	exitIfNotSupported(target, capability, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	_, err := target.Perform(ctx, &config.Timeouts, capability)
+	stop()
+
+	exitIfNotSupported(target, capability, err)
+	if errors.Is(err, errActionSkipped) {
+		fmt.Fprintf(os.Stderr, "Nothing to do: %s\n", err)
+		return
+	}
+	if err != nil {
+		message, ok := actionErrorMessages[capability]
+		if !ok {
+			message = fmt.Sprintf("Server %s error", capability)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", message, err)
+		os.Exit(1)
+	}
+}
+
+// actionDescriptions contains the short description of the extended actions,
+// used by the CLI and the Discord bot.
+var actionDescriptions = map[modules.Capability]string{
+	modules.CapabilityForceOff:         "Cut the power of the server immediately",
+	modules.CapabilityGracefulShutdown: "Ask the operating system to shut down",
+	modules.CapabilityForceRestart:     "Restart the server immediately",
+	modules.CapabilityPowerCycle:       "Turn the server off then on again",
+	modules.CapabilityPressAndHold:     "Press and hold the power button",
+	modules.CapabilityNmi:              "Send a non-maskable interrupt to the server",
+}
+
+func newActionCmd(capability modules.Capability) *cobra.Command {
+	return &cobra.Command{
+		Use:   string(capability),
+		Short: actionDescriptions[capability],
+		Run: func(cmd *cobra.Command, args []string) {
+			runActionCommand(capability)
+		},
+	}
 }
 
 var (
@@ -351,30 +701,28 @@ var (
 		Use:   "up",
 		Short: "Start the server",
 		Run: func(cmd *cobra.Command, args []string) {
-			config := parseConfigFile(configFilePath)
-			module := createModule(config, moduleName)
-
-			err := module.PowerOn()
-
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Server power-up error: %s\n", err)
-				os.Exit(1)
-			}
+			runActionCommand(modules.CapabilityPowerOn)
 		},
 	}
 	downCmd = &cobra.Command{
 		Use:   "down",
 		Short: "Turn off the server",
 		Run: func(cmd *cobra.Command, args []string) {
-			config := parseConfigFile(configFilePath)
-			module := createModule(config, moduleName)
-
-			err := module.PowerOff()
-
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Server shutdown error: %s\n", err)
-				os.Exit(1)
-			}
+			runActionCommand(modules.CapabilityPowerOff)
+		},
+	}
+	suspendCmd = &cobra.Command{
+		Use:   "suspend",
+		Short: "Suspend the server to memory",
+		Run: func(cmd *cobra.Command, args []string) {
+			runActionCommand(modules.CapabilitySuspend)
+		},
+	}
+	hibernateCmd = &cobra.Command{
+		Use:   "hibernate",
+		Short: "Hibernate the server to disk",
+		Run: func(cmd *cobra.Command, args []string) {
+			runActionCommand(modules.CapabilityHibernate)
 		},
 	}
 	stateCmd = &cobra.Command{
@@ -382,26 +730,17 @@ var (
 		Short: "Fetch the server state",
 		Run: func(cmd *cobra.Command, args []string) {
 			config := parseConfigFile(configFilePath)
-			module := createModule(config, moduleName)
-
-			powerState, ledState := module.State()
+			target := selectTarget(config)
 
-			if powerState.Err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to retrieve POWER state: %s\n", powerState.Err)
-			}
-			if ledState.Err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to retrieve LED state: %s\n", ledState.Err)
-			}
+			ctx, cancel := commandContext(config.Timeouts.State)
+			defer cancel()
+			state := modules.ReadState(ctx, target.Module)
 
-			state := struct {
-				Power bool `json:"power"`
-				Led   bool `json:"led"`
-			}{
-				Power: powerState.Value,
-				Led:   ledState.Value,
+			for _, err := range state.Errors {
+				fmt.Fprintf(os.Stderr, "Failed to retrieve the server state: %s\n", err)
 			}
 
-			jsonString, err := json.Marshal(state)
+			jsonString, err := json.Marshal(newStateResponse(state, target.Module.Capabilities()))
 			if err != nil {
 				fmt.Println("Error during JSON conversion:", err)
 				os.Exit(1)
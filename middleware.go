@@ -1,26 +1,210 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
 	"github.com/rs/zerolog"
 	"github.com/tr4cks/power/modules"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
-func ServerStateMiddleware(module modules.Module, logger *zerolog.Logger) gin.HandlerFunc {
+// TargetMiddleware selects the target named by the name parameter of the
+// route, or the default target when the route has no such parameter.
+func TargetMiddleware(targets Targets) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		powerState, ledState := module.State()
+		name := c.Param("name")
+		if name == "" {
+			c.Set("target", targets[0])
+			c.Next()
+			return
+		}
 
-		if powerState.Err != nil {
-			logger.Error().Err(powerState.Err).Msg("Failed to retrieve POWER state")
+		target := targets.Find(name)
+		if target == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"status": "ko",
+				"error":  fmt.Sprintf("unknown server %q", name),
+			})
+			return
 		}
-		if ledState.Err != nil {
-			logger.Error().Err(ledState.Err).Msg("Failed to retrieve LED state")
+		c.Set("target", target)
+		c.Next()
+	}
+}
+
+// currentTarget returns the target selected by TargetMiddleware.
+func currentTarget(c *gin.Context) *Target {
+	return c.MustGet("target").(*Target)
+}
+
+// ServerStateMiddleware retrieves the state of the target from its poller.
+// The Age header tells how long ago the state has been retrieved.
+func ServerStateMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target := currentTarget(c)
+		state := target.Poller.State(c.Request.Context())
+
+		c.Header("Age", strconv.Itoa(int(stateAge(&state).Seconds())))
+		c.Set("state", state)
+		// The index page button switches the server off when it is powered,
+		// and on otherwise
+		c.Set("disabled", !target.Module.Capabilities().Has(toggleCapability(state.Power.Powered())))
+
+		c.Next()
+	}
+}
+
+// serverState returns the state stored by ServerStateMiddleware.
+func serverState(c *gin.Context) modules.State {
+	return c.MustGet("state").(modules.State)
+}
+
+func logStateErrors(logger *zerolog.Logger, state *modules.State) {
+	for _, err := range state.Errors {
+		logger.Error().Str("error", err).Str("power", string(state.Power)).Msg("Failed to retrieve the server state")
+	}
+}
+
+func toggleCapability(power bool) modules.Capability {
+	if power {
+		return modules.CapabilityPowerOff
+	}
+	return modules.CapabilityPowerOn
+}
+
+// CapabilityMiddleware rejects the requests for an action which isn't
+// supported by the module of the target.
+func CapabilityMiddleware(capability modules.Capability) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentTarget(c).Module.Capabilities().Has(capability) {
+			abortNotSupported(c, capability)
+			return
 		}
+		c.Next()
+	}
+}
+
+func abortNotSupported(c *gin.Context, capability modules.Capability) {
+	c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+		"status": "ko",
+		"error":  fmt.Sprintf("the %s action is not supported by the module", capability),
+	})
+}
+
+// readBody returns the body of the request, which can then be read again.
+func readBody(c *gin.Context, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// IdempotencyMiddleware replays the response to the first request sent with
+// the same Idempotency-Key header, instead of performing the action again.
+// The requests sent while the first one is still running wait for its
+// response. It must be registered after the authentication, so that the
+// responses are only replayed to authenticated clients.
+func IdempotencyMiddleware(store *IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"status": "ko",
+				"error":  fmt.Sprintf("the idempotency key is longer than %d characters", maxIdempotencyKeyLength),
+			})
+			return
+		}
+
+		body, err := readBody(c, maxIdempotentBodySize)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+					"status": "ko",
+					"error":  fmt.Sprintf("the body of the request is larger than %d bytes", maxIdempotentBodySize),
+				})
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		// The same key sent with another route or another body is a client
+		// error, not a retry
+		hash := sha256.Sum256(body)
+		fingerprint := c.Request.Method + " " + c.Request.URL.Path + " " + hex.EncodeToString(hash[:])
 
-		c.Set("power", powerState.Value)
-		c.Set("led", ledState.Value)
+		response, first, err := store.begin(key, fingerprint)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"status": "ko",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if first {
+			writer := &recordingWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+			defer func() {
+				store.finish(key, response, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+			}()
+			c.Next()
+			return
+		}
 
+		if response.fingerprint != fingerprint {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"status": "ko",
+				"error":  "the idempotency key has already been used for another request",
+			})
+			return
+		}
+		select {
+		case <-response.done:
+		case <-c.Request.Context().Done():
+			c.Abort()
+			return
+		}
+		if !response.kept {
+			c.Next()
+			return
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(response.status, response.contentType, response.body)
+		c.Abort()
+	}
+}
+
+// DesiredAuthMiddleware requires authentication for the requests setting the
+// desired state to off, as switching off the server does. The requests with
+// an invalid body are left to the handler.
+func DesiredAuthMiddleware(auth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := readBody(c, maxIdempotentBodySize)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		var request desiredRequest
+		err = binding.JSON.BindBody(body, &request)
+		if err == nil && desiredCapability(request.Power) == modules.CapabilityPowerOff {
+			auth(c)
+			return
+		}
 		c.Next()
 	}
 }
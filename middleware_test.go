@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testUsername = "username"
+	testPassword = "password"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newIdempotentRouter serves POST /action and PUT /desired, behind the
+// authentication and the idempotency middlewares, and counts the requests
+// reaching the handler.
+func newIdempotentRouter(store *IdempotencyStore, handled *atomic.Int32) *gin.Engine {
+	router := gin.New()
+	basicAuth := gin.BasicAuth(gin.Accounts{testUsername: testPassword})
+	handler := func(c *gin.Context) {
+		handled.Add(1)
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "count": handled.Load()})
+	}
+	router.POST("/action", basicAuth, IdempotencyMiddleware(store), handler)
+	router.PUT("/desired", DesiredAuthMiddleware(basicAuth), IdempotencyMiddleware(store), handler)
+	return router
+}
+
+func sendRequest(router http.Handler, method string, path string, key string, body string, authenticated bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if authenticated {
+		req.SetBasicAuth(testUsername, testPassword)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	type request struct {
+		method        string
+		path          string
+		key           string
+		body          string
+		authenticated bool
+		wantStatus    int
+		wantReplayed  bool
+	}
+	tests := []struct {
+		name        string
+		requests    []request
+		wantHandled int32
+	}{
+		{
+			name: "replayed",
+			requests: []request{
+				{"POST", "/action", "k1", "", true, http.StatusOK, false},
+				{"POST", "/action", "k1", "", true, http.StatusOK, true},
+			},
+			wantHandled: 1,
+		},
+		{
+			name: "without key",
+			requests: []request{
+				{"POST", "/action", "", "", true, http.StatusOK, false},
+				{"POST", "/action", "", "", true, http.StatusOK, false},
+			},
+			wantHandled: 2,
+		},
+		{
+			name: "other body",
+			requests: []request{
+				{"PUT", "/desired", "k1", `{"power":"on"}`, false, http.StatusOK, false},
+				{"PUT", "/desired", "k1", `{"power":"on"}`, false, http.StatusOK, true},
+				{"PUT", "/desired", "k1", `{"power":"off"}`, true, http.StatusUnprocessableEntity, false},
+			},
+			wantHandled: 1,
+		},
+		{
+			name: "other route",
+			requests: []request{
+				{"POST", "/action", "k1", `{"power":"on"}`, true, http.StatusOK, false},
+				{"PUT", "/desired", "k1", `{"power":"on"}`, true, http.StatusUnprocessableEntity, false},
+			},
+			wantHandled: 1,
+		},
+		{
+			name: "not replayed without authentication",
+			requests: []request{
+				{"POST", "/action", "k1", "", true, http.StatusOK, false},
+				{"POST", "/action", "k1", "", false, http.StatusUnauthorized, false},
+			},
+			wantHandled: 1,
+		},
+		{
+			name: "unauthenticated request not recorded",
+			requests: []request{
+				{"POST", "/action", "k1", "", false, http.StatusUnauthorized, false},
+				{"POST", "/action", "k1", "", true, http.StatusOK, false},
+			},
+			wantHandled: 1,
+		},
+		{
+			name: "desired off requires authentication",
+			requests: []request{
+				{"PUT", "/desired", "k1", `{"power":"off"}`, false, http.StatusUnauthorized, false},
+				{"PUT", "/desired", "k1", `{"power":"off"}`, true, http.StatusOK, false},
+				{"PUT", "/desired", "k1", `{"power":"off"}`, false, http.StatusUnauthorized, false},
+			},
+			wantHandled: 1,
+		},
+		{
+			name: "key too long",
+			requests: []request{
+				{"POST", "/action", strings.Repeat("k", maxIdempotencyKeyLength+1), "", true, http.StatusBadRequest, false},
+			},
+			wantHandled: 0,
+		},
+		{
+			name: "body too large",
+			requests: []request{
+				{"POST", "/action", "k1", strings.Repeat("x", maxIdempotentBodySize+1), true, http.StatusRequestEntityTooLarge, false},
+			},
+			wantHandled: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled atomic.Int32
+			router := newIdempotentRouter(NewIdempotencyStore(), &handled)
+
+			for i, r := range tt.requests {
+				recorder := sendRequest(router, r.method, r.path, r.key, r.body, r.authenticated)
+				if recorder.Code != r.wantStatus {
+					t.Errorf("request %d: status = %d, want %d (body: %s)", i, recorder.Code, r.wantStatus, recorder.Body)
+				}
+				if replayed := recorder.Header().Get("Idempotent-Replayed") == "true"; replayed != r.wantReplayed {
+					t.Errorf("request %d: replayed = %t, want %t", i, replayed, r.wantReplayed)
+				}
+			}
+			if got := handled.Load(); got != tt.wantHandled {
+				t.Errorf("handled %d requests, want %d", got, tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestIdempotencyStoreCapacity(t *testing.T) {
+	store := NewIdempotencyStore()
+	store.capacity = 2
+
+	first, _, err := store.begin("k1", "POST /up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := store.begin("k2", "POST /up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both requests are still running
+	if _, _, err := store.begin("k3", "POST /up"); err != errIdempotencyStoreFull {
+		t.Fatalf("begin() error = %v, want %v", err, errIdempotencyStoreFull)
+	}
+
+	// The oldest recorded response is evicted
+	store.finish("k2", second, http.StatusOK, "application/json", nil)
+	store.finish("k1", first, http.StatusOK, "application/json", nil)
+	if _, first, err := store.begin("k3", "POST /up"); err != nil || !first {
+		t.Fatalf("begin() = %t %v, want a new key", first, err)
+	}
+	if _, first, _ := store.begin("k2", "POST /up"); first {
+		t.Error("the newest response has been evicted")
+	}
+	if len(store.responses) != 2 {
+		t.Errorf("the store holds %d keys, want 2", len(store.responses))
+	}
+}
+
+func TestTargetMiddleware(t *testing.T) {
+	targets := Targets{{Name: "nas"}, {Name: "desktop"}}
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, currentTarget(c).Name)
+	}
+	router.GET("/api/state", TargetMiddleware(targets), handler)
+	router.GET("/api/servers/:name/state", TargetMiddleware(targets), handler)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantTarget string
+	}{
+		{"/api/state", http.StatusOK, "nas"},
+		{"/api/servers/nas/state", http.StatusOK, "nas"},
+		{"/api/servers/desktop/state", http.StatusOK, "desktop"},
+		{"/api/servers/laptop/state", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := sendRequest(router, "GET", tt.path, "", "", false)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantTarget != "" && recorder.Body.String() != tt.wantTarget {
+				t.Errorf("target = %s, want %s", recorder.Body, tt.wantTarget)
+			}
+		})
+	}
+}
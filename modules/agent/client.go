@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 10 * time.Second
+
+type ClientConfig struct {
+	Url                string `validate:"required"`
+	Secret             string
+	CaFile             string `mapstructure:"ca-file"`
+	CertFile           string `mapstructure:"cert-file" validate:"required_with=KeyFile"`
+	KeyFile            string `mapstructure:"key-file" validate:"required_with=CertFile"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
+	PowerOff           Action `mapstructure:"power-off" validate:"omitempty,oneof=shutdown suspend hibernate"`
+}
+
+// AgentClient sends requests to the `power agent` running on the target.
+type AgentClient struct {
+	url    *url.URL
+	secret string
+	client http.Client
+}
+
+func (c *AgentClient) do(ctx context.Context, method string, path string, respBody interface{}) error {
+	endpoint := c.url.JoinPath(path)
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+	if c.secret != "" {
+		nonce, err := NewNonce()
+		if err != nil {
+			return fmt.Errorf("error generating the nonce: %w", err)
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(NonceHeader, nonce)
+		req.Header.Set(SignatureHeader, Sign(c.secret, method, endpoint.Path, timestamp, nonce, nil))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading the response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response from %s %s (StatusCode: %d, Body: %v)", method, endpoint.Path, resp.StatusCode, string(bytes.TrimSpace(body)))
+	}
+
+	if respBody != nil {
+		err = json.Unmarshal(body, respBody)
+		if err != nil {
+			return fmt.Errorf("error decoding the JSON response: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *AgentClient) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodGet, "/api/status", &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *AgentClient) Do(ctx context.Context, action Action) error {
+	err := c.do(ctx, http.MethodPost, "/api/"+string(action), nil)
+	if err != nil {
+		return fmt.Errorf("error requesting %s from the agent: %w", action, err)
+	}
+	return nil
+}
+
+func newTlsConfig(config *ClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CaFile != "" {
+		ca, err := os.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in the CA file %q", config.CaFile)
+		}
+	}
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+func NewClient(config *ClientConfig) (*AgentClient, error) {
+	if config.Secret == "" && config.CertFile == "" {
+		return nil, fmt.Errorf("either a secret or a client certificate is required to authenticate with the agent")
+	}
+	if config.PowerOff == "" {
+		config.PowerOff = ActionShutdown
+	}
+
+	baseUrl := config.Url
+	if !strings.Contains(baseUrl, "://") {
+		baseUrl = "http://" + baseUrl
+	}
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+	if parsedUrl.Path == "" {
+		parsedUrl.Path = "/"
+	}
+
+	tlsConfig, err := newTlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &AgentClient{
+		url:    parsedUrl,
+		secret: config.Secret,
+		client: http.Client{Transport: transport, Timeout: requestTimeout},
+	}, nil
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests sent to the agent are authenticated with an HMAC-SHA256 signature
+// of the method, the path, the timestamp, the nonce and the body, computed
+// with a secret shared by both ends. The timestamp prevents a captured request
+// from being replayed outside of the MaxClockSkew window, and the nonce, which
+// the agent only accepts once, within it.
+const (
+	TimestampHeader = "X-Power-Timestamp"
+	NonceHeader     = "X-Power-Nonce"
+	SignatureHeader = "X-Power-Signature"
+	MaxClockSkew    = 30 * time.Second
+)
+
+type Action string
+
+const (
+	ActionShutdown  Action = "shutdown"
+	ActionSuspend   Action = "suspend"
+	ActionHibernate Action = "hibernate"
+	ActionReboot    Action = "reboot"
+)
+
+var Actions = []Action{ActionShutdown, ActionSuspend, ActionHibernate, ActionReboot}
+
+type Memory struct {
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+}
+
+// Status is the answer of the agent to a status query. The fields that can't
+// be retrieved on the target are omitted.
+type Status struct {
+	Hostname string     `json:"hostname"`
+	Os       string     `json:"os"`
+	Arch     string     `json:"arch"`
+	Version  string     `json:"version"`
+	BootTime *time.Time `json:"boot_time,omitempty"`
+	Load     []float64  `json:"load,omitempty"`
+	Memory   *Memory    `json:"memory,omitempty"`
+	Actions  []Action   `json:"actions"`
+}
+
+func Sign(secret string, method string, path string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", method, path, timestamp, nonce)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewNonce() (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+func Verify(secret string, method string, path string, timestamp string, nonce string, body []byte, signature string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("timestamp %q is outside of the allowed window", timestamp)
+	}
+	if nonce == "" {
+		return fmt.Errorf("missing nonce")
+	}
+	expected := Sign(secret, method, path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// NonceCache remembers the nonces of the accepted requests for as long as
+// their timestamp is within the MaxClockSkew window, so that a captured
+// request can't be replayed.
+type NonceCache struct {
+	mutex  sync.Mutex
+	expiry map[string]time.Time
+}
+
+func NewNonceCache() *NonceCache {
+	return &NonceCache{expiry: make(map[string]time.Time)}
+}
+
+// Use records the nonce, and returns false when it has already been used.
+func (c *NonceCache) Use(nonce string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for n, expiry := range c.expiry {
+		if !now.Before(expiry) {
+			delete(c.expiry, n)
+		}
+	}
+	if _, ok := c.expiry[nonce]; ok {
+		return false
+	}
+	// A timestamp accepted now may be up to MaxClockSkew in the future, and
+	// stays valid for another MaxClockSkew
+	c.expiry[nonce] = now.Add(2 * MaxClockSkew)
+	return true
+}
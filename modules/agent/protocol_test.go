@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "shared_secret"
+
+func TestVerify(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-2*MaxClockSkew).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(2*MaxClockSkew).Unix(), 10)
+	body := []byte(`{"key":"value"}`)
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		timestamp string
+		nonce     string
+		body      []byte
+		signature string
+		wantErr   bool
+	}{
+		{"valid", "POST", "/api/reboot", now, "n1", body, Sign(testSecret, "POST", "/api/reboot", now, "n1", body), false},
+		{"other method", "GET", "/api/reboot", now, "n1", body, Sign(testSecret, "POST", "/api/reboot", now, "n1", body), true},
+		{"other path", "POST", "/api/shutdown", now, "n1", body, Sign(testSecret, "POST", "/api/reboot", now, "n1", body), true},
+		{"other nonce", "POST", "/api/reboot", now, "n2", body, Sign(testSecret, "POST", "/api/reboot", now, "n1", body), true},
+		{"other body", "POST", "/api/reboot", now, "n1", nil, Sign(testSecret, "POST", "/api/reboot", now, "n1", body), true},
+		{"other secret", "POST", "/api/reboot", now, "n1", body, Sign("other", "POST", "/api/reboot", now, "n1", body), true},
+		{"missing nonce", "POST", "/api/reboot", now, "", body, Sign(testSecret, "POST", "/api/reboot", now, "", body), true},
+		{"expired", "POST", "/api/reboot", old, "n1", body, Sign(testSecret, "POST", "/api/reboot", old, "n1", body), true},
+		{"in the future", "POST", "/api/reboot", future, "n1", body, Sign(testSecret, "POST", "/api/reboot", future, "n1", body), true},
+		{"invalid timestamp", "POST", "/api/reboot", "now", "n1", body, Sign(testSecret, "POST", "/api/reboot", "now", "n1", body), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(testSecret, tt.method, tt.path, tt.timestamp, tt.nonce, tt.body, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	cache := NewNonceCache()
+	now := time.Now()
+
+	steps := []struct {
+		name  string
+		nonce string
+		at    time.Time
+		want  bool
+	}{
+		{"first use", "n1", now, true},
+		{"replay", "n1", now.Add(time.Second), false},
+		{"other nonce", "n2", now.Add(time.Second), true},
+		{"replay at the end of the window", "n1", now.Add(2*MaxClockSkew - time.Second), false},
+		{"reuse after the window", "n1", now.Add(2 * MaxClockSkew), true},
+		{"later nonce", "n3", now.Add(5 * MaxClockSkew), true},
+	}
+	for _, step := range steps {
+		if got := cache.Use(step.nonce, step.at); got != step.want {
+			t.Errorf("%s: Use(%q) = %t, want %t", step.name, step.nonce, got, step.want)
+		}
+	}
+	// The expired nonces are pruned
+	if len(cache.expiry) != 1 {
+		t.Errorf("cache holds %d nonces, want 1", len(cache.expiry))
+	}
+}
+
+func TestNewNonce(t *testing.T) {
+	first, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 32 || first == second {
+		t.Errorf("NewNonce() = %q then %q, want distinct 32 characters nonces", first, second)
+	}
+}
@@ -0,0 +1,238 @@
+package amt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+const (
+	defaultPort    = 16992
+	defaultTlsPort = 16993
+	requestTimeout = 30 * time.Second
+
+	actionEnumerate = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate"
+	actionPull      = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull"
+
+	resourceAssociatedPowerManagementService = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_AssociatedPowerManagementService"
+	resourcePowerManagementService           = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_PowerManagementService"
+	resourceComputerSystem                   = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ComputerSystem"
+)
+
+// PowerState is a CIM power state, as defined by the DMTF power state
+// management profile.
+type PowerState int
+
+const (
+	PowerStateOn                PowerState = 2
+	PowerStateSleepLight        PowerState = 3
+	PowerStateSleepDeep         PowerState = 4
+	PowerStatePowerCycleOffSoft PowerState = 5
+	PowerStateOffHard           PowerState = 6
+	PowerStateHibernate         PowerState = 7
+	PowerStateOffSoft           PowerState = 8
+	PowerStateMasterBusReset    PowerState = 10
+	PowerStateOffSoftGraceful   PowerState = 12
+)
+
+type selector struct {
+	name  string
+	value string
+}
+
+var powerManagementServiceSelectors = []selector{
+	{"CreationClassName", "CIM_PowerManagementService"},
+	{"Name", "Intel(r) AMT Power Management Service"},
+	{"SystemCreationClassName", "CIM_ComputerSystem"},
+	{"SystemName", "Intel(r) AMT"},
+}
+
+// AmtClient sends WS-Management requests to the Intel AMT firmware.
+type AmtClient struct {
+	url    string
+	client http.Client
+}
+
+func escape(value string) string {
+	var buffer strings.Builder
+	xml.EscapeText(&buffer, []byte(value))
+	return buffer.String()
+}
+
+func messageId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("error generating the message ID: %w", err)
+	}
+	return "uuid:" + hex.EncodeToString(id), nil
+}
+
+func (c *AmtClient) send(ctx context.Context, action string, resourceUri string, selectors []selector, body string) ([]byte, error) {
+	id, err := messageId()
+	if err != nil {
+		return nil, err
+	}
+
+	var selectorSet string
+	if len(selectors) > 0 {
+		selectorSet = "<w:SelectorSet>"
+		for _, selector := range selectors {
+			selectorSet += fmt.Sprintf(`<w:Selector Name="%s">%s</w:Selector>`, escape(selector.name), escape(selector.value))
+		}
+		selectorSet += "</w:SelectorSet>"
+	}
+
+	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>`+
+		`<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration">`+
+		`<Header>`+
+		`<a:Action>%s</a:Action>`+
+		`<a:To>/wsman</a:To>`+
+		`<w:ResourceURI>%s</w:ResourceURI>`+
+		`<a:MessageID>%s</a:MessageID>`+
+		`<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`+
+		`<w:OperationTimeout>PT60S</w:OperationTimeout>`+
+		`%s`+
+		`</Header>`+
+		`<Body>%s</Body>`+
+		`</Envelope>`, escape(action), escape(resourceUri), id, selectorSet, body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBufferString(envelope))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := findElement(response, "Text")
+		return nil, fmt.Errorf("unexpected WS-Management response (StatusCode: %d, Reason: %v)", resp.StatusCode, reason)
+	}
+	return response, nil
+}
+
+// findElement returns the text of the first element with the given local
+// name, whatever its namespace.
+func findElement(document []byte, localName string) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("the response has no %s element", localName)
+		}
+		if err != nil {
+			return "", fmt.Errorf("error decoding the XML response: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == localName {
+			var text string
+			err = decoder.DecodeElement(&text, &start)
+			if err != nil {
+				return "", fmt.Errorf("error decoding the %s element: %w", localName, err)
+			}
+			return strings.TrimSpace(text), nil
+		}
+	}
+}
+
+func (c *AmtClient) PowerState(ctx context.Context) (PowerState, error) {
+	response, err := c.send(ctx, actionEnumerate, resourceAssociatedPowerManagementService, nil, "<n:Enumerate/>")
+	if err != nil {
+		return 0, fmt.Errorf("error enumerating the power management services: %w", err)
+	}
+	context, err := findElement(response, "EnumerationContext")
+	if err != nil {
+		return 0, err
+	}
+
+	body := fmt.Sprintf("<n:Pull><n:EnumerationContext>%s</n:EnumerationContext></n:Pull>", escape(context))
+	response, err = c.send(ctx, actionPull, resourceAssociatedPowerManagementService, nil, body)
+	if err != nil {
+		return 0, fmt.Errorf("error pulling the power management services: %w", err)
+	}
+	value, err := findElement(response, "PowerState")
+	if err != nil {
+		return 0, err
+	}
+	powerState, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid power state %q: %w", value, err)
+	}
+	return PowerState(powerState), nil
+}
+
+// RequestPowerStateChange invokes CIM_PowerManagementService.RequestPowerStateChange
+// on the managed system.
+func (c *AmtClient) RequestPowerStateChange(ctx context.Context, powerState PowerState) error {
+	body := fmt.Sprintf(`<h:RequestPowerStateChange_INPUT xmlns:h="%s">`+
+		`<h:PowerState>%d</h:PowerState>`+
+		`<h:ManagedElement>`+
+		`<a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address>`+
+		`<a:ReferenceParameters>`+
+		`<w:ResourceURI>%s</w:ResourceURI>`+
+		`<w:SelectorSet>`+
+		`<w:Selector Name="CreationClassName">CIM_ComputerSystem</w:Selector>`+
+		`<w:Selector Name="Name">ManagedSystem</w:Selector>`+
+		`</w:SelectorSet>`+
+		`</a:ReferenceParameters>`+
+		`</h:ManagedElement>`+
+		`</h:RequestPowerStateChange_INPUT>`, resourcePowerManagementService, powerState, resourceComputerSystem)
+
+	response, err := c.send(ctx, resourcePowerManagementService+"/RequestPowerStateChange", resourcePowerManagementService, powerManagementServiceSelectors, body)
+	if err != nil {
+		return fmt.Errorf("error requesting the power state %d: %w", powerState, err)
+	}
+	returnValue, err := findElement(response, "ReturnValue")
+	if err != nil {
+		return err
+	}
+	if returnValue != "0" {
+		return fmt.Errorf("the power state %d was refused (ReturnValue: %s)", powerState, returnValue)
+	}
+	return nil
+}
+
+func NewClient(address string, port int, useTls bool, username string, password string) *AmtClient {
+	scheme := "http"
+	if useTls {
+		scheme = "https"
+	}
+	if port == 0 {
+		port = defaultPort
+		if useTls {
+			port = defaultTlsPort
+		}
+	}
+
+	// Ignore SSL certificate verification, AMT uses self-signed certificates by default
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	return &AmtClient{
+		url: fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(address, strconv.Itoa(port))),
+		client: http.Client{
+			Transport: &modules.DigestTransport{Username: username, Password: password, Transport: transport},
+			Timeout:   requestTimeout,
+		},
+	}
+}
@@ -0,0 +1,112 @@
+package amt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type AmtModule struct {
+	modules.DefaultModule
+	Config AmtConfig
+	Client *AmtClient
+}
+
+type AmtConfig struct {
+	Hostname string `validate:"required"`
+	Address  string `validate:"required"`
+	Port     int    `validate:"gte=0,lte=65535"`
+	Tls      bool
+	Username string
+	Password string `validate:"required"`
+	PowerOff string `mapstructure:"power-off" validate:"omitempty,oneof=graceful hard"`
+}
+
+func New() modules.Module {
+	return &AmtModule{}
+}
+
+func (m *AmtModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "amt", err)
+	}
+	if m.Config.Username == "" {
+		m.Config.Username = "admin"
+	}
+	if m.Config.PowerOff == "" {
+		m.Config.PowerOff = "graceful"
+	}
+	m.Client = NewClient(m.Config.Address, m.Config.Port, m.Config.Tls, m.Config.Username, m.Config.Password)
+	return nil
+}
+
+func (m *AmtModule) State(ctx context.Context) modules.State {
+	powerStateTask, powerStateChan := modules.MakeAsync(func() modules.Result[PowerState] {
+		value, err := m.Client.PowerState(ctx)
+		if err != nil {
+			return modules.Result[PowerState]{Err: err}
+		}
+		switch value {
+		case PowerStateOn, PowerStateSleepLight, PowerStateSleepDeep, PowerStateHibernate, PowerStateOffHard, PowerStateOffSoft:
+			return modules.Result[PowerState]{Value: value}
+		}
+		return modules.Result[PowerState]{Err: fmt.Errorf("unknown power state %d", value)}
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	go powerStateTask()
+	go pingTask()
+
+	powerState := <-powerStateChan
+	state := modules.NewState(modules.Result[bool]{Value: powerState.Value == PowerStateOn, Err: powerState.Err}, <-pingChan)
+	switch powerState.Value {
+	case PowerStateSleepLight, PowerStateSleepDeep, PowerStateHibernate:
+		state.Power = modules.PowerSleeping
+	}
+	return state
+}
+
+func (m *AmtModule) PowerOn(ctx context.Context) error {
+	return m.Client.RequestPowerStateChange(ctx, PowerStateOn)
+}
+
+func (m *AmtModule) PowerOff(ctx context.Context) error {
+	if m.Config.PowerOff == "hard" {
+		return m.Client.RequestPowerStateChange(ctx, PowerStateOffSoft)
+	}
+	return m.Client.RequestPowerStateChange(ctx, PowerStateOffSoftGraceful)
+}
+
+// extendedPowerStates contains the power state requested for each extended
+// action.
+var extendedPowerStates = map[modules.Capability]PowerState{
+	modules.CapabilityForceOff:         PowerStateOffSoft,
+	modules.CapabilityGracefulShutdown: PowerStateOffSoftGraceful,
+	modules.CapabilityForceRestart:     PowerStateMasterBusReset,
+	modules.CapabilityPowerCycle:       PowerStatePowerCycleOffSoft,
+}
+
+func (m *AmtModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{
+		modules.CapabilityPowerOn,
+		modules.CapabilityPowerOff,
+		modules.CapabilityForceOff,
+		modules.CapabilityGracefulShutdown,
+		modules.CapabilityForceRestart,
+		modules.CapabilityPowerCycle,
+	}
+}
+
+func (m *AmtModule) Perform(ctx context.Context, action modules.Capability) error {
+	powerState, ok := extendedPowerStates[action]
+	if !ok {
+		return modules.ErrNotSupported
+	}
+	return m.Client.RequestPowerStateChange(ctx, powerState)
+}
@@ -0,0 +1,382 @@
+package amt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testUsername     = "admin"
+	testPassword     = "P@ssw0rd"
+	digestRealm      = "Digest:A3829B3827DE4D33D4449B366831FD01"
+	digestNonce      = "2c7e4f3a9b1d"
+	enumerationToken = "00000012-0000-0000-0000-000000000000"
+)
+
+// wsmanStub is a local stand-in for the WS-Management service of the AMT
+// firmware, protected with the digest authentication.
+type wsmanStub struct {
+	server *httptest.Server
+	// requests records the power states requested
+	requests moduletest.Recorder[PowerState]
+
+	mutex sync.Mutex
+	auth  moduletest.DigestAuth
+	// powerState is the power state reported by the firmware
+	powerState PowerState
+	// malformed truncates the responses
+	malformed bool
+	// returnValue is returned by RequestPowerStateChange, 0 for a success
+	returnValue int
+	// fault is answered with a SOAP fault to every request when not empty
+	fault string
+}
+
+func newWsmanStub(t *testing.T) *wsmanStub {
+	t.Helper()
+	stub := &wsmanStub{
+		powerState: PowerStateOffSoft,
+		auth:       moduletest.DigestAuth{Username: testUsername, Password: testPassword, Realm: digestRealm, Nonce: digestNonce},
+	}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// configure changes the state of the firmware while it is running.
+func (s *wsmanStub) configure(configure func(s *wsmanStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=UTF-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope" xmlns:g="http://schemas.xmlsoap.org/ws/2004/09/enumeration">`+
+		`<a:Header/><a:Body>%s</a:Body></a:Envelope>`, body)
+}
+
+func writeFault(w http.ResponseWriter, reason string) {
+	writeEnvelope(w, http.StatusBadRequest, `<a:Fault><a:Code><a:Value>a:Sender</a:Value></a:Code>`+
+		`<a:Reason><a:Text xml:lang="en-US">`+reason+`</a:Text></a:Reason></a:Fault>`)
+}
+
+func (s *wsmanStub) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/wsman" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	request, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.auth.Valid(r) {
+		s.auth.Challenge(w)
+		return
+	}
+	if s.fault != "" {
+		writeFault(w, s.fault)
+		return
+	}
+	if s.malformed {
+		w.Header().Set("Content-Type", "application/soap+xml; charset=UTF-8")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope"><a:Body><g:Enumerate`)
+		return
+	}
+	action, _ := findElement(request, "Action")
+	resource, _ := findElement(request, "ResourceURI")
+
+	switch {
+	case action == actionEnumerate && resource == resourceAssociatedPowerManagementService:
+		writeEnvelope(w, http.StatusOK, `<g:EnumerateResponse><g:EnumerationContext>`+enumerationToken+`</g:EnumerationContext></g:EnumerateResponse>`)
+	case action == actionPull && resource == resourceAssociatedPowerManagementService:
+		if context, _ := findElement(request, "EnumerationContext"); context != enumerationToken {
+			writeFault(w, "The enumeration context supplied in the message is not valid.")
+			return
+		}
+		writeEnvelope(w, http.StatusOK, fmt.Sprintf(`<g:PullResponse><g:Items>`+
+			`<h:CIM_AssociatedPowerManagementService xmlns:h="%s">`+
+			`<h:AvailableRequestedPowerStates>2</h:AvailableRequestedPowerStates>`+
+			`<h:PowerState>%d</h:PowerState>`+
+			`</h:CIM_AssociatedPowerManagementService>`+
+			`</g:Items><g:EndOfSequence/></g:PullResponse>`, resourceAssociatedPowerManagementService, s.powerState))
+	case action == resourcePowerManagementService+"/RequestPowerStateChange" && resource == resourcePowerManagementService:
+		// The first selector is the one of the service, in the header
+		selector, _ := findElement(request, "Selector")
+		value, _ := findElement(request, "PowerState")
+		powerState, err := strconv.Atoi(value)
+		if selector != "CIM_PowerManagementService" || err != nil {
+			writeFault(w, "The message is not valid.")
+			return
+		}
+		s.requests.Record(PowerState(powerState))
+		if s.returnValue == 0 {
+			switch PowerState(powerState) {
+			case PowerStatePowerCycleOffSoft, PowerStateMasterBusReset:
+				s.powerState = PowerStateOn
+			case PowerStateOffSoftGraceful:
+				s.powerState = PowerStateOffSoft
+			default:
+				s.powerState = PowerState(powerState)
+			}
+		}
+		writeEnvelope(w, http.StatusOK, fmt.Sprintf(`<g:RequestPowerStateChange_OUTPUT xmlns:g="%s"><g:ReturnValue>%d</g:ReturnValue></g:RequestPowerStateChange_OUTPUT>`, resourcePowerManagementService, s.returnValue))
+	default:
+		writeFault(w, "The action is not supported by the service.")
+	}
+}
+
+func newTestModule(t *testing.T, stub *wsmanStub, config map[string]interface{}) *AmtModule {
+	t.Helper()
+	return newModule(t, stub.server.Listener.Addr().(*net.TCPAddr), config)
+}
+
+func newModule(t *testing.T, address *net.TCPAddr, config map[string]interface{}) *AmtModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*AmtModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"address":  address.IP.String(),
+		"port":     address.Port,
+		"password": testPassword,
+	}, config)
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		powerState PowerState
+		want       modules.PowerState
+	}{
+		{PowerStateOn, modules.PowerOn},
+		{PowerStateOffSoft, modules.PowerOff},
+		{PowerStateOffHard, modules.PowerOff},
+		{PowerStateSleepLight, modules.PowerSleeping},
+		{PowerStateSleepDeep, modules.PowerSleeping},
+		{PowerStateHibernate, modules.PowerSleeping},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.powerState)), func(t *testing.T) {
+			stub := newWsmanStub(t)
+			stub.configure(func(s *wsmanStub) { s.powerState = tt.powerState })
+			module := newTestModule(t, stub, nil)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.want {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+			}
+			if errs := moduletest.PowerErrors(state); len(errs) != 0 {
+				t.Errorf("State() power errors = %v, want none", errs)
+			}
+		})
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	powerOn := func(m *AmtModule, ctx context.Context) error { return m.PowerOn(ctx) }
+	powerOff := func(m *AmtModule, ctx context.Context) error { return m.PowerOff(ctx) }
+	perform := func(action modules.Capability) func(m *AmtModule, ctx context.Context) error {
+		return func(m *AmtModule, ctx context.Context) error { return m.Perform(ctx, action) }
+	}
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		call    func(m *AmtModule, ctx context.Context) error
+		want    PowerState
+		wantErr error
+	}{
+		{"power on", nil, powerOn, PowerStateOn, nil},
+		{"power off", nil, powerOff, PowerStateOffSoftGraceful, nil},
+		{"hard power off", map[string]interface{}{"power-off": "hard"}, powerOff, PowerStateOffSoft, nil},
+		{"force off", nil, perform(modules.CapabilityForceOff), PowerStateOffSoft, nil},
+		{"graceful shutdown", nil, perform(modules.CapabilityGracefulShutdown), PowerStateOffSoftGraceful, nil},
+		{"force restart", nil, perform(modules.CapabilityForceRestart), PowerStateMasterBusReset, nil},
+		{"power cycle", nil, perform(modules.CapabilityPowerCycle), PowerStatePowerCycleOffSoft, nil},
+		{"suspend", nil, perform(modules.CapabilitySuspend), 0, modules.ErrNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newWsmanStub(t)
+			module := newTestModule(t, stub, tt.config)
+
+			err := tt.call(module, moduletest.Context(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			var want []PowerState
+			if tt.want != 0 {
+				want = []PowerState{tt.want}
+			}
+			if requests := stub.requests.Received(); !slices.Equal(requests, want) {
+				t.Errorf("requested power states = %v, want %v", requests, want)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	stub := newWsmanStub(t)
+	module := newTestModule(t, stub, nil)
+
+	// Every advertised extended action must be performed
+	for _, capability := range module.Capabilities() {
+		if capability == modules.CapabilityPowerOn || capability == modules.CapabilityPowerOff {
+			continue
+		}
+		if err := module.Perform(moduletest.Context(t), capability); err != nil {
+			t.Errorf("Perform(%s) error = %v", capability, err)
+		}
+	}
+	if requests := stub.requests.Received(); len(requests) != len(extendedPowerStates) {
+		t.Errorf("requested power states = %v, want one per extended action", requests)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		configure func(s *wsmanStub)
+		wantState string
+		wantPower string
+	}{
+		{
+			"wrong password",
+			map[string]interface{}{"password": "invalid"},
+			nil,
+			"power: error enumerating the power management services: unexpected WS-Management response (StatusCode: 401",
+			"error requesting the power state 2: unexpected WS-Management response (StatusCode: 401",
+		},
+		{
+			"refused power state",
+			nil,
+			func(s *wsmanStub) { s.returnValue = 2 },
+			"",
+			"the power state 2 was refused (ReturnValue: 2)",
+		},
+		{
+			"fault",
+			nil,
+			func(s *wsmanStub) { s.fault = "The service is busy." },
+			"power: error enumerating the power management services: unexpected WS-Management response (StatusCode: 400, Reason: The service is busy.)",
+			"error requesting the power state 2: unexpected WS-Management response (StatusCode: 400, Reason: The service is busy.)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newWsmanStub(t)
+			if tt.configure != nil {
+				stub.configure(tt.configure)
+			}
+			module := newTestModule(t, stub, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			errs := moduletest.PowerErrors(state)
+			if tt.wantState == "" && len(errs) != 0 {
+				t.Errorf("State() power errors = %v, want none", errs)
+			}
+			if tt.wantState != "" && (state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.HasPrefix(errs[0], tt.wantState)) {
+				t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, errs, tt.wantState)
+			}
+
+			err := module.PowerOn(moduletest.Context(t))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantPower) {
+				t.Errorf("PowerOn() error = %v, want %q", err, tt.wantPower)
+			}
+		})
+	}
+}
+
+func TestUnknownPowerState(t *testing.T) {
+	for _, powerState := range []PowerState{0, PowerStatePowerCycleOffSoft, 9, 16} {
+		t.Run(strconv.Itoa(int(powerState)), func(t *testing.T) {
+			stub := newWsmanStub(t)
+			stub.configure(func(s *wsmanStub) { s.powerState = powerState })
+			module := newTestModule(t, stub, nil)
+
+			state := module.State(moduletest.Context(t))
+			want := fmt.Sprintf("power: unknown power state %d", powerState)
+			if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || !slices.Equal(errs, []string{want}) {
+				t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, state.Errors, want)
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		// between is called between the two calls of the module
+		between func(s *wsmanStub)
+		wantErr string
+	}{
+		{
+			// The firmware answers the next request with a new challenge
+			name:    "nonce rotated",
+			between: func(s *wsmanStub) { s.auth.Nonce = "9f03be1c4d2a" },
+		},
+		{
+			name:    "password changed",
+			between: func(s *wsmanStub) { s.auth.Password = "N3wP@ssw0rd" },
+			wantErr: "error requesting the power state 2: unexpected WS-Management response (StatusCode: 401",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newWsmanStub(t)
+			module := newTestModule(t, stub, nil)
+
+			if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOff {
+				t.Fatalf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOff, state.Errors)
+			}
+			stub.configure(tt.between)
+			err := module.PowerOn(moduletest.Context(t))
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)) {
+				t.Errorf("PowerOn() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	stub := newWsmanStub(t)
+	stub.configure(func(s *wsmanStub) { s.malformed = true })
+	module := newTestModule(t, stub, nil)
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.HasPrefix(errs[0], "power: error decoding the XML response") {
+		t.Errorf("State() = %s %v, want a decoding error", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.HasPrefix(err.Error(), "error decoding the XML response") {
+		t.Errorf("PowerOn() error = %v, want a decoding error", err)
+	}
+}
+
+func TestUnreachableFirmware(t *testing.T) {
+	module := newModule(t, moduletest.ClosedAddress(t), nil)
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error sending the request") {
+		t.Errorf("State() = %s %v, want a connection error", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+		t.Errorf("PowerOn() error = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package composite
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type CompositeModule struct {
+	modules.DefaultModule
+	Config            CompositeConfig
+	StateProvider     modules.Module
+	PowerOnProvider   modules.Module
+	PowerOffProvider  modules.Module
+	SuspendProvider   modules.Module
+	HibernateProvider modules.Module
+	ActionsProvider   modules.Module
+
+	registry map[string]func() modules.Module
+}
+
+type ProviderConfig struct {
+	Module string `validate:"required"`
+	Config map[string]interface{}
+}
+
+type CompositeConfig struct {
+	State     ProviderConfig  `validate:"required"`
+	PowerOn   *ProviderConfig `mapstructure:"power-on"`
+	PowerOff  *ProviderConfig `mapstructure:"power-off"`
+	Suspend   *ProviderConfig
+	Hibernate *ProviderConfig
+	// Actions is the provider of the extended actions
+	Actions *ProviderConfig
+}
+
+// New creates a module which delegates each operation to a module of the
+// registry. The providers fall back to the state provider when they are not
+// defined.
+func New(registry map[string]func() modules.Module) modules.Module {
+	return &CompositeModule{registry: registry}
+}
+
+func (m *CompositeModule) createProvider(name string, config *ProviderConfig) (modules.Module, error) {
+	newModule, ok := m.registry[config.Module]
+	if !ok {
+		moduleNames := make([]string, 0, len(m.registry))
+		for moduleName := range m.registry {
+			moduleNames = append(moduleNames, moduleName)
+		}
+		sort.Strings(moduleNames)
+		return nil, fmt.Errorf("can't find the %q module used as %s provider (available modules: %s)", config.Module, name, strings.Join(moduleNames, ", "))
+	}
+	module := newModule()
+	err := module.Init(config.Config)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the %s provider: %w", name, err)
+	}
+	return module, nil
+}
+
+func (m *CompositeModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "composite", err)
+	}
+
+	m.StateProvider, err = m.createProvider("state", &m.Config.State)
+	if err != nil {
+		return err
+	}
+
+	providers := []struct {
+		name     string
+		config   *ProviderConfig
+		provider *modules.Module
+	}{
+		{"power-on", m.Config.PowerOn, &m.PowerOnProvider},
+		{"power-off", m.Config.PowerOff, &m.PowerOffProvider},
+		{"suspend", m.Config.Suspend, &m.SuspendProvider},
+		{"hibernate", m.Config.Hibernate, &m.HibernateProvider},
+		{"actions", m.Config.Actions, &m.ActionsProvider},
+	}
+	for _, provider := range providers {
+		*provider.provider = m.StateProvider
+		if provider.config != nil {
+			*provider.provider, err = m.createProvider(provider.name, provider.config)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *CompositeModule) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{}
+	if m.PowerOnProvider.Capabilities().Has(modules.CapabilityPowerOn) {
+		capabilities = append(capabilities, modules.CapabilityPowerOn)
+	}
+	if m.PowerOffProvider.Capabilities().Has(modules.CapabilityPowerOff) {
+		capabilities = append(capabilities, modules.CapabilityPowerOff)
+	}
+	if _, ok := m.SuspendProvider.(modules.Sleeper); ok && m.SuspendProvider.Capabilities().Has(modules.CapabilitySuspend) {
+		capabilities = append(capabilities, modules.CapabilitySuspend)
+	}
+	if _, ok := m.HibernateProvider.(modules.Sleeper); ok && m.HibernateProvider.Capabilities().Has(modules.CapabilityHibernate) {
+		capabilities = append(capabilities, modules.CapabilityHibernate)
+	}
+	if _, ok := m.ActionsProvider.(modules.ActionPerformer); ok {
+		actionsCapabilities := m.ActionsProvider.Capabilities()
+		for _, action := range modules.ExtendedActions {
+			if actionsCapabilities.Has(action) {
+				capabilities = append(capabilities, action)
+			}
+		}
+	}
+	return capabilities
+}
+
+func (m *CompositeModule) State(ctx context.Context) modules.State {
+	return m.StateProvider.State(ctx)
+}
+
+func (m *CompositeModule) PowerOn(ctx context.Context) error {
+	return m.PowerOnProvider.PowerOn(ctx)
+}
+
+func (m *CompositeModule) PowerOff(ctx context.Context) error {
+	return m.PowerOffProvider.PowerOff(ctx)
+}
+
+func (m *CompositeModule) Suspend(ctx context.Context) error {
+	return modules.Perform(ctx, m.SuspendProvider, modules.CapabilitySuspend)
+}
+
+func (m *CompositeModule) Hibernate(ctx context.Context) error {
+	return modules.Perform(ctx, m.HibernateProvider, modules.CapabilityHibernate)
+}
+
+func (m *CompositeModule) Perform(ctx context.Context, action modules.Capability) error {
+	if !slices.Contains(modules.ExtendedActions, action) {
+		return modules.ErrNotSupported
+	}
+	return modules.Perform(ctx, m.ActionsProvider, action)
+}
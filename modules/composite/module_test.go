@@ -0,0 +1,199 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+// basicModule is a provider which can only switch the server on and off. Its
+// calls are recorded as "<name>:<operation>", name being set by its
+// configuration.
+type basicModule struct {
+	modules.DefaultModule
+	name  string
+	calls *moduletest.Recorder[string]
+}
+
+func (m *basicModule) Init(config map[string]interface{}) error {
+	m.name, _ = config["name"].(string)
+	if m.name == "" {
+		return errors.New("missing name")
+	}
+	return nil
+}
+
+func (m *basicModule) State(ctx context.Context) modules.State {
+	m.calls.Record(m.name + ":state")
+	return modules.State{Power: modules.PowerOn}
+}
+
+func (m *basicModule) PowerOn(ctx context.Context) error {
+	m.calls.Record(m.name + ":power-on")
+	return nil
+}
+
+func (m *basicModule) PowerOff(ctx context.Context) error {
+	m.calls.Record(m.name + ":power-off")
+	return nil
+}
+
+// bmcModule is a provider which also puts the server to sleep and performs
+// the force-off and nmi actions.
+type bmcModule struct {
+	basicModule
+}
+
+func (m *bmcModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{
+		modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate,
+		modules.CapabilityForceOff, modules.CapabilityNmi,
+	}
+}
+
+func (m *bmcModule) Suspend(ctx context.Context) error {
+	m.calls.Record(m.name + ":suspend")
+	return nil
+}
+
+func (m *bmcModule) Hibernate(ctx context.Context) error {
+	m.calls.Record(m.name + ":hibernate")
+	return nil
+}
+
+func (m *bmcModule) Perform(ctx context.Context, action modules.Capability) error {
+	m.calls.Record(m.name + ":" + string(action))
+	return nil
+}
+
+func newTestModule(t *testing.T, calls *moduletest.Recorder[string], config map[string]interface{}) *CompositeModule {
+	t.Helper()
+	registry := map[string]func() modules.Module{
+		"basic": func() modules.Module { return &basicModule{calls: calls} },
+		"bmc":   func() modules.Module { return &bmcModule{basicModule{calls: calls}} },
+	}
+	return moduletest.Init(t, New(registry).(*CompositeModule), nil, config)
+}
+
+func provider(module string, name string) map[string]interface{} {
+	return map[string]interface{}{"module": module, "config": map[string]interface{}{"name": name}}
+}
+
+func TestDelegation(t *testing.T) {
+	operations := []struct {
+		name    string
+		perform func(m *CompositeModule, ctx context.Context) error
+	}{
+		{"state", func(m *CompositeModule, ctx context.Context) error { m.State(ctx); return nil }},
+		{"power-on", (*CompositeModule).PowerOn},
+		{"power-off", (*CompositeModule).PowerOff},
+		{"suspend", (*CompositeModule).Suspend},
+		{"hibernate", (*CompositeModule).Hibernate},
+		{"nmi", func(m *CompositeModule, ctx context.Context) error { return m.Perform(ctx, modules.CapabilityNmi) }},
+	}
+	tests := []struct {
+		name             string
+		config           map[string]interface{}
+		wantCapabilities modules.Capabilities
+		// wantCalls contains the call recorded by each operation, empty
+		// when the operation isn't supported
+		wantCalls []string
+	}{
+		{
+			name:             "state provider only",
+			config:           map[string]interface{}{"state": provider("bmc", "ilo")},
+			wantCapabilities: modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate, modules.CapabilityForceOff, modules.CapabilityNmi},
+			wantCalls:        []string{"ilo:state", "ilo:power-on", "ilo:power-off", "ilo:suspend", "ilo:hibernate", "ilo:nmi"},
+		},
+		{
+			name: "separate providers",
+			config: map[string]interface{}{
+				"state":     provider("basic", "plug"),
+				"power-on":  provider("basic", "wol"),
+				"power-off": provider("basic", "ssh"),
+				"suspend":   provider("bmc", "agent"),
+				"hibernate": provider("bmc", "agent"),
+				"actions":   provider("bmc", "ipmi"),
+			},
+			wantCapabilities: modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate, modules.CapabilityForceOff, modules.CapabilityNmi},
+			wantCalls:        []string{"plug:state", "wol:power-on", "ssh:power-off", "agent:suspend", "agent:hibernate", "ipmi:nmi"},
+		},
+		{
+			// The providers without the sleep and extended actions don't
+			// advertise them
+			name: "basic providers",
+			config: map[string]interface{}{
+				"state":   provider("bmc", "ilo"),
+				"suspend": provider("basic", "plug"),
+				"actions": provider("basic", "plug"),
+			},
+			wantCapabilities: modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityHibernate},
+			wantCalls:        []string{"ilo:state", "ilo:power-on", "ilo:power-off", "", "ilo:hibernate", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls moduletest.Recorder[string]
+			module := newTestModule(t, &calls, tt.config)
+
+			if got := module.Capabilities(); !slices.Equal(got, tt.wantCapabilities) {
+				t.Errorf("Capabilities() = %v, want %v", got, tt.wantCapabilities)
+			}
+			for i, operation := range operations {
+				calls.Reset()
+				err := operation.perform(module, moduletest.Context(t))
+				if tt.wantCalls[i] == "" {
+					if !errors.Is(err, modules.ErrNotSupported) || len(calls.Received()) != 0 {
+						t.Errorf("%s: error = %v and calls %v, want %v without any call", operation.name, err, calls.Received(), modules.ErrNotSupported)
+					}
+					continue
+				}
+				if err != nil || !slices.Equal(calls.Received(), []string{tt.wantCalls[i]}) {
+					t.Errorf("%s: error = %v and calls %v, want %s", operation.name, err, calls.Received(), tt.wantCalls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPerformNotExtended(t *testing.T) {
+	var calls moduletest.Recorder[string]
+	module := newTestModule(t, &calls, map[string]interface{}{"state": provider("bmc", "ilo")})
+
+	// The power and sleep actions have their own providers
+	if err := module.Perform(moduletest.Context(t), modules.CapabilityPowerOn); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("Perform() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+	if received := calls.Received(); len(received) != 0 {
+		t.Errorf("calls = %v, want none", received)
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"missing state provider", map[string]interface{}{"power-on": provider("basic", "wol")}, "error validating"},
+		{"unknown module", map[string]interface{}{"state": provider("basic", "plug"), "actions": provider("unknown", "x")}, `can't find the "unknown" module used as actions provider (available modules: basic, bmc)`},
+		{"invalid provider configuration", map[string]interface{}{"state": provider("basic", "plug"), "suspend": provider("bmc", "")}, "error initializing the suspend provider: missing name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := New(map[string]func() modules.Module{
+				"basic": func() modules.Module { return &basicModule{} },
+				"bmc":   func() modules.Module { return &bmcModule{} },
+			})
+			err := module.Init(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Init() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package modules
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DigestTransport is an http.RoundTripper implementing the HTTP digest
+// access authentication (RFC 7616) with the MD5 and SHA-256 algorithms.
+// Requests are first sent without credentials and replayed once with an
+// Authorization header when the server answers with a digest challenge.
+type DigestTransport struct {
+	Username  string
+	Password  string
+	Transport http.RoundTripper
+}
+
+func (t *DigestTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func parseDigestChallenge(header string) (map[string]string, bool) {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Digest") {
+		return nil, false
+	}
+	challenge := map[string]string{}
+	for _, param := range splitDigestParams(params) {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		challenge[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return challenge, true
+}
+
+// splitDigestParams splits the comma separated parameters of a challenge,
+// ignoring the commas in quoted values.
+func splitDigestParams(params string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, c := range params {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, params[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, params[start:])
+}
+
+func (t *DigestTransport) authorization(req *http.Request, challenge map[string]string) (string, error) {
+	var newHash func() hash.Hash
+	algorithm := challenge["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	digest := func(values ...string) string {
+		h := newHash()
+		io.WriteString(h, strings.Join(values, ":"))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 8)
+	_, err := rand.Read(cnonceBytes)
+	if err != nil {
+		return "", fmt.Errorf("error generating the client nonce: %w", err)
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	const nc = "00000001"
+
+	uri := req.URL.RequestURI()
+	ha1 := digest(t.Username, challenge["realm"], t.Password)
+	ha2 := digest(req.Method, uri)
+
+	var response string
+	qop := ""
+	for _, value := range strings.Split(challenge["qop"], ",") {
+		if strings.TrimSpace(value) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		response = digest(ha1, challenge["nonce"], nc, cnonce, qop, ha2)
+	} else {
+		response = digest(ha1, challenge["nonce"], ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, t.Username),
+		fmt.Sprintf(`realm="%s"`, challenge["realm"]),
+		fmt.Sprintf(`nonce="%s"`, challenge["nonce"]),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if algorithm != "" {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	if opaque, ok := challenge["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+func (t *DigestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body is consumed by the first attempt, keep a way to replay it
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody == nil {
+		return nil, fmt.Errorf("digest authentication requires a replayable request body")
+	}
+
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		challenge, ok := parseDigestChallenge(header)
+		if !ok {
+			continue
+		}
+		authorization, err := t.authorization(retry, challenge)
+		if err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			retry.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error replaying the request body: %w", err)
+			}
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		retry.Header.Set("Authorization", authorization)
+		return t.transport().RoundTrip(retry)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHost    = "unix:///var/run/docker.sock"
+	requestTimeout = 30 * time.Second
+)
+
+type HealthStatus string
+
+const (
+	HealthStatusNone      HealthStatus = ""
+	HealthStatusStarting  HealthStatus = "starting"
+	HealthStatusHealthy   HealthStatus = "healthy"
+	HealthStatusUnhealthy HealthStatus = "unhealthy"
+)
+
+type ContainerState struct {
+	Running bool `json:"Running"`
+	Health  *struct {
+		Status HealthStatus `json:"Status"`
+	} `json:"Health"`
+}
+
+// Healthy reports whether a running container passes its health check. A
+// container without health check is healthy as soon as it runs.
+func (s *ContainerState) Healthy() bool {
+	if !s.Running {
+		return false
+	}
+	return s.Health == nil || s.Health.Status == HealthStatusNone || s.Health.Status == HealthStatusHealthy
+}
+
+type container struct {
+	Id    string         `json:"Id"`
+	State ContainerState `json:"State"`
+}
+
+// DockerClient talks to the Docker Engine API, or to the compatible API of
+// Podman, over a unix socket or TCP.
+type DockerClient struct {
+	baseUrl string
+	client  http.Client
+}
+
+func (c *DockerClient) do(ctx context.Context, method string, path string, query url.Values, response interface{}) (int, error) {
+	endpoint := c.baseUrl + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 304 is returned when the container is already started or stopped
+	if resp.StatusCode >= 400 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("error reading the response body: %w", err)
+		}
+		return resp.StatusCode, fmt.Errorf("unexpected response from %s %s (StatusCode: %d, Body: %v)", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if response != nil {
+		err = json.NewDecoder(resp.Body).Decode(response)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("error decoding the JSON response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *DockerClient) Inspect(ctx context.Context, name string) (*ContainerState, error) {
+	var container container
+	_, err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, &container)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting the container %q: %w", name, err)
+	}
+	return &container.State, nil
+}
+
+// ProjectContainers returns the identifiers of the containers of a compose
+// project, whether they are running or not.
+func (c *DockerClient) ProjectContainers(ctx context.Context, project string) ([]string, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"label": {"com.docker.compose.project=" + project},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the filters: %w", err)
+	}
+	var containers []container
+	_, err = c.do(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"1"}, "filters": {string(filters)}}, &containers)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the containers of the %q project: %w", project, err)
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no container found for the %q project", project)
+	}
+	ids := make([]string, 0, len(containers))
+	for _, container := range containers {
+		ids = append(ids, container.Id)
+	}
+	return ids, nil
+}
+
+func (c *DockerClient) Start(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil, nil)
+	if err != nil {
+		return fmt.Errorf("error starting the container %q: %w", name, err)
+	}
+	return nil
+}
+
+func (c *DockerClient) Stop(ctx context.Context, name string, timeout time.Duration) error {
+	query := url.Values{}
+	if timeout > 0 {
+		query.Set("t", strconv.Itoa(int(timeout.Seconds())))
+	}
+	_, err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", query, nil)
+	if err != nil {
+		return fmt.Errorf("error stopping the container %q: %w", name, err)
+	}
+	return nil
+}
+
+func NewClient(host string, stopTimeout time.Duration) (*DockerClient, error) {
+	if host == "" {
+		host = defaultHost
+	}
+	parsedUrl, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the host: %w", err)
+	}
+
+	// Stopping a container may take up to its stop timeout
+	client := http.Client{Timeout: requestTimeout + stopTimeout}
+	switch parsedUrl.Scheme {
+	case "unix":
+		socket := parsedUrl.Path
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &DockerClient{"http://docker", client}, nil
+	case "tcp", "http":
+		return &DockerClient{"http://" + parsedUrl.Host, client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported host scheme %q, expected unix or tcp", parsedUrl.Scheme)
+	}
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type DockerModule struct {
+	modules.DefaultModule
+	Config DockerConfig
+	Client *DockerClient
+}
+
+type DockerConfig struct {
+	Host        string
+	Container   string        `validate:"required_without=Project,excluded_with=Project"`
+	Project     string        `validate:"required_without=Container"`
+	StopTimeout time.Duration `mapstructure:"stop-timeout" validate:"gte=0"`
+}
+
+func New() modules.Module {
+	return &DockerModule{}
+}
+
+func (m *DockerModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "docker", err)
+	}
+	m.Client, err = NewClient(m.Config.Host, m.Config.StopTimeout)
+	if err != nil {
+		return fmt.Errorf("error creating docker client: %w", err)
+	}
+	return nil
+}
+
+func (m *DockerModule) containers(ctx context.Context) ([]string, error) {
+	if m.Config.Container != "" {
+		return []string{m.Config.Container}, nil
+	}
+	return m.Client.ProjectContainers(ctx, m.Config.Project)
+}
+
+func (m *DockerModule) State(ctx context.Context) modules.State {
+	containers, err := m.containers(ctx)
+	if err != nil {
+		return modules.UnknownState(err)
+	}
+
+	// The target is switched on as soon as a container runs, and reachable
+	// once all of them run and pass their health check. It is still powering
+	// on while a health check is starting.
+	running, healthy, starting := false, true, false
+	for _, container := range containers {
+		state, err := m.Client.Inspect(ctx, container)
+		if err != nil {
+			return modules.UnknownState(err)
+		}
+		running = running || state.Running
+		healthy = healthy && state.Healthy()
+		starting = starting || (state.Running && state.Health != nil && state.Health.Status == HealthStatusStarting)
+	}
+	state := modules.NewState(modules.Result[bool]{Value: running}, modules.Result[bool]{Value: healthy})
+	if starting {
+		state.Power = modules.PoweringOn
+	}
+	return state
+}
+
+func (m *DockerModule) PowerOn(ctx context.Context) error {
+	containers, err := m.containers(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, container := range containers {
+		errs = append(errs, m.Client.Start(ctx, container))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *DockerModule) PowerOff(ctx context.Context) error {
+	containers, err := m.containers(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, container := range containers {
+		errs = append(errs, m.Client.Stop(ctx, container, m.Config.StopTimeout))
+	}
+	return errors.Join(errs...)
+}
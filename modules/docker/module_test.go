@@ -0,0 +1,437 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const projectLabel = "com.docker.compose.project"
+
+type fakeContainer struct {
+	Name    string
+	Project string
+	Running bool
+	// Health is the status of the health check, empty when the container
+	// has none
+	Health HealthStatus
+	// StartStatus is the status code answered to the start and stop
+	// requests, instead of performing them
+	StartStatus int
+}
+
+// engineStub is a fake Docker Engine API, served over a unix socket or TCP.
+type engineStub struct {
+	host string
+	// calls records the start and stop requests, as "<action> <name>"
+	calls moduletest.Recorder[string]
+
+	mutex      sync.Mutex
+	containers []*fakeContainer
+	// malformed truncates the JSON responses of the successful requests
+	malformed bool
+}
+
+func newEngineStub(t *testing.T, network string, containers ...*fakeContainer) *engineStub {
+	t.Helper()
+	stub := &engineStub{containers: containers}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(stub.serve))
+	if network == "unix" {
+		// The temporary directory of the test may exceed the maximum length
+		// of a socket path
+		directory, err := os.MkdirTemp("", "docker")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(directory) })
+		socket := filepath.Join(directory, "docker.sock")
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Listener = listener
+		stub.host = "unix://" + socket
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	if network != "unix" {
+		stub.host = "tcp://" + server.Listener.Addr().String()
+	}
+	return stub
+}
+
+// configure changes the containers while the engine is running.
+func (s *engineStub) configure(configure func(s *engineStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func (s *engineStub) find(name string) *fakeContainer {
+	for _, container := range s.containers {
+		if container.Name == name || "id-"+container.Name == name {
+			return container
+		}
+	}
+	return nil
+}
+
+// writeJSON answers with value, truncated when the responses are malformed.
+func (s *engineStub) writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	if s.malformed && status == http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Id": "id-`))
+		return
+	}
+	moduletest.WriteJSON(w, status, value)
+}
+
+func (s *engineStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.Method == http.MethodGet && r.URL.Path == "/containers/json" {
+		var filters map[string][]string
+		if r.URL.Query().Get("all") != "1" || json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters) != nil {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid filters"})
+			return
+		}
+		containers := []map[string]interface{}{}
+		for _, container := range s.containers {
+			if slices.Contains(filters["label"], projectLabel+"="+container.Project) {
+				containers = append(containers, map[string]interface{}{"Id": "id-" + container.Name})
+			}
+		}
+		s.writeJSON(w, http.StatusOK, containers)
+		return
+	}
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+	if len(path) != 2 {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"message": "page not found"})
+		return
+	}
+	container := s.find(path[0])
+	if container == nil {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such container: " + path[0]})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path[1] == "json":
+		state := map[string]interface{}{"Running": container.Running}
+		if container.Health != HealthStatusNone {
+			state["Health"] = map[string]interface{}{"Status": container.Health}
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"Id": "id-" + container.Name, "State": state})
+	case r.Method == http.MethodPost && (path[1] == "start" || path[1] == "stop"):
+		call := path[1] + " " + container.Name
+		if t := r.URL.Query().Get("t"); t != "" {
+			call += " t=" + t
+		}
+		s.calls.Record(call)
+		if container.StartStatus != 0 {
+			s.writeJSON(w, container.StartStatus, map[string]string{"message": "driver failed programming external connectivity"})
+			return
+		}
+		if container.Running == (path[1] == "start") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		container.Running = path[1] == "start"
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"message": "page not found"})
+	}
+}
+
+func newTestModule(t *testing.T, stub *engineStub, config map[string]interface{}) *DockerModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*DockerModule), map[string]interface{}{"host": stub.host}, config)
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name          string
+		containers    []*fakeContainer
+		config        map[string]interface{}
+		wantPower     modules.PowerState
+		wantReachable bool
+	}{
+		{"running", []*fakeContainer{{Name: "web", Running: true}}, nil, modules.PowerOn, true},
+		{"stopped", []*fakeContainer{{Name: "web"}}, nil, modules.PowerOff, false},
+		{"healthy", []*fakeContainer{{Name: "web", Running: true, Health: HealthStatusHealthy}}, nil, modules.PowerOn, true},
+		{"health starting", []*fakeContainer{{Name: "web", Running: true, Health: HealthStatusStarting}}, nil, modules.PoweringOn, false},
+		{"unhealthy", []*fakeContainer{{Name: "web", Running: true, Health: HealthStatusUnhealthy}}, nil, modules.PowerOn, false},
+		{"stopped with health check", []*fakeContainer{{Name: "web", Health: HealthStatusUnhealthy}}, nil, modules.PowerOff, false},
+		{
+			"project running",
+			[]*fakeContainer{{Name: "web", Project: "app", Running: true}, {Name: "db", Project: "app", Running: true, Health: HealthStatusHealthy}},
+			map[string]interface{}{"project": "app"},
+			modules.PowerOn, true,
+		},
+		{
+			"project partially running",
+			[]*fakeContainer{{Name: "web", Project: "app", Running: true}, {Name: "db", Project: "app"}},
+			map[string]interface{}{"project": "app"},
+			modules.PowerOn, false,
+		},
+		{
+			"project stopped",
+			[]*fakeContainer{{Name: "web", Project: "app"}, {Name: "db", Project: "app"}, {Name: "other", Project: "other", Running: true}},
+			map[string]interface{}{"project": "app"},
+			modules.PowerOff, false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newEngineStub(t, "unix", tt.containers...)
+			config := tt.config
+			if config == nil {
+				config = map[string]interface{}{"container": "web"}
+			}
+			module := newTestModule(t, stub, config)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.wantPower {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.wantPower, state.Errors)
+			}
+			if state.Reachable == nil || *state.Reachable != tt.wantReachable {
+				t.Errorf("State().Reachable = %v, want %t", state.Reachable, tt.wantReachable)
+			}
+			if len(state.Errors) != 0 {
+				t.Errorf("State().Errors = %v, want none", state.Errors)
+			}
+		})
+	}
+}
+
+func TestStateError(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"missing container", map[string]interface{}{"container": "missing"}, `state: error inspecting the container "missing": unexpected response from GET /containers/missing/json (StatusCode: 404, Body: {"message":"No such container: missing"})`},
+		{"empty project", map[string]interface{}{"project": "missing"}, `state: no container found for the "missing" project`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newEngineStub(t, "unix", &fakeContainer{Name: "web", Project: "app", Running: true})
+			module := newTestModule(t, stub, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != modules.PowerUnknown {
+				t.Errorf("State().Power = %s, want %s", state.Power, modules.PowerUnknown)
+			}
+			if !slices.Equal(state.Errors, []string{tt.wantErr}) {
+				t.Errorf("State().Errors = %v, want [%s]", state.Errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	for _, network := range []string{"unix", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			stub := newEngineStub(t, network, &fakeContainer{Name: "web"})
+			module := newTestModule(t, stub, map[string]interface{}{"container": "web", "stop-timeout": "30s"})
+
+			if err := module.PowerOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOn {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOn, state.Errors)
+			}
+			// The engine answers 304 when the container already runs
+			if err := module.PowerOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if err := module.PowerOff(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOff() error = %v", err)
+			}
+			if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOff {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOff, state.Errors)
+			}
+
+			want := []string{"start web", "start web", "stop web t=30"}
+			if calls := stub.calls.Received(); !slices.Equal(calls, want) {
+				t.Errorf("calls = %v, want %v", calls, want)
+			}
+		})
+	}
+}
+
+func TestProjectPowerActions(t *testing.T) {
+	stub := newEngineStub(t, "unix",
+		&fakeContainer{Name: "web", Project: "app"},
+		&fakeContainer{Name: "db", Project: "app"},
+		&fakeContainer{Name: "other", Project: "other"},
+	)
+	module := newTestModule(t, stub, map[string]interface{}{"project": "app"})
+
+	if err := module.PowerOn(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOn() error = %v", err)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOff() error = %v", err)
+	}
+	want := []string{"start web", "start db", "stop web", "stop db"}
+	if calls := stub.calls.Received(); !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestPowerActionErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []*fakeContainer
+		config     map[string]interface{}
+		powerOn    bool
+		wantErrs   []string
+		wantCalls  []string
+	}{
+		{
+			"start missing container",
+			nil,
+			map[string]interface{}{"container": "web"},
+			true,
+			[]string{`error starting the container "web": unexpected response from POST /containers/web/start (StatusCode: 404, Body: {"message":"No such container: web"})`},
+			nil,
+		},
+		{
+			"stop failure",
+			[]*fakeContainer{{Name: "web", Running: true, StartStatus: http.StatusInternalServerError}},
+			map[string]interface{}{"container": "web"},
+			false,
+			[]string{`error stopping the container "web": unexpected response from POST /containers/web/stop (StatusCode: 500`},
+			[]string{"stop web"},
+		},
+		{
+			"partial project start",
+			[]*fakeContainer{
+				{Name: "web", Project: "app", StartStatus: http.StatusInternalServerError},
+				{Name: "db", Project: "app"},
+			},
+			map[string]interface{}{"project": "app"},
+			true,
+			[]string{`error starting the container "id-web"`, "driver failed programming external connectivity"},
+			[]string{"start web", "start db"},
+		},
+		{
+			"empty project",
+			nil,
+			map[string]interface{}{"project": "app"},
+			false,
+			[]string{`no container found for the "app" project`},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newEngineStub(t, "unix", tt.containers...)
+			module := newTestModule(t, stub, tt.config)
+
+			var err error
+			if tt.powerOn {
+				err = module.PowerOn(moduletest.Context(t))
+			} else {
+				err = module.PowerOff(moduletest.Context(t))
+			}
+			if err == nil {
+				t.Fatal("error = nil, want an error")
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error = %v, want it to contain %q", err, want)
+				}
+			}
+			if calls := stub.calls.Received(); !slices.Equal(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"container", map[string]interface{}{"container": "web"}, `state: error inspecting the container "web": error decoding the JSON response`},
+		{"project", map[string]interface{}{"project": "app"}, `state: error listing the containers of the "app" project: error decoding the JSON response`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newEngineStub(t, "unix", &fakeContainer{Name: "web", Project: "app", Running: true})
+			stub.configure(func(s *engineStub) { s.malformed = true })
+			module := newTestModule(t, stub, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != modules.PowerUnknown || len(state.Errors) != 1 || !strings.HasPrefix(state.Errors[0], tt.wantErr) {
+				t.Errorf("State() = %s %v, want %q", state.Power, state.Errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnreachableEngine(t *testing.T) {
+	tests := []struct {
+		name string
+		host func(t *testing.T) string
+	}{
+		{"unix", func(t *testing.T) string { return "unix://" + filepath.Join(t.TempDir(), "missing.sock") }},
+		{"tcp", func(t *testing.T) string { return "tcp://" + moduletest.ClosedAddress(t).String() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := moduletest.Init(t, New().(*DockerModule), nil, map[string]interface{}{"host": tt.host(t), "container": "web"})
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != modules.PowerUnknown || len(state.Errors) != 1 || !strings.Contains(state.Errors[0], "error sending the request") {
+				t.Errorf("State() = %s %v, want an unknown state with a request error", state.Power, state.Errors)
+			}
+			if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+				t.Errorf("PowerOn() error = %v, want a request error", err)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr error
+	}{
+		{"", "http://docker", nil},
+		{"unix:///run/podman/podman.sock", "http://docker", nil},
+		{"tcp://127.0.0.1:2375", "http://127.0.0.1:2375", nil},
+		{"http://127.0.0.1:2375", "http://127.0.0.1:2375", nil},
+		{"ssh://docker@host", "", errors.New(`unsupported host scheme "ssh", expected unix or tcp`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			client, err := NewClient(tt.host, 0)
+			if fmt.Sprint(err) != fmt.Sprint(tt.wantErr) {
+				t.Fatalf("NewClient() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && client.baseUrl != tt.want {
+				t.Errorf("NewClient().baseUrl = %q, want %q", client.baseUrl, tt.want)
+			}
+		})
+	}
+}
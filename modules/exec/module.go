@@ -0,0 +1,258 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type ExecModule struct {
+	modules.DefaultModule
+	Config ExecConfig
+
+	powerOn  *command
+	powerOff *command
+	state    *command
+}
+
+type CommandConfig struct {
+	Command string `validate:"required"`
+	Args    []string
+}
+
+type StateCommandConfig struct {
+	CommandConfig `mapstructure:",squash"`
+	Output        string `validate:"omitempty,oneof=exit-code json"`
+}
+
+type ExecConfig struct {
+	Hostname  string
+	PowerOn   *CommandConfig      `mapstructure:"power-on"`
+	PowerOff  *CommandConfig      `mapstructure:"power-off"`
+	State     *StateCommandConfig `validate:"required_without=Hostname"`
+	Timeout   time.Duration       `validate:"gte=0"`
+	Env       map[string]string
+	Variables map[string]string
+}
+
+type command struct {
+	path string
+	args []*template.Template
+}
+
+// jsonState is the document a state command prints when its output is json.
+type jsonState struct {
+	Power *bool `json:"power"`
+	Led   *bool `json:"led"`
+	// PowerState optionally reports a transition or a sleeping server
+	PowerState modules.PowerState `json:"power_state"`
+	Attributes map[string]any     `json:"attributes"`
+}
+
+func New() modules.Module {
+	return &ExecModule{}
+}
+
+func parseCommand(name string, config *CommandConfig) (*command, error) {
+	args := make([]*template.Template, 0, len(config.Args))
+	for i, arg := range config.Args {
+		tmpl, err := template.New(fmt.Sprintf("%s[%d]", name, i)).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the %s command arguments: %w", name, err)
+		}
+		args = append(args, tmpl)
+	}
+	return &command{config.Command, args}, nil
+}
+
+func (m *ExecModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "exec", err)
+	}
+	if m.Config.Timeout == 0 {
+		m.Config.Timeout = defaultTimeout
+	}
+	if m.Config.PowerOn != nil {
+		m.powerOn, err = parseCommand("power-on", m.Config.PowerOn)
+		if err != nil {
+			return err
+		}
+	}
+	if m.Config.PowerOff != nil {
+		m.powerOff, err = parseCommand("power-off", m.Config.PowerOff)
+		if err != nil {
+			return err
+		}
+	}
+	if m.Config.State != nil {
+		m.state, err = parseCommand("state", &m.Config.State.CommandConfig)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run executes the command with the configured timeout and environment. The
+// arguments are rendered with the configured variables and the action name.
+func (m *ExecModule) run(ctx context.Context, action string, cmd *command) ([]byte, error) {
+	data := make(map[string]string, len(m.Config.Variables)+1)
+	for key, value := range m.Config.Variables {
+		data[key] = value
+	}
+	data["action"] = action
+
+	args := make([]string, 0, len(cmd.args))
+	for _, tmpl := range cmd.args {
+		var arg strings.Builder
+		err := tmpl.Execute(&arg, data)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering the %s command arguments: %w", action, err)
+		}
+		args = append(args, arg.String())
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, m.Config.Timeout)
+	defer cancel()
+
+	execCmd := osexec.CommandContext(runCtx, cmd.path, args...)
+	execCmd.Env = os.Environ()
+	for key, value := range m.Config.Env {
+		execCmd.Env = append(execCmd.Env, key+"="+value)
+	}
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout = &stdout
+	execCmd.Stderr = &stderr
+
+	err := execCmd.Run()
+	if ctx.Err() != nil {
+		return stdout.Bytes(), fmt.Errorf("the %s command was interrupted: %w", action, ctx.Err())
+	}
+	if runCtx.Err() == context.DeadlineExceeded {
+		return stdout.Bytes(), fmt.Errorf("the %s command timed out after %s", action, m.Config.Timeout)
+	}
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("error running the %s command (Stderr: %v): %w", action, stderr.String(), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// commandState runs the state command. The reachability is the led field of
+// its JSON output, or the power state otherwise.
+func (m *ExecModule) commandState(ctx context.Context) modules.State {
+	output, err := m.run(ctx, "state", m.state)
+
+	if m.Config.State.Output == "json" {
+		if err != nil {
+			return modules.UnknownState(err)
+		}
+		var state jsonState
+		err = json.Unmarshal(output, &state)
+		if err != nil {
+			return modules.UnknownState(fmt.Errorf("error decoding the JSON output of the state command: %w", err))
+		}
+		if state.Power == nil {
+			return modules.UnknownState(fmt.Errorf("the JSON output of the state command has no %q field", "power"))
+		}
+		switch state.PowerState {
+		case "", modules.PowerOn, modules.PowerOff, modules.PoweringOn, modules.PoweringOff, modules.PowerSleeping:
+		default:
+			return modules.UnknownState(fmt.Errorf("the JSON output of the state command has an unknown %q value: %q", "power_state", state.PowerState))
+		}
+		led := *state.Power
+		if state.Led != nil {
+			led = *state.Led
+		}
+		result := modules.NewState(modules.Result[bool]{Value: *state.Power}, modules.Result[bool]{Value: led})
+		if state.PowerState != "" {
+			result.Power = state.PowerState
+		}
+		result.Attributes = state.Attributes
+		return result
+	}
+
+	// Exit code 0 means on, 1 means off and anything else is an error
+	if err != nil {
+		var exitError *osexec.ExitError
+		if errors.As(err, &exitError) && exitError.ExitCode() == 1 {
+			return modules.NewState(modules.Result[bool]{Value: false}, modules.Result[bool]{Value: false})
+		}
+		return modules.UnknownState(err)
+	}
+	return modules.NewState(modules.Result[bool]{Value: true}, modules.Result[bool]{Value: true})
+}
+
+func (m *ExecModule) State(ctx context.Context) modules.State {
+	if m.state == nil {
+		ping, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.NewState(modules.Result[bool]{Value: ping, Err: err}, modules.Result[bool]{Value: ping, Err: err})
+	}
+
+	if m.Config.Hostname == "" {
+		return m.commandState(ctx)
+	}
+
+	stateTask, stateChan := modules.MakeAsync(func() modules.State {
+		return m.commandState(ctx)
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	go stateTask()
+	go pingTask()
+
+	// The reachability is given by ping when a hostname is defined
+	state, ping := <-stateChan, <-pingChan
+	state.Reachable = nil
+	if ping.Err != nil {
+		state.AddError("reachability", ping.Err)
+	} else {
+		state.SetReachable(ping.Value)
+		if ping.Value && state.Power == modules.PowerUnknown {
+			state.Power = modules.PowerOn
+		}
+	}
+	return state
+}
+
+func (m *ExecModule) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{}
+	if m.powerOn != nil {
+		capabilities = append(capabilities, modules.CapabilityPowerOn)
+	}
+	if m.powerOff != nil {
+		capabilities = append(capabilities, modules.CapabilityPowerOff)
+	}
+	return capabilities
+}
+
+func (m *ExecModule) PowerOn(ctx context.Context) error {
+	if m.powerOn == nil {
+		return modules.ErrNotSupported
+	}
+	_, err := m.run(ctx, "power-on", m.powerOn)
+	return err
+}
+
+func (m *ExecModule) PowerOff(ctx context.Context) error {
+	if m.powerOff == nil {
+		return modules.ErrNotSupported
+	}
+	_, err := m.run(ctx, "power-off", m.powerOff)
+	return err
+}
@@ -0,0 +1,69 @@
+package exec
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+// newTestModule returns a module whose state command prints output as JSON.
+func newTestModule(t *testing.T, output string) *ExecModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*ExecModule), nil, map[string]interface{}{
+		"state": map[string]interface{}{
+			"command": "sh",
+			"args":    []string{"-c", `printf '%s' "$OUTPUT"`},
+			"output":  "json",
+		},
+		"env": map[string]string{"OUTPUT": output},
+	})
+}
+
+func TestJsonState(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		want       modules.PowerState
+		wantErrors []string
+	}{
+		{name: "on", output: `{"power": true}`, want: modules.PowerOn},
+		{name: "off", output: `{"power": false}`, want: modules.PowerOff},
+		{name: "sleeping", output: `{"power": false, "power_state": "sleeping"}`, want: modules.PowerSleeping},
+		{name: "powering on", output: `{"power": false, "power_state": "powering-on"}`, want: modules.PoweringOn},
+		{
+			name:       "unknown power state",
+			output:     `{"power": true, "power_state": "hibernating"}`,
+			want:       modules.PowerUnknown,
+			wantErrors: []string{`state: the JSON output of the state command has an unknown "power_state" value: "hibernating"`},
+		},
+		{
+			name:       "missing power",
+			output:     `{"power_state": "sleeping"}`,
+			want:       modules.PowerUnknown,
+			wantErrors: []string{`state: the JSON output of the state command has no "power" field`},
+		},
+		{
+			name:       "malformed",
+			output:     `{"power": tru`,
+			want:       modules.PowerUnknown,
+			wantErrors: []string{"state: error decoding the JSON output of the state command"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := newTestModule(t, tt.output)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.want {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+			}
+			errs := state.Errors
+			if len(errs) != len(tt.wantErrors) || !slices.EqualFunc(errs, tt.wantErrors, strings.HasPrefix) {
+				t.Errorf("State().Errors = %v, want %v", errs, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 30 * time.Second
+
+type entityState struct {
+	State string `json:"state"`
+}
+
+// HomeAssistantClient calls the Home Assistant REST API with a long-lived
+// access token.
+type HomeAssistantClient struct {
+	url    *url.URL
+	token  string
+	client http.Client
+}
+
+func (c *HomeAssistantClient) do(ctx context.Context, method string, path string, reqBody interface{}, respBody interface{}) error {
+	endpoint := c.url.JoinPath(path)
+
+	var body io.Reader
+	if reqBody != nil {
+		jsonData, err := json.Marshal(reqBody)
+		if err != nil {
+			return fmt.Errorf("error encoding JSON: %w", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading the response body: %w", err)
+		}
+		return fmt.Errorf("unexpected response from %s %s (StatusCode: %d, Body: %v)", method, path, resp.StatusCode, string(body))
+	}
+
+	if respBody != nil {
+		err = json.NewDecoder(resp.Body).Decode(respBody)
+		if err != nil {
+			return fmt.Errorf("error decoding the JSON response: %w", err)
+		}
+	}
+	return nil
+}
+
+// CallService calls a service of the entity domain, such as switch.turn_on.
+func (c *HomeAssistantClient) CallService(ctx context.Context, entityId string, service string) error {
+	domain, _, _ := strings.Cut(entityId, ".")
+	err := c.do(ctx, http.MethodPost, "/api/services/"+domain+"/"+service, map[string]string{"entity_id": entityId}, nil)
+	if err != nil {
+		return fmt.Errorf("error calling %s.%s on %s: %w", domain, service, entityId, err)
+	}
+	return nil
+}
+
+func (c *HomeAssistantClient) State(ctx context.Context, entityId string) (string, error) {
+	var state entityState
+	err := c.do(ctx, http.MethodGet, "/api/states/"+entityId, nil, &state)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving the state of %s: %w", entityId, err)
+	}
+	// Home Assistant reports the entities without a known state as unknown,
+	// an empty state comes from something else answering at the URL
+	if state.State == "" {
+		return "", fmt.Errorf("error retrieving the state of %s: the response has no state", entityId)
+	}
+	return state.State, nil
+}
+
+func NewClient(baseUrl string, token string) (*HomeAssistantClient, error) {
+	if !strings.Contains(baseUrl, "://") {
+		baseUrl = "http://" + baseUrl
+	}
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+	return &HomeAssistantClient{parsedUrl, token, http.Client{Timeout: requestTimeout}}, nil
+}
@@ -0,0 +1,123 @@
+package homeassistant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type HomeAssistantModule struct {
+	modules.DefaultModule
+	Config HomeAssistantConfig
+	Client *HomeAssistantClient
+}
+
+type HomeAssistantConfig struct {
+	Url   string `validate:"required"`
+	Token string `validate:"required"`
+	// Entity is used to switch the server on and off and to retrieve its
+	// state, unless a more specific entity is defined.
+	Entity       string
+	PowerOn      string `mapstructure:"power-on"`
+	PowerOff     string `mapstructure:"power-off"`
+	State        string `validate:"required_without=Entity"`
+	Reachability string
+	Hostname     string
+}
+
+func New() modules.Module {
+	return &HomeAssistantModule{}
+}
+
+func (m *HomeAssistantModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "homeassistant", err)
+	}
+	if m.Config.PowerOn == "" {
+		m.Config.PowerOn = m.Config.Entity
+	}
+	if m.Config.PowerOff == "" {
+		m.Config.PowerOff = m.Config.Entity
+	}
+	if m.Config.State == "" {
+		m.Config.State = m.Config.Entity
+	}
+	if strings.HasPrefix(m.Config.State, "button.") {
+		return fmt.Errorf("the %s entity has no on/off state, define a state entity", m.Config.State)
+	}
+	m.Client, err = NewClient(m.Config.Url, m.Config.Token)
+	if err != nil {
+		return fmt.Errorf("error creating home assistant client: %w", err)
+	}
+	return nil
+}
+
+// entityOn reports whether an entity is on. Unavailable entities, whose
+// device doesn't answer Home Assistant, have no known state.
+func (m *HomeAssistantModule) entityOn(ctx context.Context, entityId string) modules.Result[bool] {
+	state, err := m.Client.State(ctx, entityId)
+	if err == nil && (state == "unavailable" || state == "unknown") {
+		err = fmt.Errorf("the %s entity is %s", entityId, state)
+	}
+	return modules.Result[bool]{Value: state == "on", Err: err}
+}
+
+func (m *HomeAssistantModule) reachability(ctx context.Context, powerState modules.Result[bool]) modules.Result[bool] {
+	if m.Config.Reachability != "" {
+		return m.entityOn(ctx, m.Config.Reachability)
+	}
+	if m.Config.Hostname != "" {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	}
+	return powerState
+}
+
+func (m *HomeAssistantModule) State(ctx context.Context) modules.State {
+	powerState := m.entityOn(ctx, m.Config.State)
+	return modules.NewState(powerState, m.reachability(ctx, powerState))
+}
+
+// action calls the service matching the entity domain. Buttons are pressed
+// and scripts are run, whatever the requested state.
+func (m *HomeAssistantModule) action(ctx context.Context, entityId string, on bool) error {
+	domain, _, _ := strings.Cut(entityId, ".")
+	switch domain {
+	case "button", "input_button":
+		return m.Client.CallService(ctx, entityId, "press")
+	case "script", "scene":
+		return m.Client.CallService(ctx, entityId, "turn_on")
+	}
+	if on {
+		return m.Client.CallService(ctx, entityId, "turn_on")
+	}
+	return m.Client.CallService(ctx, entityId, "turn_off")
+}
+
+func (m *HomeAssistantModule) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{}
+	if m.Config.PowerOn != "" {
+		capabilities = append(capabilities, modules.CapabilityPowerOn)
+	}
+	if m.Config.PowerOff != "" {
+		capabilities = append(capabilities, modules.CapabilityPowerOff)
+	}
+	return capabilities
+}
+
+func (m *HomeAssistantModule) PowerOn(ctx context.Context) error {
+	if m.Config.PowerOn == "" {
+		return modules.ErrNotSupported
+	}
+	return m.action(ctx, m.Config.PowerOn, true)
+}
+
+func (m *HomeAssistantModule) PowerOff(ctx context.Context) error {
+	if m.Config.PowerOff == "" {
+		return modules.ErrNotSupported
+	}
+	return m.action(ctx, m.Config.PowerOff, false)
+}
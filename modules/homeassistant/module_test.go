@@ -0,0 +1,380 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const testToken = "eyJhbGciOiJIUzI1NiJ9.test"
+
+// homeAssistantStub is a stub of the Home Assistant REST API.
+type homeAssistantStub struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	token    string
+	entities map[string]string
+	// malformed replaces the successful responses with the given body
+	malformed string
+	// calls records the service calls, as "<domain>.<service> <entity>"
+	calls moduletest.Recorder[string]
+}
+
+func newHomeAssistantStub(t *testing.T, entities map[string]string) *homeAssistantStub {
+	t.Helper()
+	stub := &homeAssistantStub{token: testToken, entities: entities}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// configure changes the entities or the token while Home Assistant is
+// running.
+func (s *homeAssistantStub) configure(configure func(s *homeAssistantStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+// writeJSON answers with value, or with the malformed body when the request
+// succeeds.
+func (s *homeAssistantStub) writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	if s.malformed != "" && status == http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(s.malformed))
+		return
+	}
+	moduletest.WriteJSON(w, status, value)
+}
+
+func (s *homeAssistantStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, "401: Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if entityId, ok := strings.CutPrefix(r.URL.Path, "/api/states/"); ok && r.Method == http.MethodGet {
+		state, ok := s.entities[entityId]
+		if !ok {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"message": "Entity not found."})
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"entity_id": entityId, "state": state, "attributes": map[string]interface{}{}})
+		return
+	}
+
+	service, ok := strings.CutPrefix(r.URL.Path, "/api/services/")
+	if !ok || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var data struct {
+		EntityId string `json:"entity_id"`
+	}
+	if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&data) != nil {
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Data should be valid JSON."})
+		return
+	}
+	domain, name, _ := strings.Cut(service, "/")
+	if _, ok := s.entities[data.EntityId]; !ok || !strings.HasPrefix(data.EntityId, domain+".") {
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Referenced entities " + data.EntityId + " are missing or not currently available"})
+		return
+	}
+	s.calls.Record(domain + "." + name + " " + data.EntityId)
+	switch name {
+	case "turn_on":
+		s.entities[data.EntityId] = "on"
+	case "turn_off":
+		s.entities[data.EntityId] = "off"
+	}
+	s.writeJSON(w, http.StatusOK, []interface{}{})
+}
+
+func newTestModule(t *testing.T, stub *homeAssistantStub, config map[string]interface{}) *HomeAssistantModule {
+	t.Helper()
+	return newModule(t, stub.server.URL, config)
+}
+
+func newModule(t *testing.T, url string, config map[string]interface{}) *HomeAssistantModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*HomeAssistantModule), map[string]interface{}{"url": url, "token": testToken}, config)
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name          string
+		entities      map[string]string
+		config        map[string]interface{}
+		wantPower     modules.PowerState
+		wantReachable *bool
+		wantErrs      []string
+	}{
+		{"switch on", map[string]string{"switch.server": "on"}, map[string]interface{}{"entity": "switch.server"}, modules.PowerOn, newBool(true), nil},
+		{"switch off", map[string]string{"switch.server": "off"}, map[string]interface{}{"entity": "switch.server"}, modules.PowerOff, newBool(false), nil},
+		{
+			"unavailable",
+			map[string]string{"switch.server": "unavailable"},
+			map[string]interface{}{"entity": "switch.server"},
+			modules.PowerUnknown, nil,
+			[]string{"power: the switch.server entity is unavailable", "reachability: the switch.server entity is unavailable"},
+		},
+		{
+			"unknown",
+			map[string]string{"switch.server": "unknown"},
+			map[string]interface{}{"entity": "switch.server"},
+			modules.PowerUnknown, nil,
+			[]string{"power: the switch.server entity is unknown", "reachability: the switch.server entity is unknown"},
+		},
+		{
+			"missing entity",
+			map[string]string{},
+			map[string]interface{}{"entity": "switch.server"},
+			modules.PowerUnknown, nil,
+			[]string{
+				`power: error retrieving the state of switch.server: unexpected response from GET /api/states/switch.server (StatusCode: 404, Body: {"message":"Entity not found."}`,
+				"reachability: error retrieving the state of switch.server",
+			},
+		},
+		{
+			"state entity",
+			map[string]string{"button.wake": "2024-01-01T00:00:00+00:00", "input_boolean.server": "on"},
+			map[string]interface{}{"entity": "button.wake", "state": "input_boolean.server"},
+			modules.PowerOn, newBool(true), nil,
+		},
+		{
+			"reachability entity",
+			map[string]string{"switch.server": "on", "binary_sensor.server_ping": "off"},
+			map[string]interface{}{"entity": "switch.server", "reachability": "binary_sensor.server_ping"},
+			modules.PowerOn, newBool(false), nil,
+		},
+		{
+			"unavailable reachability entity",
+			map[string]string{"switch.server": "off", "binary_sensor.server_ping": "unavailable"},
+			map[string]interface{}{"entity": "switch.server", "reachability": "binary_sensor.server_ping"},
+			modules.PowerOff, nil,
+			[]string{"reachability: the binary_sensor.server_ping entity is unavailable"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newHomeAssistantStub(t, tt.entities)
+			module := newTestModule(t, stub, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.wantPower {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.wantPower, state.Errors)
+			}
+			if (state.Reachable == nil) != (tt.wantReachable == nil) || (state.Reachable != nil && *state.Reachable != *tt.wantReachable) {
+				t.Errorf("State().Reachable = %v, want %v", state.Reachable, tt.wantReachable)
+			}
+			if len(state.Errors) != len(tt.wantErrs) {
+				t.Fatalf("State().Errors = %v, want %v", state.Errors, tt.wantErrs)
+			}
+			for i, want := range tt.wantErrs {
+				if !strings.HasPrefix(state.Errors[i], want) {
+					t.Errorf("State().Errors[%d] = %q, want %q", i, state.Errors[i], want)
+				}
+			}
+		})
+	}
+}
+
+func newBool(value bool) *bool {
+	return &value
+}
+
+func TestPowerActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		entities map[string]string
+		config   map[string]interface{}
+		wantOn   string
+		wantOff  string
+	}{
+		{"switch", map[string]string{"switch.server": "off"}, map[string]interface{}{"entity": "switch.server"}, "switch.turn_on switch.server", "switch.turn_off switch.server"},
+		{"light", map[string]string{"light.server": "off"}, map[string]interface{}{"entity": "light.server"}, "light.turn_on light.server", "light.turn_off light.server"},
+		{
+			"button",
+			map[string]string{"button.power": "unknown", "binary_sensor.server": "off"},
+			map[string]interface{}{"entity": "button.power", "state": "binary_sensor.server"},
+			"button.press button.power", "button.press button.power",
+		},
+		{
+			"input button",
+			map[string]string{"input_button.power": "unknown", "binary_sensor.server": "off"},
+			map[string]interface{}{"entity": "input_button.power", "state": "binary_sensor.server"},
+			"input_button.press input_button.power", "input_button.press input_button.power",
+		},
+		{
+			"scripts",
+			map[string]string{"script.start": "off", "script.stop": "off", "binary_sensor.server": "off"},
+			map[string]interface{}{"power-on": "script.start", "power-off": "script.stop", "state": "binary_sensor.server"},
+			"script.turn_on script.start", "script.turn_on script.stop",
+		},
+		{
+			"scene",
+			map[string]string{"scene.server": "unknown", "switch.server": "off"},
+			map[string]interface{}{"entity": "switch.server", "power-on": "scene.server"},
+			"scene.turn_on scene.server", "switch.turn_off switch.server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newHomeAssistantStub(t, tt.entities)
+			module := newTestModule(t, stub, tt.config)
+
+			if err := module.PowerOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if err := module.PowerOff(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOff() error = %v", err)
+			}
+			want := []string{tt.wantOn, tt.wantOff}
+			if calls := stub.calls.Received(); !slices.Equal(calls, want) {
+				t.Errorf("calls = %v, want %v", calls, want)
+			}
+		})
+	}
+}
+
+func TestSwitchRoundTrip(t *testing.T) {
+	stub := newHomeAssistantStub(t, map[string]string{"switch.server": "off"})
+	module := newTestModule(t, stub, map[string]interface{}{"entity": "switch.server"})
+
+	if err := module.PowerOn(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOn() error = %v", err)
+	}
+	if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOn {
+		t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOn, state.Errors)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOff() error = %v", err)
+	}
+	if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOff {
+		t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOff, state.Errors)
+	}
+}
+
+func TestPowerActionsNotSupported(t *testing.T) {
+	stub := newHomeAssistantStub(t, map[string]string{"binary_sensor.server": "on"})
+	module := newTestModule(t, stub, map[string]interface{}{"state": "binary_sensor.server"})
+
+	if capabilities := module.Capabilities(); len(capabilities) != 0 {
+		t.Errorf("Capabilities() = %v, want none", capabilities)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("PowerOn() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("PowerOff() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+	if calls := stub.calls.Received(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestToken(t *testing.T) {
+	stub := newHomeAssistantStub(t, map[string]string{"switch.server": "on"})
+	module := newTestModule(t, stub, map[string]interface{}{"entity": "switch.server", "token": "invalid"})
+
+	state := module.State(moduletest.Context(t))
+	want := "power: error retrieving the state of switch.server: unexpected response from GET /api/states/switch.server (StatusCode: 401"
+	if state.Power != modules.PowerUnknown || len(state.Errors) == 0 || !strings.HasPrefix(state.Errors[0], want) {
+		t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, state.Errors, want)
+	}
+
+	err := module.PowerOn(moduletest.Context(t))
+	want = "error calling switch.turn_on on switch.server: unexpected response from POST /api/services/switch/turn_on (StatusCode: 401"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("PowerOn() error = %v, want %q", err, want)
+	}
+}
+
+func TestTokenRevoked(t *testing.T) {
+	stub := newHomeAssistantStub(t, map[string]string{"switch.server": "on"})
+	module := newTestModule(t, stub, map[string]interface{}{"entity": "switch.server"})
+
+	if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOn {
+		t.Fatalf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOn, state.Errors)
+	}
+	stub.configure(func(s *homeAssistantStub) { s.token = "eyJhbGciOiJIUzI1NiJ9.rotated" })
+
+	// The states polled before the revocation aren't reported anymore
+	state := module.State(moduletest.Context(t))
+	if state.Power != modules.PowerUnknown || len(state.Errors) != 2 || !strings.Contains(state.Errors[0], "StatusCode: 401") {
+		t.Errorf("State() = %s %v, want an unknown state with a 401 error", state.Power, state.Errors)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "StatusCode: 401") {
+		t.Errorf("PowerOff() error = %v, want a 401 error", err)
+	}
+	if calls := stub.calls.Received(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"truncated", `{"entity_id": "switch.server", "sta`, "power: error retrieving the state of switch.server: error decoding the JSON response"},
+		{"html page", "<!DOCTYPE html><html><body>Sign in</body></html>", "power: error retrieving the state of switch.server: error decoding the JSON response"},
+		{"without state", `{"entity_id": "switch.server"}`, "power: error retrieving the state of switch.server: the response has no state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newHomeAssistantStub(t, map[string]string{"switch.server": "on"})
+			stub.configure(func(s *homeAssistantStub) { s.malformed = tt.body })
+			module := newTestModule(t, stub, map[string]interface{}{"entity": "switch.server"})
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != modules.PowerUnknown || len(state.Errors) != 2 || !strings.HasPrefix(state.Errors[0], tt.wantErr) {
+				t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, state.Errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnreachableServer(t *testing.T) {
+	module := newModule(t, moduletest.ClosedAddress(t).String(), map[string]interface{}{"entity": "switch.server"})
+
+	state := module.State(moduletest.Context(t))
+	if state.Power != modules.PowerUnknown || len(state.Errors) != 2 || !strings.Contains(state.Errors[0], "error sending the request") {
+		t.Errorf("State() = %s %v, want an unknown state with a request error", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+		t.Errorf("PowerOn() error = %v, want a request error", err)
+	}
+}
+
+func TestServiceError(t *testing.T) {
+	stub := newHomeAssistantStub(t, map[string]string{"switch.server": "off"})
+	module := newTestModule(t, stub, map[string]interface{}{"entity": "switch.server"})
+	stub.configure(func(s *homeAssistantStub) { delete(s.entities, "switch.server") })
+
+	err := module.PowerOn(moduletest.Context(t))
+	if err == nil || !strings.Contains(err.Error(), "StatusCode: 400") || !strings.Contains(err.Error(), "missing or not currently available") {
+		t.Errorf("PowerOn() error = %v, want the reason of the failure", err)
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	err := New().Init(map[string]interface{}{"url": "homeassistant.local:8123", "token": testToken, "entity": "button.power"})
+	want := "the button.power entity has no on/off state, define a state entity"
+	if err == nil || err.Error() != want {
+		t.Errorf("Init() error = %v, want %s", err, want)
+	}
+}
@@ -2,14 +2,20 @@ package ilo
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 )
 
+// requestTimeout bounds every request sent to iLO, in addition to the
+// deadline of the context
+const requestTimeout = 30 * time.Second
+
 type PowerState string
 
 const (
@@ -29,23 +35,39 @@ type IloClient struct {
 	password string
 }
 
-func (c *IloClient) PushPowerButton() error {
-	// URL for the iLO endpoint to get power status
-	endpoint := c.url.JoinPath("/Systems/1/Actions/ComputerSystem.Reset/")
+type ResetType string
 
-	// Create the request body to initiate the button press (Action Reset with ResetType PushPowerButton)
-	reqBody := map[string]string{
-		"ResetType": "PushPowerButton",
-	}
+const (
+	ResetTypeOn               ResetType = "On"
+	ResetTypeForceOff         ResetType = "ForceOff"
+	ResetTypeGracefulShutdown ResetType = "GracefulShutdown"
+	ResetTypeForceRestart     ResetType = "ForceRestart"
+	ResetTypeNmi              ResetType = "Nmi"
+	ResetTypePushPowerButton  ResetType = "PushPowerButton"
+)
+
+type resetAction struct {
+	AllowableValues []ResetType `json:"ResetType@Redfish.AllowableValues"`
+}
 
+type systemActions struct {
+	Reset resetAction `json:"#ComputerSystem.Reset"`
+}
+
+type system struct {
+	Actions systemActions `json:"Actions"`
+}
+
+// post sends a JSON encoded body to an iLO endpoint and checks the response
+func (c *IloClient) post(ctx context.Context, endpoint *url.URL, reqBody interface{}) error {
 	// Encode the JSON data
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return fmt.Errorf("error encoding JSON: %w", err)
 	}
 
-	// Create an HTTP POST request to the iLO endpoint for the Reset action
-	req, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewBuffer(jsonData))
+	// Create an HTTP POST request to the iLO endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("error creating the request: %w", err)
 	}
@@ -60,13 +82,14 @@ func (c *IloClient) PushPowerButton() error {
 	tr := http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	client := http.Client{Transport: &tr}
+	client := http.Client{Transport: &tr, Timeout: requestTimeout}
 
-	// Send the request to iLO to initiate the button press (Action Reset with ResetType PushPowerButton)
+	// Send the request to iLO to perform the action
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("error sending the request: %w", err)
 	}
+	defer resp.Body.Close()
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
@@ -75,18 +98,98 @@ func (c *IloClient) PushPowerButton() error {
 		if err != nil {
 			return fmt.Errorf("error reading the response body: %w", err)
 		}
-		return fmt.Errorf("error retrieving server power status (StatusCode: %d, Body: %v)", resp.StatusCode, string(body))
+		return fmt.Errorf("error performing the action (StatusCode: %d, Body: %v)", resp.StatusCode, string(body))
 	}
 
 	return nil
 }
 
-func (c *IloClient) PowerState() (*PowerState, error) {
+// Reset performs the ComputerSystem.Reset action with the given reset type
+func (c *IloClient) Reset(ctx context.Context, resetType ResetType) error {
+	// URL for the iLO endpoint of the Reset action
+	endpoint := c.url.JoinPath("/Systems/1/Actions/ComputerSystem.Reset/")
+
+	// Create the request body of the Reset action
+	reqBody := map[string]ResetType{
+		"ResetType": resetType,
+	}
+
+	return c.post(ctx, endpoint, reqBody)
+}
+
+func (c *IloClient) PushPowerButton(ctx context.Context) error {
+	return c.Reset(ctx, ResetTypePushPowerButton)
+}
+
+// PressAndHold holds the power button down until the server is forced off,
+// using the HP OEM PowerButton action
+func (c *IloClient) PressAndHold(ctx context.Context) error {
+	// URL for the iLO endpoint of the system
+	endpoint := c.url.JoinPath("/Systems/1/")
+
+	// Create the request body of the OEM PowerButton action
+	reqBody := map[string]string{
+		"Action":   "PowerButton",
+		"PushType": "PressAndHold",
+		"Target":   "/Oem/Hp",
+	}
+
+	return c.post(ctx, endpoint, reqBody)
+}
+
+// AllowableResetTypes returns the reset types advertised by iLO for the
+// system, or nil when it does not advertise any
+func (c *IloClient) AllowableResetTypes(ctx context.Context) ([]ResetType, error) {
+	// URL for the iLO endpoint of the system
+	endpoint := c.url.JoinPath("/Systems/1/")
+
+	// Create an HTTP GET request to the iLO endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request: %w", err)
+	}
+
+	// Add the credentials to the request
+	req.SetBasicAuth(c.username, c.password)
+
+	// Ignore SSL certificate verification
+	tr := http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	client := http.Client{Transport: &tr, Timeout: requestTimeout}
+
+	// Send the request to iLO to get the system
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check the response status code
+	if resp.StatusCode != http.StatusOK {
+		// Read the response body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the response body: %w", err)
+		}
+		return nil, fmt.Errorf("error retrieving the system (StatusCode: %d, Body: %v)", resp.StatusCode, string(body))
+	}
+
+	// Parse the response body
+	var system system
+	err = json.NewDecoder(resp.Body).Decode(&system)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the JSON response: %w", err)
+	}
+	return system.Actions.Reset.AllowableValues, nil
+}
+
+func (c *IloClient) PowerState(ctx context.Context) (*PowerState, error) {
 	// URL for the iLO endpoint to get power status
 	endpoint := c.url.JoinPath("/Systems/1/")
 
 	// Create an HTTP GET request to the iLO endpoint
-	req, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating the request: %w", err)
 	}
@@ -98,7 +201,7 @@ func (c *IloClient) PowerState() (*PowerState, error) {
 	tr := http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	client := http.Client{Transport: &tr}
+	client := http.Client{Transport: &tr, Timeout: requestTimeout}
 
 	// Send the request to iLO to get power status
 	resp, err := client.Do(req)
@@ -128,10 +231,10 @@ func (c *IloClient) PowerState() (*PowerState, error) {
 
 func NewClient(baseUrl string, username string, password string) (*IloClient, error) {
 	parsedUrl, err := url.Parse(baseUrl)
-	parsedUrl.Scheme = "https"
-	parsedUrl = parsedUrl.JoinPath("/redfish/v1/")
 	if err != nil {
 		return nil, fmt.Errorf("error parsing the URL: %w", err)
 	}
+	parsedUrl.Scheme = "https"
+	parsedUrl = parsedUrl.JoinPath("/redfish/v1/")
 	return &IloClient{parsedUrl, username, password}, nil
 }
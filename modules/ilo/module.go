@@ -1,7 +1,12 @@
 package ilo
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/tr4cks/power/modules"
 )
@@ -10,6 +15,14 @@ type IloModule struct {
 	modules.DefaultModule
 	Config IloConfig
 	Client *IloClient
+
+	mu      sync.Mutex
+	allowed []ResetType
+	// discoveryErr is returned instead of discovering the reset types again
+	// until retryAt, which is pushed back after each failure
+	discoveryErr error
+	failures     int
+	retryAt      time.Time
 }
 
 type IloConfig struct {
@@ -19,6 +32,11 @@ type IloConfig struct {
 	Password string `validate:"required"`
 }
 
+const (
+	minDiscoveryBackoff = 30 * time.Second
+	maxDiscoveryBackoff = 10 * time.Minute
+)
+
 func New() modules.Module {
 	return &IloModule{}
 }
@@ -35,27 +53,119 @@ func (m *IloModule) Init(config map[string]interface{}) error {
 	return nil
 }
 
-func (m *IloModule) State() (modules.Result[bool], modules.Result[bool]) {
+func (m *IloModule) State(ctx context.Context) modules.State {
 	powerStateTask, powerStateChan := modules.MakeAsync(func() modules.Result[bool] {
-		value, err := m.Client.PowerState()
-		return modules.Result[bool]{err == nil && *value == PowerStateOn, err}
+		value, err := m.Client.PowerState(ctx)
+		if err == nil {
+			// The reset types are discovered along with the state, so that
+			// Capabilities doesn't have to, a failure is retried once the
+			// backoff expires
+			m.allowableResetTypes(ctx)
+		}
+		if err == nil && *value == PowerStateUnknown {
+			err = errors.New("iLO reports an unknown power state")
+		}
+		// A server being reset stays switched on
+		return modules.Result[bool]{Value: err == nil && (*value == PowerStateOn || *value == PowerReset), Err: err}
 	})
 
 	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
-		value, err := modules.Ping(m.Config.Hostname)
-		return modules.Result[bool]{value, err}
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
 	})
 
 	go powerStateTask()
 	go pingTask()
 
-	return <-powerStateChan, <-pingChan
+	return modules.NewState(<-powerStateChan, <-pingChan)
+}
+
+// PowerOn uses the On reset type when iLO allows it, which doesn't switch off
+// a server that is already running, and presses the power button otherwise.
+func (m *IloModule) PowerOn(ctx context.Context) error {
+	allowed, err := m.allowableResetTypes(ctx)
+	if err == nil && slices.Contains(allowed, ResetTypeOn) {
+		return m.Client.Reset(ctx, ResetTypeOn)
+	}
+	return m.Client.PushPowerButton(ctx)
 }
 
-func (m *IloModule) PowerOn() error {
-	return m.Client.PushPowerButton()
+func (m *IloModule) PowerOff(ctx context.Context) error {
+	return m.Client.PushPowerButton(ctx)
 }
 
-func (m *IloModule) PowerOff() error {
-	return m.Client.PushPowerButton()
+// allowableResetTypes returns the reset types advertised by iLO. They are
+// retrieved once and then reused. A failure is returned again without any
+// request until the backoff expires, the backoff doubling after each failure.
+func (m *IloModule) allowableResetTypes(ctx context.Context) ([]ResetType, error) {
+	m.mu.Lock()
+	allowed, discoveryErr, retryAt := m.allowed, m.discoveryErr, m.retryAt
+	m.mu.Unlock()
+	if allowed != nil {
+		return allowed, nil
+	}
+	if discoveryErr != nil && time.Now().Before(retryAt) {
+		return nil, discoveryErr
+	}
+
+	allowed, err := m.Client.AllowableResetTypes(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		backoff := min(minDiscoveryBackoff<<m.failures, maxDiscoveryBackoff)
+		if backoff < maxDiscoveryBackoff {
+			m.failures++
+		}
+		m.discoveryErr = err
+		m.retryAt = time.Now().Add(backoff)
+		return nil, err
+	}
+	if allowed == nil {
+		allowed = []ResetType{}
+	}
+	m.allowed = allowed
+	m.discoveryErr = nil
+	return allowed, nil
+}
+
+// extendedResetTypes contains the reset type of each extended action.
+var extendedResetTypes = []struct {
+	capability modules.Capability
+	resetType  ResetType
+}{
+	{modules.CapabilityForceOff, ResetTypeForceOff},
+	{modules.CapabilityGracefulShutdown, ResetTypeGracefulShutdown},
+	{modules.CapabilityForceRestart, ResetTypeForceRestart},
+	{modules.CapabilityNmi, ResetTypeNmi},
+}
+
+// Capabilities returns the extended actions matching the reset types
+// advertised by iLO, as discovered by the state polls and the power on, in
+// addition to the press and hold of the power button. Before they are
+// discovered, only the press and hold is returned.
+func (m *IloModule) Capabilities() modules.Capabilities {
+	m.mu.Lock()
+	allowed := m.allowed
+	m.mu.Unlock()
+
+	capabilities := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityPressAndHold}
+	for _, extended := range extendedResetTypes {
+		if slices.Contains(allowed, extended.resetType) {
+			capabilities = append(capabilities, extended.capability)
+		}
+	}
+	return capabilities
+}
+
+func (m *IloModule) Perform(ctx context.Context, action modules.Capability) error {
+	if action == modules.CapabilityPressAndHold {
+		return m.Client.PressAndHold(ctx)
+	}
+	for _, extended := range extendedResetTypes {
+		if extended.capability == action {
+			return m.Client.Reset(ctx, extended.resetType)
+		}
+	}
+	return modules.ErrNotSupported
 }
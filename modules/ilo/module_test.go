@@ -0,0 +1,313 @@
+package ilo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testUsername = "admin"
+	testPassword = "secret"
+)
+
+// iloStub is a local stand-in for the iLO 4 REST API of the first system.
+type iloStub struct {
+	server *httptest.Server
+
+	mutex      sync.Mutex
+	password   string
+	powerState PowerState
+	// allowed is advertised as ResetType@Redfish.AllowableValues
+	allowed []ResetType
+	// systemStatus is the status code answered to the system queries
+	systemStatus int
+	// malformed truncates the JSON answers to the system queries
+	malformed bool
+	// actions records the received reset types and OEM push types
+	actions       moduletest.Recorder[string]
+	systemQueries int
+}
+
+func newIloStub(t *testing.T, allowed []ResetType) *iloStub {
+	t.Helper()
+	stub := &iloStub{password: testPassword, powerState: PowerStateOff, allowed: allowed, systemStatus: http.StatusOK}
+	// The client only speaks HTTPS, without verifying the certificate
+	stub.server = httptest.NewTLSServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// configure changes the behaviour of the stub while it is running.
+func (s *iloStub) configure(configure func(s *iloStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func (s *iloStub) receivedSystemQueries() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.systemQueries
+}
+
+func (s *iloStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != testUsername || password != s.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/redfish/v1/Systems/1/":
+		s.systemQueries++
+		if s.systemStatus != http.StatusOK {
+			w.WriteHeader(s.systemStatus)
+			return
+		}
+		if s.malformed {
+			w.Write([]byte(`{"PowerState": "O`))
+			return
+		}
+		moduletest.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"PowerState": s.powerState,
+			"Actions": map[string]interface{}{
+				"#ComputerSystem.Reset": map[string]interface{}{"ResetType@Redfish.AllowableValues": s.allowed},
+			},
+		})
+	case r.Method == http.MethodPost && r.URL.Path == "/redfish/v1/Systems/1/":
+		var body struct{ Action, PushType string }
+		if json.NewDecoder(r.Body).Decode(&body) != nil || body.Action != "PowerButton" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.actions.Record(body.PushType)
+	case r.Method == http.MethodPost && r.URL.Path == "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset/":
+		var body struct{ ResetType ResetType }
+		if json.NewDecoder(r.Body).Decode(&body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.actions.Record(string(body.ResetType))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestModule(t *testing.T, stub *iloStub) *IloModule {
+	t.Helper()
+	return newModule(t, stub.server.URL)
+}
+
+func newModule(t *testing.T, url string) *IloModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*IloModule), nil, map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"url":      url,
+		"username": testUsername,
+		"password": testPassword,
+	})
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		powerState PowerState
+		want       modules.PowerState
+	}{
+		{PowerStateOn, modules.PowerOn},
+		{PowerReset, modules.PowerOn},
+		{PowerStateOff, modules.PowerOff},
+		{PowerStateUnknown, modules.PowerUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.powerState), func(t *testing.T) {
+			stub := newIloStub(t, nil)
+			stub.configure(func(s *iloStub) { s.powerState = tt.powerState })
+			module := newTestModule(t, stub)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.want {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+			}
+			if errors := moduletest.PowerErrors(state); (tt.want == modules.PowerUnknown) != (len(errors) == 1) {
+				t.Errorf("State() errors = %v", errors)
+			}
+		})
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowed      []ResetType
+		systemStatus int
+		powerOn      bool
+		want         ResetType
+	}{
+		{"on", []ResetType{ResetTypeOn, ResetTypePushPowerButton}, http.StatusOK, true, ResetTypeOn},
+		{"on with the power button", []ResetType{ResetTypePushPowerButton}, http.StatusOK, true, ResetTypePushPowerButton},
+		{"on without allowable values", nil, http.StatusOK, true, ResetTypePushPowerButton},
+		{"on after a discovery error", nil, http.StatusInternalServerError, true, ResetTypePushPowerButton},
+		{"off", []ResetType{ResetTypeOn, ResetTypePushPowerButton}, http.StatusOK, false, ResetTypePushPowerButton},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newIloStub(t, tt.allowed)
+			stub.configure(func(s *iloStub) { s.systemStatus = tt.systemStatus })
+			module := newTestModule(t, stub)
+
+			var err error
+			if tt.powerOn {
+				err = module.PowerOn(moduletest.Context(t))
+			} else {
+				err = module.PowerOff(moduletest.Context(t))
+			}
+			if err != nil {
+				t.Fatalf("power action error = %v", err)
+			}
+			if actions := stub.actions.Received(); !slices.Equal(actions, []string{string(tt.want)}) {
+				t.Errorf("actions = %v, want %s", actions, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	stub := newIloStub(t, []ResetType{ResetTypeOn, ResetTypeForceOff, ResetTypeNmi, ResetTypePushPowerButton})
+	module := newTestModule(t, stub)
+
+	// The reset types aren't discovered by Capabilities itself
+	basic := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityPressAndHold}
+	if got := module.Capabilities(); !slices.Equal(got, basic) {
+		t.Errorf("Capabilities() before the first poll = %v, want %v", got, basic)
+	}
+	if queries := stub.receivedSystemQueries(); queries != 0 {
+		t.Errorf("system queries = %d, want none", queries)
+	}
+
+	module.State(moduletest.Context(t))
+	want := append(basic, modules.CapabilityForceOff, modules.CapabilityNmi)
+	if got := module.Capabilities(); !slices.Equal(got, want) {
+		t.Errorf("Capabilities() = %v, want %v", got, want)
+	}
+	if err := module.Perform(moduletest.Context(t), modules.CapabilityNmi); err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	if err := module.Perform(moduletest.Context(t), modules.CapabilityPressAndHold); err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	if actions := stub.actions.Received(); !slices.Equal(actions, []string{"Nmi", "PressAndHold"}) {
+		t.Errorf("actions = %v, want [Nmi PressAndHold]", actions)
+	}
+	// The reset types are only retrieved once, the next polls only query the
+	// power state
+	module.State(moduletest.Context(t))
+	module.Capabilities()
+	if queries := stub.receivedSystemQueries(); queries != 3 {
+		t.Errorf("system queries = %d, want 3", queries)
+	}
+}
+
+func TestDiscoveryBackoff(t *testing.T) {
+	stub := newIloStub(t, []ResetType{ResetTypeOn, ResetTypeForceOff})
+	stub.configure(func(s *iloStub) { s.systemStatus = http.StatusServiceUnavailable })
+	module := newTestModule(t, stub)
+
+	basic := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityPressAndHold}
+	for range 3 {
+		module.allowableResetTypes(moduletest.Context(t))
+		if got := module.Capabilities(); !slices.Equal(got, basic) {
+			t.Errorf("Capabilities() = %v, want %v", got, basic)
+		}
+	}
+	// The failure is cached until the backoff expires
+	if queries := stub.receivedSystemQueries(); queries != 1 {
+		t.Errorf("system queries = %d, want 1", queries)
+	}
+
+	backoffs := []time.Duration{2 * minDiscoveryBackoff, 4 * minDiscoveryBackoff}
+	for _, backoff := range backoffs {
+		module.mu.Lock()
+		module.retryAt = time.Now()
+		module.mu.Unlock()
+		start := time.Now()
+		module.allowableResetTypes(moduletest.Context(t))
+		module.mu.Lock()
+		retryAt := module.retryAt
+		module.mu.Unlock()
+		// The backoff doubles after each failure
+		if delay := retryAt.Sub(start); delay < backoff || delay > backoff+time.Second {
+			t.Errorf("retry after %s, want %s", delay, backoff)
+		}
+	}
+
+	// The next poll retries the discovery once the backoff expired
+	stub.configure(func(s *iloStub) { s.systemStatus = http.StatusOK })
+	module.mu.Lock()
+	module.retryAt = time.Now()
+	module.mu.Unlock()
+	module.State(moduletest.Context(t))
+	want := append(basic, modules.CapabilityForceOff)
+	if got := module.Capabilities(); !slices.Equal(got, want) {
+		t.Errorf("Capabilities() = %v, want %v", got, want)
+	}
+	if queries := stub.receivedSystemQueries(); queries != 5 {
+		t.Errorf("system queries = %d, want 5", queries)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	stub := newIloStub(t, []ResetType{ResetTypeOn, ResetTypePushPowerButton})
+	module := newTestModule(t, stub)
+	stub.configure(func(s *iloStub) { s.password = "rotated" })
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "StatusCode: 401") {
+		t.Errorf("State() = %s %v, want a 401 error", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "StatusCode: 401") {
+		t.Errorf("PowerOn() error = %v, want a 401 error", err)
+	}
+	if actions := stub.actions.Received(); len(actions) != 0 {
+		t.Errorf("actions = %v, want none", actions)
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	stub := newIloStub(t, []ResetType{ResetTypeOn, ResetTypeForceOff})
+	stub.configure(func(s *iloStub) { s.malformed = true })
+	module := newTestModule(t, stub)
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error decoding the JSON response") {
+		t.Errorf("State() = %s %v, want a decoding error", state.Power, state.Errors)
+	}
+	basic := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityPressAndHold}
+	if got := module.Capabilities(); !slices.Equal(got, basic) {
+		t.Errorf("Capabilities() = %v, want %v", got, basic)
+	}
+}
+
+func TestUnreachableIlo(t *testing.T) {
+	module := newModule(t, "https://"+moduletest.ClosedAddress(t).String())
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error sending the request") {
+		t.Errorf("State() = %s %v, want a connection error", state.Power, state.Errors)
+	}
+	// The power button is pressed when the reset types can't be discovered
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+		t.Errorf("PowerOn() error = %v, want a connection error", err)
+	}
+}
@@ -1,10 +1,78 @@
 package modules
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotSupported is returned by the optional actions a module can't perform
+// with its current configuration.
+var ErrNotSupported = errors.New("action not supported by the module")
+
+// Module is implemented by every module. The operations receive a context
+// carrying their deadline, and must return as soon as it is done.
 type Module interface {
 	Init(config map[string]interface{}) error
-	State() (Result[bool] /* power */, Result[bool] /* led */)
-	PowerOn() error
-	PowerOff() error
+	// Capabilities returns the actions the module is able to perform with its
+	// current configuration. It is only called once Init succeeded.
+	Capabilities() Capabilities
+	State(ctx context.Context) State
+	PowerOn(ctx context.Context) error
+	PowerOff(ctx context.Context) error
+}
+
+type Capability string
+
+const (
+	CapabilityPowerOn   Capability = "power-on"
+	CapabilityPowerOff  Capability = "power-off"
+	CapabilitySuspend   Capability = "suspend"
+	CapabilityHibernate Capability = "hibernate"
+
+	// Extended actions
+	CapabilityForceOff         Capability = "force-off"
+	CapabilityGracefulShutdown Capability = "graceful-shutdown"
+	CapabilityForceRestart     Capability = "force-restart"
+	CapabilityPowerCycle       Capability = "power-cycle"
+	CapabilityPressAndHold     Capability = "press-and-hold"
+	CapabilityNmi              Capability = "nmi"
+)
+
+// ExtendedActions contains the actions performed by ActionPerformer.
+var ExtendedActions = []Capability{
+	CapabilityForceOff,
+	CapabilityGracefulShutdown,
+	CapabilityForceRestart,
+	CapabilityPowerCycle,
+	CapabilityPressAndHold,
+	CapabilityNmi,
+}
+
+type Capabilities []Capability
+
+func (c Capabilities) Has(capability Capability) bool {
+	for _, value := range c {
+		if value == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Sleeper is implemented by the modules able to put the server to sleep,
+// either by suspending it to memory or by hibernating it to disk. The server
+// is resumed with PowerOn. The supported actions are listed by Capabilities.
+type Sleeper interface {
+	Suspend(ctx context.Context) error
+	Hibernate(ctx context.Context) error
+}
+
+// ActionPerformer is implemented by the modules able to perform extended
+// actions, usually through a BMC: forcing the server off, resetting it,
+// holding its power button, etc. The supported actions are listed by
+// Capabilities.
+type ActionPerformer interface {
+	Perform(ctx context.Context, action Capability) error
 }
 
 type DefaultModule struct{}
@@ -13,14 +81,48 @@ func (*DefaultModule) Init(config map[string]interface{}) error {
 	return nil
 }
 
-func (*DefaultModule) State() (Result[bool], Result[bool]) {
-	return Result[bool]{}, Result[bool]{}
+func (*DefaultModule) Capabilities() Capabilities {
+	return Capabilities{CapabilityPowerOn, CapabilityPowerOff}
+}
+
+func (*DefaultModule) State(ctx context.Context) State {
+	return State{Power: PowerUnknown}
 }
 
-func (*DefaultModule) PowerOn() error {
+func (*DefaultModule) PowerOn(ctx context.Context) error {
 	return nil
 }
 
-func (*DefaultModule) PowerOff() error {
+func (*DefaultModule) PowerOff(ctx context.Context) error {
 	return nil
 }
+
+// Perform performs action with module, or returns ErrNotSupported when the
+// module doesn't support it.
+func Perform(ctx context.Context, module Module, action Capability) error {
+	if !module.Capabilities().Has(action) {
+		return ErrNotSupported
+	}
+
+	switch action {
+	case CapabilityPowerOn:
+		return module.PowerOn(ctx)
+	case CapabilityPowerOff:
+		return module.PowerOff(ctx)
+	case CapabilitySuspend, CapabilityHibernate:
+		sleeper, ok := module.(Sleeper)
+		if !ok {
+			return ErrNotSupported
+		}
+		if action == CapabilitySuspend {
+			return sleeper.Suspend(ctx)
+		}
+		return sleeper.Hibernate(ctx)
+	}
+
+	performer, ok := module.(ActionPerformer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return performer.Perform(ctx, action)
+}
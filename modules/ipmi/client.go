@@ -0,0 +1,58 @@
+package ipmi
+
+import (
+	"context"
+	"fmt"
+
+	goipmi "github.com/bougou/go-ipmi"
+)
+
+type IpmiClient struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+func (c *IpmiClient) session(ctx context.Context, routine func(client *goipmi.Client) error) error {
+	client, err := goipmi.NewClient(c.host, c.port, c.username, c.password)
+	if err != nil {
+		return fmt.Errorf("error creating the IPMI client: %w", err)
+	}
+
+	// Open an RMCP+ (IPMI v2.0 LAN) session with the BMC
+	err = client.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("error opening the RMCP+ session: %w", err)
+	}
+	defer client.Close(ctx)
+
+	return routine(client)
+}
+
+func (c *IpmiClient) ChassisControl(ctx context.Context, control goipmi.ChassisControl) error {
+	return c.session(ctx, func(client *goipmi.Client) error {
+		_, err := client.ChassisControl(ctx, control)
+		if err != nil {
+			return fmt.Errorf("error sending the chassis control command: %w", err)
+		}
+		return nil
+	})
+}
+
+func (c *IpmiClient) PowerIsOn(ctx context.Context) (bool, error) {
+	powerIsOn := false
+	err := c.session(ctx, func(client *goipmi.Client) error {
+		status, err := client.GetChassisStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("error retrieving the chassis status: %w", err)
+		}
+		powerIsOn = status.PowerIsOn
+		return nil
+	})
+	return powerIsOn, err
+}
+
+func NewClient(host string, port int, username string, password string) *IpmiClient {
+	return &IpmiClient{host, port, username, password}
+}
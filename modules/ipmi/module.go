@@ -0,0 +1,104 @@
+package ipmi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tr4cks/power/modules"
+
+	goipmi "github.com/bougou/go-ipmi"
+)
+
+const defaultPort = 623
+
+type IpmiModule struct {
+	modules.DefaultModule
+	Config IpmiConfig
+	Client *IpmiClient
+}
+
+type IpmiConfig struct {
+	Hostname string `validate:"required"`
+	Address  string `validate:"required"`
+	Port     int    `validate:"gte=0,lte=65535"`
+	Username string `validate:"required"`
+	Password string `validate:"required"`
+	PowerOff string `mapstructure:"power-off" validate:"omitempty,oneof=soft hard"`
+}
+
+func New() modules.Module {
+	return &IpmiModule{}
+}
+
+func (m *IpmiModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "ipmi", err)
+	}
+	if m.Config.Port == 0 {
+		m.Config.Port = defaultPort
+	}
+	if m.Config.PowerOff == "" {
+		m.Config.PowerOff = "soft"
+	}
+	m.Client = NewClient(m.Config.Address, m.Config.Port, m.Config.Username, m.Config.Password)
+	return nil
+}
+
+// chassisControls contains the chassis control command of each extended
+// action.
+var chassisControls = map[modules.Capability]goipmi.ChassisControl{
+	modules.CapabilityForceOff:         goipmi.ChassisControlPowerDown,
+	modules.CapabilityGracefulShutdown: goipmi.ChassisControlSoftShutdown,
+	modules.CapabilityForceRestart:     goipmi.ChassisControlHardReset,
+	modules.CapabilityPowerCycle:       goipmi.ChassisControlPowerCycle,
+	modules.CapabilityNmi:              goipmi.ChassisControlDiagnosticInterrupt,
+}
+
+func (m *IpmiModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{
+		modules.CapabilityPowerOn,
+		modules.CapabilityPowerOff,
+		modules.CapabilityForceOff,
+		modules.CapabilityGracefulShutdown,
+		modules.CapabilityForceRestart,
+		modules.CapabilityPowerCycle,
+		modules.CapabilityNmi,
+	}
+}
+
+func (m *IpmiModule) State(ctx context.Context) modules.State {
+	powerStateTask, powerStateChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := m.Client.PowerIsOn(ctx)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	go powerStateTask()
+	go pingTask()
+
+	return modules.NewState(<-powerStateChan, <-pingChan)
+}
+
+func (m *IpmiModule) PowerOn(ctx context.Context) error {
+	return m.Client.ChassisControl(ctx, goipmi.ChassisControlPowerUp)
+}
+
+func (m *IpmiModule) PowerOff(ctx context.Context) error {
+	if m.Config.PowerOff == "hard" {
+		return m.Client.ChassisControl(ctx, goipmi.ChassisControlPowerDown)
+	}
+	return m.Client.ChassisControl(ctx, goipmi.ChassisControlSoftShutdown)
+}
+
+func (m *IpmiModule) Perform(ctx context.Context, action modules.Capability) error {
+	control, ok := chassisControls[action]
+	if !ok {
+		return modules.ErrNotSupported
+	}
+	return m.Client.ChassisControl(ctx, control)
+}
@@ -0,0 +1,317 @@
+package ipmi
+
+import (
+	"crypto/rand"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	goipmi "github.com/bougou/go-ipmi"
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testUsername = "admin"
+	testPassword = "secret"
+)
+
+func newTestModule(t *testing.T, bmc *bmcSimulator, config map[string]interface{}) *IpmiModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*IpmiModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"address":  "127.0.0.1",
+		"port":     bmc.port(),
+		"username": testUsername,
+		"password": testPassword,
+	}, config)
+}
+
+func TestPowerOn(t *testing.T) {
+	bmc := newBmcSimulator(t, testUsername, testPassword)
+	module := newTestModule(t, bmc, nil)
+
+	if err := module.PowerOn(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOn() error = %v", err)
+	}
+	if controls := bmc.receivedControls(); !slices.Equal(controls, []goipmi.ChassisControl{goipmi.ChassisControlPowerUp}) {
+		t.Errorf("controls = %v, want power up", controls)
+	}
+	if !bmc.power() {
+		t.Error("the chassis is still powered off")
+	}
+}
+
+func TestPowerOff(t *testing.T) {
+	tests := []struct {
+		name     string
+		powerOff string
+		want     goipmi.ChassisControl
+	}{
+		{name: "default", powerOff: "", want: goipmi.ChassisControlSoftShutdown},
+		{name: "soft", powerOff: "soft", want: goipmi.ChassisControlSoftShutdown},
+		{name: "hard", powerOff: "hard", want: goipmi.ChassisControlPowerDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bmc := newBmcSimulator(t, testUsername, testPassword)
+			bmc.setPower(true)
+			module := newTestModule(t, bmc, map[string]interface{}{"power-off": tt.powerOff})
+
+			if err := module.PowerOff(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOff() error = %v", err)
+			}
+			if controls := bmc.receivedControls(); !slices.Equal(controls, []goipmi.ChassisControl{tt.want}) {
+				t.Errorf("controls = %v, want %v", controls, tt.want)
+			}
+			if bmc.power() {
+				t.Error("the chassis is still powered on")
+			}
+		})
+	}
+}
+
+func TestPerform(t *testing.T) {
+	tests := []struct {
+		action  modules.Capability
+		want    goipmi.ChassisControl
+		wantErr error
+	}{
+		{action: modules.CapabilityForceOff, want: goipmi.ChassisControlPowerDown},
+		{action: modules.CapabilityGracefulShutdown, want: goipmi.ChassisControlSoftShutdown},
+		{action: modules.CapabilityForceRestart, want: goipmi.ChassisControlHardReset},
+		{action: modules.CapabilityPowerCycle, want: goipmi.ChassisControlPowerCycle},
+		{action: modules.CapabilityNmi, want: goipmi.ChassisControlDiagnosticInterrupt},
+		{action: modules.CapabilitySuspend, wantErr: modules.ErrNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.action), func(t *testing.T) {
+			bmc := newBmcSimulator(t, testUsername, testPassword)
+			bmc.setPower(true)
+			module := newTestModule(t, bmc, nil)
+
+			err := module.Perform(moduletest.Context(t), tt.action)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Perform() error = %v, want %v", err, tt.wantErr)
+				}
+				if controls := bmc.receivedControls(); len(controls) != 0 {
+					t.Errorf("controls = %v, want none", controls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Perform() error = %v", err)
+			}
+			if controls := bmc.receivedControls(); !slices.Equal(controls, []goipmi.ChassisControl{tt.want}) {
+				t.Errorf("controls = %v, want %v", controls, tt.want)
+			}
+		})
+	}
+}
+
+func TestChassisStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status []byte
+		want   bool
+	}{
+		{name: "on", status: []byte{0x01, 0x00, 0x00}, want: true},
+		{name: "off", status: []byte{0x00, 0x00, 0x00}, want: false},
+		{name: "off with faults", status: []byte{0x7e, 0x1f, 0x7f}, want: false},
+		{name: "on with front panel byte", status: []byte{0x21, 0x10, 0x40, 0xf0}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bmc := newBmcSimulator(t, testUsername, testPassword)
+			bmc.configure(func(bmc *bmcSimulator) {
+				bmc.chassisStatus = tt.status
+			})
+			module := newTestModule(t, bmc, nil)
+
+			got, err := module.Client.PowerIsOn(moduletest.Context(t))
+			if err != nil {
+				t.Fatalf("PowerIsOn() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PowerIsOn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, on := range []bool{true, false} {
+		bmc := newBmcSimulator(t, testUsername, testPassword)
+		bmc.setPower(on)
+		module := newTestModule(t, bmc, nil)
+
+		state := module.State(moduletest.Context(t))
+		want := modules.PowerOff
+		if on {
+			want = modules.PowerOn
+		}
+		if state.Power != want {
+			t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, want, state.Errors)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(bmc *bmcSimulator)
+		config  map[string]interface{}
+		timeout time.Duration
+		wantErr string
+	}{
+		{
+			name:    "wrong password",
+			config:  map[string]interface{}{"password": "wrong"},
+			wantErr: "error opening the RMCP+ session",
+		},
+		{
+			name:    "unknown user",
+			config:  map[string]interface{}{"username": "operator"},
+			wantErr: "error opening the RMCP+ session",
+		},
+		{
+			name: "completion code",
+			prepare: func(bmc *bmcSimulator) {
+				bmc.completionCodes[commandGetChassisStatus] = 0xd5
+			},
+			wantErr: "error retrieving the chassis status",
+		},
+		{
+			name: "truncated response",
+			prepare: func(bmc *bmcSimulator) {
+				bmc.chassisStatus = []byte{0x01}
+			},
+			wantErr: "error retrieving the chassis status",
+		},
+		{
+			name: "unresponsive",
+			prepare: func(bmc *bmcSimulator) {
+				bmc.silent = true
+			},
+			timeout: 500 * time.Millisecond,
+			wantErr: "error retrieving the chassis status",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bmc := newBmcSimulator(t, testUsername, testPassword)
+			if tt.prepare != nil {
+				bmc.configure(tt.prepare)
+			}
+			module := newTestModule(t, bmc, tt.config)
+
+			ctx := moduletest.Context(t)
+			if tt.timeout != 0 {
+				ctx = moduletest.ContextWithTimeout(t, tt.timeout)
+			}
+			_, err := module.Client.PowerIsOn(ctx)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("PowerIsOn() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChassisControlError(t *testing.T) {
+	bmc := newBmcSimulator(t, testUsername, testPassword)
+	bmc.configure(func(bmc *bmcSimulator) {
+		bmc.completionCodes[commandChassisControl] = 0xcc
+	})
+	module := newTestModule(t, bmc, nil)
+
+	err := module.PowerOn(moduletest.Context(t))
+	if err == nil || !strings.Contains(err.Error(), "error sending the chassis control command") {
+		t.Errorf("PowerOn() error = %v, want a chassis control error", err)
+	}
+	if bmc.power() {
+		t.Error("the chassis has been powered on")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	tests := []struct {
+		name string
+		// between is called between the two calls of the module
+		between func(bmc *bmcSimulator)
+		wantErr string
+	}{
+		{
+			name:    "closed after each call",
+			between: func(bmc *bmcSimulator) {},
+		},
+		{
+			name: "closed after a failed command",
+			between: func(bmc *bmcSimulator) {
+				bmc.completionCodes[commandGetChassisStatus] = 0xd5
+			},
+			wantErr: "error retrieving the chassis status",
+		},
+		{
+			// The sessions don't outlive the calls, so that the BMC
+			// forgetting them, when restarted or once they have expired,
+			// doesn't affect the next call
+			name: "forgotten by the BMC",
+			between: func(bmc *bmcSimulator) {
+				clear(bmc.sessions)
+				rand.Read(bmc.guid[:])
+			},
+		},
+		{
+			name: "password changed",
+			between: func(bmc *bmcSimulator) {
+				bmc.password = "rotated"
+			},
+			wantErr: "error opening the RMCP+ session",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bmc := newBmcSimulator(t, testUsername, testPassword)
+			module := newTestModule(t, bmc, nil)
+
+			if _, err := module.Client.PowerIsOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("first PowerIsOn() error = %v", err)
+			}
+			bmc.configure(tt.between)
+			_, err := module.Client.PowerIsOn(moduletest.Context(t))
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("second PowerIsOn() error = %v, want %q", err, tt.wantErr)
+			}
+			bmc.configure(func(bmc *bmcSimulator) {
+				for id, session := range bmc.sessions {
+					if session.active {
+						t.Errorf("session %#x is still open", id)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestUnreachableBmc(t *testing.T) {
+	address := moduletest.ClosedUDPAddress(t)
+	module := moduletest.Init(t, New().(*IpmiModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"address":  address.IP.String(),
+		"port":     address.Port,
+		"username": testUsername,
+		"password": testPassword,
+	}, nil)
+
+	start := time.Now()
+	state := module.State(moduletest.ContextWithTimeout(t, time.Second))
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("State() took %s, want it to give up with the context", elapsed)
+	}
+	if errs := moduletest.PowerErrors(state); state.Power == modules.PowerOn || len(errs) != 1 || !strings.Contains(errs[0], "error opening the RMCP+ session") {
+		t.Errorf("State() = %s %v, want a session error", state.Power, state.Errors)
+	}
+}
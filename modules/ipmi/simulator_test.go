@@ -0,0 +1,380 @@
+package ipmi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	goipmi "github.com/bougou/go-ipmi"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	netFnChassis = 0x00
+	netFnApp     = 0x06
+
+	commandGetChassisStatus    = 0x01
+	commandChassisControl      = 0x02
+	commandGetChannelAuthCaps  = 0x38
+	commandSetSessionPrivilege = 0x3b
+	commandCloseSession        = 0x3c
+
+	completionInvalidCommand = 0xc1
+)
+
+// bmcSimulator is an in-process BMC answering the RMCP+ (IPMI v2.0 LAN)
+// requests of the go-ipmi client over UDP. Only the cipher suite 3
+// (RAKP-HMAC-SHA1, HMAC-SHA1-96, AES-CBC-128) is supported, as on most BMCs,
+// along with the chassis commands used by the module.
+type bmcSimulator struct {
+	conn     net.PacketConn
+	username string
+	password string
+	guid     [16]byte
+
+	controls moduletest.Recorder[goipmi.ChassisControl]
+
+	mutex   sync.Mutex
+	powerOn bool
+	// chassisStatus replaces the data of the chassis status responses
+	chassisStatus []byte
+	// completionCodes contains the completion code returned instead of
+	// performing the given chassis commands
+	completionCodes map[uint8]uint8
+	// silent drops the chassis commands, as an unresponsive BMC
+	silent bool
+
+	sessions      map[uint32]*simulatedSession
+	nextSessionID uint32
+}
+
+type simulatedSession struct {
+	consoleID   uint32
+	consoleRand [16]byte
+	bmcRand     [16]byte
+	role        uint8
+	username    []byte
+	sik         []byte
+	k2          []byte
+	active      bool
+}
+
+// newBmcSimulator starts a BMC accepting the given credentials, on a random
+// local port. It is stopped along with the test.
+func newBmcSimulator(t *testing.T, username string, password string) *bmcSimulator {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	s := &bmcSimulator{
+		conn:            conn,
+		username:        username,
+		password:        password,
+		completionCodes: make(map[uint8]uint8),
+		sessions:        make(map[uint32]*simulatedSession),
+		nextSessionID:   0x0100,
+	}
+	rand.Read(s.guid[:])
+	go s.serve()
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return s
+}
+
+func (s *bmcSimulator) port() int {
+	return s.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// configure changes the behaviour of the simulator while it is running.
+func (s *bmcSimulator) configure(configure func(s *bmcSimulator)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func (s *bmcSimulator) setPower(on bool) {
+	s.configure(func(s *bmcSimulator) {
+		s.powerOn = on
+	})
+}
+
+func (s *bmcSimulator) power() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.powerOn
+}
+
+func (s *bmcSimulator) receivedControls() []goipmi.ChassisControl {
+	return s.controls.Received()
+}
+
+func (s *bmcSimulator) serve() {
+	buffer := make([]byte, 1024)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if reply := s.handle(buffer[:n]); reply != nil {
+			s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func (s *bmcSimulator) handle(packet []byte) []byte {
+	rmcp := &goipmi.Rmcp{}
+	if err := rmcp.Unpack(packet); err != nil || rmcp.Session20 == nil {
+		return nil
+	}
+	header := rmcp.Session20.SessionHeader20
+	payload := rmcp.Session20.SessionPayload
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch header.PayloadType {
+	case goipmi.PayloadTypeRmcpOpenSessionRequest:
+		return reply(goipmi.PayloadTypeRmcpOpenSessionResponse, s.openSession(payload))
+	case goipmi.PayloadTypeRAKPMessage1:
+		return reply(goipmi.PayloadTypeRAKPMessage2, s.rakp1(payload))
+	case goipmi.PayloadTypeRAKPMessage3:
+		return reply(goipmi.PayloadTypeRAKPMessage4, s.rakp3(payload))
+	case goipmi.PayloadTypeIPMI:
+		if header.SessionID != 0 {
+			session, ok := s.sessions[header.SessionID]
+			if !ok || !session.active {
+				return nil
+			}
+			if header.PayloadEncrypted {
+				decrypted, ok := decryptPayload(session.k2[:16], payload)
+				if !ok {
+					return nil
+				}
+				payload = decrypted
+			}
+		}
+		response := s.command(payload)
+		if response == nil {
+			return nil
+		}
+		return reply(goipmi.PayloadTypeIPMI, response)
+	}
+	return nil
+}
+
+// reply packs a response payload, neither authenticated nor encrypted.
+func reply(payloadType goipmi.PayloadType, payload []byte) []byte {
+	rmcp := &goipmi.Rmcp{
+		RmcpHeader: goipmi.NewRmcpHeader(),
+		Session20: &goipmi.Session20{
+			SessionHeader20: &goipmi.SessionHeader20{
+				AuthType:      goipmi.AuthTypeRMCPPlus,
+				PayloadType:   payloadType,
+				PayloadLength: uint16(len(payload)),
+			},
+			SessionPayload: payload,
+		},
+	}
+	return rmcp.Pack()
+}
+
+func (s *bmcSimulator) openSession(request []byte) []byte {
+	if len(request) < 32 {
+		return nil
+	}
+	consoleID := binary.LittleEndian.Uint32(request[4:8])
+	response := []byte{request[0], 0, byte(goipmi.PrivilegeLevelAdministrator), 0}
+	response = binary.LittleEndian.AppendUint32(response, consoleID)
+
+	switch {
+	case goipmi.AuthAlg(request[12]) != goipmi.AuthAlgRAKP_HMAC_SHA1:
+		response[1] = byte(goipmi.RmcpStatusCodeInvalidAuthAlg)
+		return response
+	case goipmi.IntegrityAlg(request[20]) != goipmi.IntegrityAlg_HMAC_SHA1_96:
+		response[1] = byte(goipmi.RmcpStatusCodeInvalidIntegrityAlg)
+		return response
+	case goipmi.CryptAlg(request[28]) != goipmi.CryptAlg_AES_CBC_128:
+		response[1] = byte(goipmi.RmcpStatusCodeInvalidConfidentAlg)
+		return response
+	}
+
+	s.nextSessionID++
+	s.sessions[s.nextSessionID] = &simulatedSession{consoleID: consoleID}
+	response = binary.LittleEndian.AppendUint32(response, s.nextSessionID)
+	return append(response, request[8:32]...)
+}
+
+func (s *bmcSimulator) rakp1(request []byte) []byte {
+	if len(request) < 28 || len(request) < 28+int(request[27]) {
+		return nil
+	}
+	bmcID := binary.LittleEndian.Uint32(request[4:8])
+	session, ok := s.sessions[bmcID]
+	if !ok {
+		return []byte{request[0], byte(goipmi.RmcpStatusCodeInvalidSessionID), 0, 0, 0, 0, 0, 0}
+	}
+	response := []byte{request[0], 0, 0, 0}
+	response = binary.LittleEndian.AppendUint32(response, session.consoleID)
+
+	session.username = append([]byte(nil), request[28:28+int(request[27])]...)
+	if string(session.username) != s.username {
+		response[1] = byte(goipmi.RmcpStatusCodeUnauthorizedName)
+		return response
+	}
+	copy(session.consoleRand[:], request[8:24])
+	rand.Read(session.bmcRand[:])
+	session.role = request[24]
+
+	// The key exchange authentication code proves that the BMC knows the
+	// password of the user
+	input := binary.LittleEndian.AppendUint32(nil, session.consoleID)
+	input = binary.LittleEndian.AppendUint32(input, bmcID)
+	input = append(input, session.consoleRand[:]...)
+	input = append(input, session.bmcRand[:]...)
+	input = append(input, s.guid[:]...)
+	input = append(input, session.role, byte(len(session.username)))
+	input = append(input, session.username...)
+
+	response = append(response, session.bmcRand[:]...)
+	response = append(response, s.guid[:]...)
+	return append(response, hmacSha1(s.key(), input)...)
+}
+
+func (s *bmcSimulator) rakp3(request []byte) []byte {
+	if len(request) < 8 {
+		return nil
+	}
+	bmcID := binary.LittleEndian.Uint32(request[4:8])
+	session, ok := s.sessions[bmcID]
+	if !ok {
+		return []byte{request[0], byte(goipmi.RmcpStatusCodeInvalidSessionID), 0, 0, 0, 0, 0, 0}
+	}
+	response := []byte{request[0], 0, 0, 0}
+	response = binary.LittleEndian.AppendUint32(response, session.consoleID)
+
+	input := append([]byte(nil), session.bmcRand[:]...)
+	input = binary.LittleEndian.AppendUint32(input, session.consoleID)
+	input = append(input, session.role, byte(len(session.username)))
+	input = append(input, session.username...)
+	if !hmac.Equal(request[8:], hmacSha1(s.key(), input)) {
+		response[1] = byte(goipmi.RmcpStatusCodeInvalidIntegrityCheckValue)
+		return response
+	}
+
+	input = append([]byte(nil), session.consoleRand[:]...)
+	input = append(input, session.bmcRand[:]...)
+	input = append(input, session.role, byte(len(session.username)))
+	input = append(input, session.username...)
+	session.sik = hmacSha1(s.key(), input)
+	session.k2 = hmacSha1(session.sik, []byte{
+		0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02,
+		0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02,
+	})
+	session.active = true
+
+	input = append([]byte(nil), session.consoleRand[:]...)
+	input = binary.LittleEndian.AppendUint32(input, bmcID)
+	input = append(input, s.guid[:]...)
+	return append(response, hmacSha1(session.sik, input)[:12]...)
+}
+
+// key returns the password of the user, padded to 160 bits.
+func (s *bmcSimulator) key() []byte {
+	key := make([]byte, 20)
+	copy(key, s.password)
+	return key
+}
+
+// command answers an IPMI request message, or returns nil to drop it.
+func (s *bmcSimulator) command(request []byte) []byte {
+	if len(request) < 7 {
+		return nil
+	}
+	netFn := request[1] >> 2
+	command := request[5]
+	data := request[6 : len(request)-1]
+
+	completionCode, failed := s.completionCodes[command]
+	var responseData []byte
+	switch {
+	case netFn == netFnChassis && s.silent:
+		return nil
+	case netFn == netFnChassis && failed:
+	case netFn == netFnApp && command == commandGetChannelAuthCaps:
+		// IPMI v2.0 extended data, non-null usernames, IPMI v2.0 support
+		responseData = []byte{0x01, 0x80, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00}
+	case netFn == netFnApp && command == commandSetSessionPrivilege && len(data) > 0:
+		responseData = []byte{data[0]}
+	case netFn == netFnApp && command == commandCloseSession && len(data) >= 4:
+		delete(s.sessions, binary.LittleEndian.Uint32(data))
+	case netFn == netFnChassis && command == commandGetChassisStatus:
+		responseData = s.chassisStatus
+		if responseData == nil {
+			// The last power on was requested by a command, the power
+			// restore policy is "previous"
+			responseData = []byte{0x20, 0x10, 0x40}
+			if s.powerOn {
+				responseData[0] |= 0x01
+			}
+		}
+	case netFn == netFnChassis && command == commandChassisControl && len(data) > 0:
+		control := goipmi.ChassisControl(data[0])
+		s.controls.Record(control)
+		switch control {
+		case goipmi.ChassisControlPowerUp:
+			s.powerOn = true
+		case goipmi.ChassisControlPowerDown, goipmi.ChassisControlSoftShutdown:
+			s.powerOn = false
+		}
+	default:
+		completionCode = completionInvalidCommand
+	}
+
+	// The requester and responder addresses and LUNs are swapped, and the
+	// network function is the odd response one
+	response := []byte{request[3], (netFn+1)<<2 | request[4]&0x03, 0, request[0], request[4]&0xfc | request[1]&0x03, command, completionCode}
+	response[2] = checksum(response[0:2])
+	response = append(response, responseData...)
+	return append(response, checksum(response[3:]))
+}
+
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+func hmacSha1(key []byte, data []byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// decryptPayload decrypts an AES-CBC-128 payload, made of the initialization
+// vector followed by the encrypted data and its padding.
+func decryptPayload(key []byte, payload []byte) ([]byte, bool) {
+	if len(payload) < 2*aes.BlockSize || len(payload)%aes.BlockSize != 0 {
+		return nil, false
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, false
+	}
+	data := make([]byte, len(payload)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, payload[:aes.BlockSize]).CryptBlocks(data, payload[aes.BlockSize:])
+	padLength := int(data[len(data)-1])
+	if padLength >= len(data) {
+		return nil, false
+	}
+	return data[:len(data)-1-padLength], true
+}
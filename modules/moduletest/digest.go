@@ -0,0 +1,62 @@
+package moduletest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// DigestAuth checks the HTTP digest authentication of the requests received
+// by a stand-in, with the auth quality of protection.
+type DigestAuth struct {
+	Username string
+	Password string
+	Realm    string
+	Nonce    string
+	// Algorithm is MD5 when empty, or SHA-256
+	Algorithm string
+}
+
+func (d *DigestAuth) hash(values ...string) string {
+	var h hash.Hash
+	if d.Algorithm == "SHA-256" {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(strings.Join(values, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Valid reports whether r is authenticated with the expected credentials and
+// the current nonce.
+func (d *DigestAuth) Valid(r *http.Request) bool {
+	scheme, params, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || scheme != "Digest" {
+		return false
+	}
+	fields := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		fields[key] = strings.Trim(value, `"`)
+	}
+	ha1 := d.hash(d.Username, d.Realm, d.Password)
+	ha2 := d.hash(r.Method, r.URL.RequestURI())
+	response := d.hash(ha1, d.Nonce, fields["nc"], fields["cnonce"], "auth", ha2)
+	return fields["username"] == d.Username && fields["nonce"] == d.Nonce && fields["qop"] == "auth" && fields["response"] == response
+}
+
+// Challenge answers r with the 401 status and the challenge of the current
+// nonce.
+func (d *DigestAuth) Challenge(w http.ResponseWriter) {
+	challenge := fmt.Sprintf(`Digest realm="%s", nonce="%s", stale="false", qop="auth"`, d.Realm, d.Nonce)
+	if d.Algorithm != "" {
+		challenge += fmt.Sprintf(", algorithm=%s", d.Algorithm)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,143 @@
+// Package moduletest provides the helpers shared by the tests of the modules:
+// contexts bounded by a timeout, module initialization, recording of the
+// requests received by the stand-ins of the devices, and addresses on which
+// nothing listens.
+package moduletest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// Timeout bounds the module operations of a test, so that a module waiting
+// for an answer which never comes fails the test instead of blocking it.
+const Timeout = 10 * time.Second
+
+// Context returns a context canceled after Timeout, or at the end of the
+// test.
+func Context(t testing.TB) context.Context {
+	return ContextWithTimeout(t, Timeout)
+}
+
+// ContextWithTimeout returns a context canceled after timeout, or at the end
+// of the test.
+func ContextWithTimeout(t testing.TB, timeout time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// Init initializes module with the defaults overridden by config, a nil value
+// removing the key, and fails the test when the module rejects the
+// configuration.
+func Init[M modules.Module](t testing.TB, module M, defaults map[string]interface{}, config map[string]interface{}) M {
+	t.Helper()
+	moduleConfig := make(map[string]interface{}, len(defaults)+len(config))
+	for key, value := range defaults {
+		moduleConfig[key] = value
+	}
+	for key, value := range config {
+		if value == nil {
+			delete(moduleConfig, key)
+			continue
+		}
+		moduleConfig[key] = value
+	}
+	if err := module.Init(moduleConfig); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return module
+}
+
+// PowerErrors returns the errors of state about the power. The ping checking
+// the reachability may not be allowed where the tests run.
+func PowerErrors(state modules.State) []string {
+	var errs []string
+	for _, err := range state.Errors {
+		if strings.HasPrefix(err, "power: ") {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// WaitFor fails the test when condition isn't met within Timeout.
+func WaitFor(t testing.TB, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ClosedAddress returns a local TCP address on which nothing listens, the
+// connections being refused.
+func ClosedAddress(t testing.TB) *net.TCPAddr {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+	return address
+}
+
+// ClosedUDPAddress returns a local UDP address on which nothing listens, the
+// datagrams being dropped.
+func ClosedUDPAddress(t testing.TB) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+	return address
+}
+
+// WriteJSON answers a request with value encoded in JSON.
+func WriteJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Recorder records the requests received by a stand-in, as they are
+// received by the goroutine serving them and checked by the test.
+type Recorder[T any] struct {
+	mutex  sync.Mutex
+	values []T
+}
+
+func (r *Recorder[T]) Record(value T) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values = append(r.values, value)
+}
+
+// Received returns a copy of the recorded values.
+func (r *Recorder[T]) Received() []T {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.values)
+}
+
+// Reset forgets the recorded values.
+func (r *Recorder[T]) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values = nil
+}
@@ -0,0 +1,248 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+// message is a message published by a client of the broker.
+type message struct {
+	Topic   string
+	Payload string
+	Retain  bool
+}
+
+// brokerClient is a connection to the broker and its subscriptions.
+type brokerClient struct {
+	conn net.Conn
+	// id is the identifier of the connected client, guarded by the mutex of
+	// the broker
+	id     string
+	mutex  sync.Mutex
+	topics []string
+}
+
+func (c *brokerClient) write(packet packets.ControlPacket) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return packet.Write(c.conn)
+}
+
+func (c *brokerClient) subscribed(topic string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return slices.Contains(c.topics, topic)
+}
+
+// brokerStub is a minimal embedded MQTT 3.1.1 broker. It supports the exact
+// topic subscriptions and the retained messages, and delivers every message
+// with QoS 0. As the real brokers, it disconnects a client when another one
+// connects with the same identifier.
+type brokerStub struct {
+	listener net.Listener
+	// published records the messages published by the clients
+	published moduletest.Recorder[message]
+	// clientIds records the identifiers of the connections
+	clientIds moduletest.Recorder[string]
+
+	mutex    sync.Mutex
+	clients  map[*brokerClient]struct{}
+	retained map[string][]byte
+	connects int
+	// username and password are the credentials required to connect, when
+	// username isn't empty
+	username string
+	password string
+}
+
+func newBrokerStub(t *testing.T) *brokerStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &brokerStub{
+		listener: listener,
+		clients:  map[*brokerClient]struct{}{},
+		retained: map[string][]byte{},
+	}
+	go broker.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		broker.disconnectAll()
+	})
+	return broker
+}
+
+func (b *brokerStub) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *brokerStub) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &brokerClient{conn: conn}
+		b.mutex.Lock()
+		b.clients[client] = struct{}{}
+		b.mutex.Unlock()
+		go b.handle(client)
+	}
+}
+
+func (b *brokerStub) handle(client *brokerClient) {
+	defer func() {
+		client.conn.Close()
+		b.mutex.Lock()
+		delete(b.clients, client)
+		b.mutex.Unlock()
+	}()
+	for {
+		packet, err := packets.ReadPacket(client.conn)
+		if err != nil {
+			return
+		}
+		err = b.process(client, packet)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *brokerStub) process(client *brokerClient, packet packets.ControlPacket) error {
+	switch packet := packet.(type) {
+	case *packets.ConnectPacket:
+		connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		b.mutex.Lock()
+		b.connects++
+		if b.username != "" && (packet.Username != b.username || string(packet.Password) != b.password) {
+			connack.ReturnCode = packets.ErrRefusedNotAuthorised
+		} else {
+			for other := range b.clients {
+				if other != client && other.id == packet.ClientIdentifier {
+					other.conn.Close()
+				}
+			}
+			client.id = packet.ClientIdentifier
+		}
+		b.mutex.Unlock()
+		b.clientIds.Record(packet.ClientIdentifier)
+		err := client.write(connack)
+		if err == nil && connack.ReturnCode != packets.Accepted {
+			err = errors.New("connection refused")
+		}
+		return err
+	case *packets.SubscribePacket:
+		suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+		suback.MessageID = packet.MessageID
+		suback.ReturnCodes = make([]byte, len(packet.Topics))
+		client.mutex.Lock()
+		client.topics = append(client.topics, packet.Topics...)
+		client.mutex.Unlock()
+		err := client.write(suback)
+		if err != nil {
+			return err
+		}
+		for _, topic := range packet.Topics {
+			b.mutex.Lock()
+			payload, ok := b.retained[topic]
+			b.mutex.Unlock()
+			if ok {
+				err = client.write(newPublishPacket(topic, payload, true))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case *packets.PublishPacket:
+		b.published.Record(message{
+			Topic:   packet.TopicName,
+			Payload: string(packet.Payload),
+			Retain:  packet.Retain,
+		})
+		b.publish(packet.TopicName, packet.Payload, packet.Retain)
+		switch packet.Qos {
+		case 1:
+			puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+			puback.MessageID = packet.MessageID
+			return client.write(puback)
+		case 2:
+			pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+			pubrec.MessageID = packet.MessageID
+			return client.write(pubrec)
+		}
+		return nil
+	case *packets.PubrelPacket:
+		pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+		pubcomp.MessageID = packet.MessageID
+		return client.write(pubcomp)
+	case *packets.PingreqPacket:
+		return client.write(packets.NewControlPacket(packets.Pingresp))
+	case *packets.DisconnectPacket:
+		return errors.New("client disconnected")
+	default:
+		return fmt.Errorf("unexpected packet %v", packet)
+	}
+}
+
+func newPublishPacket(topic string, payload []byte, retain bool) *packets.PublishPacket {
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = topic
+	publish.Payload = payload
+	publish.Retain = retain
+	return publish
+}
+
+// publish sends a message to the subscribed clients, and keeps it for the
+// future subscriptions when it is retained.
+func (b *brokerStub) publish(topic string, payload []byte, retain bool) {
+	b.mutex.Lock()
+	if retain {
+		b.retained[topic] = payload
+	}
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for client := range b.clients {
+		clients = append(clients, client)
+	}
+	b.mutex.Unlock()
+
+	for _, client := range clients {
+		if client.subscribed(topic) {
+			// The delivered message only has the retain flag when it is sent
+			// for a new subscription
+			client.write(newPublishPacket(topic, payload, false))
+		}
+	}
+}
+
+// configure changes the settings of the broker while it is running.
+func (b *brokerStub) configure(configure func(b *brokerStub)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	configure(b)
+}
+
+func (b *brokerStub) connections() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.connects
+}
+
+// disconnectAll drops the connections, as a broker restart would.
+func (b *brokerStub) disconnectAll() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for client := range b.clients {
+		client.conn.Close()
+	}
+}
@@ -0,0 +1,292 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	clientIdPrefix   = "power"
+	defaultOnValue   = "ON"
+	operationTimeout = 10 * time.Second
+)
+
+type MqttModule struct {
+	modules.DefaultModule
+	Config MqttConfig
+	Client pahomqtt.Client
+
+	mu         sync.RWMutex
+	powerState *modules.Result[bool]
+	ledState   *modules.Result[bool]
+	received   chan struct{}
+	once       sync.Once
+}
+
+type TlsConfig struct {
+	CaFile             string `mapstructure:"ca-file"`
+	CertFile           string `mapstructure:"cert-file" validate:"required_with=KeyFile"`
+	KeyFile            string `mapstructure:"key-file" validate:"required_with=CertFile"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
+}
+
+type CommandConfig struct {
+	Topic   string `validate:"required"`
+	Payload string
+	Retain  bool
+}
+
+type StateConfig struct {
+	Topic string `validate:"required"`
+	// Field is the dot-separated path of the value in a JSON payload. The
+	// whole payload is used when it is empty.
+	Field   string
+	OnValue string `mapstructure:"on-value"`
+}
+
+type MqttConfig struct {
+	Hostname   string
+	Broker     string `validate:"required"`
+	ClientId   string `mapstructure:"client-id"`
+	Username   string
+	Password   string
+	Qos        byte `validate:"lte=2"`
+	Tls        *TlsConfig
+	PowerOn    *CommandConfig `mapstructure:"power-on"`
+	PowerOff   *CommandConfig `mapstructure:"power-off"`
+	PowerState StateConfig    `mapstructure:"power-state"`
+	LedState   *StateConfig   `mapstructure:"led-state"`
+}
+
+func New() modules.Module {
+	return &MqttModule{}
+}
+
+// defaultClientId returns a client identifier unique to this instance, as
+// "power-<hostname>-<random>", since the broker disconnects a client when
+// another one connects with the same identifier. It only contains the
+// characters and the 23 bytes every broker accepts.
+func defaultClientId() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	hostname, _ := os.Hostname()
+	hostname, _, _ = strings.Cut(hostname, ".")
+	hostname = strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, hostname)
+	// The prefix, the separators and the suffix leave 8 bytes for the hostname
+	if len(hostname) > 8 {
+		hostname = hostname[:8]
+	}
+	if hostname == "" {
+		return clientIdPrefix + "-" + hex.EncodeToString(suffix)
+	}
+	return clientIdPrefix + "-" + hostname + "-" + hex.EncodeToString(suffix)
+}
+
+func newTlsConfig(config *TlsConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CaFile != "" {
+		ca, err := os.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in the CA file %q", config.CaFile)
+		}
+	}
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// parseState extracts the state from a message payload and compares it to
+// the value meaning "on".
+func parseState(config *StateConfig, payload []byte) (bool, error) {
+	value := strings.TrimSpace(string(payload))
+	if config.Field != "" {
+		var document interface{}
+		err := json.Unmarshal(payload, &document)
+		if err != nil {
+			return false, fmt.Errorf("error decoding the JSON payload: %w", err)
+		}
+		for _, key := range strings.Split(config.Field, ".") {
+			object, ok := document.(map[string]interface{})
+			if !ok {
+				return false, fmt.Errorf("the payload has no %q field", config.Field)
+			}
+			document, ok = object[key]
+			if !ok {
+				return false, fmt.Errorf("the payload has no %q field", config.Field)
+			}
+		}
+		value = fmt.Sprint(document)
+	}
+	return strings.EqualFold(value, config.OnValue), nil
+}
+
+func (m *MqttModule) subscribe(config *StateConfig, store func(modules.Result[bool])) pahomqtt.Token {
+	return m.Client.Subscribe(config.Topic, m.Config.Qos, func(client pahomqtt.Client, message pahomqtt.Message) {
+		value, err := parseState(config, message.Payload())
+		store(modules.Result[bool]{Value: value, Err: err})
+	})
+}
+
+func (m *MqttModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "mqtt", err)
+	}
+	if m.Config.ClientId == "" {
+		m.Config.ClientId = defaultClientId()
+	}
+	// The defaults are set once here, the message handlers only read the
+	// configuration
+	if m.Config.PowerState.OnValue == "" {
+		m.Config.PowerState.OnValue = defaultOnValue
+	}
+	if m.Config.LedState != nil && m.Config.LedState.OnValue == "" {
+		m.Config.LedState.OnValue = defaultOnValue
+	}
+
+	options := pahomqtt.NewClientOptions().
+		AddBroker(m.Config.Broker).
+		SetClientID(m.Config.ClientId).
+		SetUsername(m.Config.Username).
+		SetPassword(m.Config.Password).
+		SetConnectTimeout(operationTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	if m.Config.Tls != nil {
+		tlsConfig, err := newTlsConfig(m.Config.Tls)
+		if err != nil {
+			return fmt.Errorf("error configuring TLS: %w", err)
+		}
+		options.SetTLSConfig(tlsConfig)
+	}
+
+	// The subscriptions are renewed on every (re)connection, the broker then
+	// sends back the retained states
+	options.SetOnConnectHandler(func(client pahomqtt.Client) {
+		m.subscribe(&m.Config.PowerState, func(state modules.Result[bool]) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.powerState = &state
+			m.once.Do(func() { close(m.received) })
+		})
+		if m.Config.LedState != nil {
+			m.subscribe(m.Config.LedState, func(state modules.Result[bool]) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				m.ledState = &state
+			})
+		}
+	})
+
+	m.received = make(chan struct{})
+	m.Client = pahomqtt.NewClient(options)
+	// With ConnectRetry, the client keeps trying in the background when the
+	// broker is unreachable at startup
+	m.Client.Connect()
+	return nil
+}
+
+func (m *MqttModule) cachedState(state *modules.Result[bool], topic string) modules.Result[bool] {
+	if state == nil && !m.Client.IsConnectionOpen() {
+		return modules.Result[bool]{Err: fmt.Errorf("not connected to the broker %s", m.Config.Broker)}
+	}
+	if state == nil {
+		return modules.Result[bool]{Err: fmt.Errorf("no state received yet on %q", topic)}
+	}
+	return *state
+}
+
+func (m *MqttModule) State(ctx context.Context) modules.State {
+	// Give the broker some time to deliver the retained state right after
+	// the connection
+	select {
+	case <-m.received:
+	case <-time.After(operationTimeout):
+	case <-ctx.Done():
+	}
+
+	m.mu.RLock()
+	powerState := m.cachedState(m.powerState, m.Config.PowerState.Topic)
+	var ledState modules.Result[bool]
+	if m.Config.LedState != nil {
+		ledState = m.cachedState(m.ledState, m.Config.LedState.Topic)
+	}
+	m.mu.RUnlock()
+
+	switch {
+	case m.Config.LedState != nil:
+		return modules.NewState(powerState, ledState)
+	case m.Config.Hostname != "":
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.NewState(powerState, modules.Result[bool]{Value: value, Err: err})
+	default:
+		return modules.NewState(powerState, powerState)
+	}
+}
+
+func (m *MqttModule) publish(ctx context.Context, config *CommandConfig) error {
+	token := m.Client.Publish(config.Topic, m.Config.Qos, config.Retain, config.Payload)
+	select {
+	case <-token.Done():
+	case <-time.After(operationTimeout):
+		return fmt.Errorf("timed out publishing on %q", config.Topic)
+	case <-ctx.Done():
+		return fmt.Errorf("error publishing on %q: %w", config.Topic, ctx.Err())
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("error publishing on %q: %w", config.Topic, err)
+	}
+	return nil
+}
+
+func (m *MqttModule) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{}
+	if m.Config.PowerOn != nil {
+		capabilities = append(capabilities, modules.CapabilityPowerOn)
+	}
+	if m.Config.PowerOff != nil {
+		capabilities = append(capabilities, modules.CapabilityPowerOff)
+	}
+	return capabilities
+}
+
+func (m *MqttModule) PowerOn(ctx context.Context) error {
+	if m.Config.PowerOn == nil {
+		return modules.ErrNotSupported
+	}
+	return m.publish(ctx, m.Config.PowerOn)
+}
+
+func (m *MqttModule) PowerOff(ctx context.Context) error {
+	if m.Config.PowerOff == nil {
+		return modules.ErrNotSupported
+	}
+	return m.publish(ctx, m.Config.PowerOff)
+}
@@ -0,0 +1,306 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	powerStateTopic = "server/power"
+	ledStateTopic   = "server/led"
+	commandTopic    = "server/set"
+)
+
+func newTestModule(t *testing.T, broker *brokerStub, config map[string]interface{}) *MqttModule {
+	t.Helper()
+	module := newDisconnectedModule(t, broker.url(), config)
+	// The messages published while the client connects are only sent once
+	// connected
+	moduletest.WaitFor(t, "the connection to the broker", module.Client.IsConnectionOpen)
+	return module
+}
+
+// newDisconnectedModule returns a module which may not manage to connect to
+// the broker.
+func newDisconnectedModule(t *testing.T, url string, config map[string]interface{}) *MqttModule {
+	t.Helper()
+	module := moduletest.Init(t, New().(*MqttModule), map[string]interface{}{
+		"broker":      url,
+		"power-state": map[string]interface{}{"topic": powerStateTopic},
+	}, config)
+	t.Cleanup(func() { module.Client.Disconnect(0) })
+	return module
+}
+
+// waitForPower polls the state of the module until it has the given power
+// state, the messages are delivered asynchronously.
+func waitForPower(t *testing.T, module *MqttModule, power modules.PowerState) modules.State {
+	t.Helper()
+	var state modules.State
+	moduletest.WaitFor(t, fmt.Sprintf("the %s power state", power), func() bool {
+		state = module.State(moduletest.Context(t))
+		return state.Power == power
+	})
+	return state
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		payload string
+		want    modules.PowerState
+		wantErr string
+	}{
+		{"default on value", nil, "ON", modules.PowerOn, ""},
+		{"case insensitive", nil, " on\n", modules.PowerOn, ""},
+		{"off", nil, "OFF", modules.PowerOff, ""},
+		{"custom on value", map[string]interface{}{"on-value": "1"}, "1", modules.PowerOn, ""},
+		{"custom off value", map[string]interface{}{"on-value": "1"}, "ON", modules.PowerOff, ""},
+		{"JSON field", map[string]interface{}{"field": "state.power"}, `{"state": {"power": "ON"}}`, modules.PowerOn, ""},
+		{"JSON boolean", map[string]interface{}{"field": "on", "on-value": "true"}, `{"on": true}`, modules.PowerOn, ""},
+		{"missing JSON field", map[string]interface{}{"field": "state.power"}, `{"state": "ON"}`, modules.PowerUnknown, `power: the payload has no "state.power" field`},
+		{"invalid JSON", map[string]interface{}{"field": "power"}, "ON", modules.PowerUnknown, "power: error decoding the JSON payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newBrokerStub(t)
+			broker.publish(powerStateTopic, []byte(tt.payload), true)
+			stateConfig := map[string]interface{}{"topic": powerStateTopic}
+			for key, value := range tt.config {
+				stateConfig[key] = value
+			}
+			module := newTestModule(t, broker, map[string]interface{}{"power-state": stateConfig})
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.want {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+			}
+			if tt.wantErr != "" && !slices.ContainsFunc(state.Errors, func(err string) bool { return strings.HasPrefix(err, tt.wantErr) }) {
+				t.Errorf("State().Errors = %v, want an error starting with %q", state.Errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLedState(t *testing.T) {
+	tests := []struct {
+		power, led    string
+		wantPower     modules.PowerState
+		wantReachable bool
+	}{
+		{"ON", "ON", modules.PowerOn, true},
+		{"ON", "OFF", modules.PowerOn, false},
+		{"OFF", "ON", modules.PowerOff, true},
+		{"OFF", "OFF", modules.PowerOff, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.power, tt.led), func(t *testing.T) {
+			broker := newBrokerStub(t)
+			broker.publish(powerStateTopic, []byte(tt.power), true)
+			broker.publish(ledStateTopic, []byte(tt.led), true)
+			module := newTestModule(t, broker, map[string]interface{}{
+				"led-state": map[string]interface{}{"topic": ledStateTopic},
+			})
+
+			// The LED state may arrive after the power state
+			state := waitForPower(t, module, tt.wantPower)
+			moduletest.WaitFor(t, "the LED state", func() bool {
+				state = module.State(moduletest.Context(t))
+				return state.Reachable != nil
+			})
+			if *state.Reachable != tt.wantReachable {
+				t.Errorf("State().Reachable = %v, want %t (errors: %v)", state.Reachable, tt.wantReachable, state.Errors)
+			}
+		})
+	}
+}
+
+func TestNoStateReceived(t *testing.T) {
+	broker := newBrokerStub(t)
+	module := newTestModule(t, broker, nil)
+
+	state := module.State(moduletest.ContextWithTimeout(t, 200*time.Millisecond))
+	if state.Power != modules.PowerUnknown {
+		t.Errorf("State().Power = %s, want %s", state.Power, modules.PowerUnknown)
+	}
+	want := fmt.Sprintf("power: no state received yet on %q", powerStateTopic)
+	if !slices.Contains(state.Errors, want) {
+		t.Errorf("State().Errors = %v, want %q", state.Errors, want)
+	}
+}
+
+func TestStateUpdate(t *testing.T) {
+	broker := newBrokerStub(t)
+	broker.publish(powerStateTopic, []byte("OFF"), true)
+	module := newTestModule(t, broker, nil)
+	waitForPower(t, module, modules.PowerOff)
+
+	broker.publish(powerStateTopic, []byte("ON"), false)
+	waitForPower(t, module, modules.PowerOn)
+}
+
+func TestReconnect(t *testing.T) {
+	broker := newBrokerStub(t)
+	broker.publish(powerStateTopic, []byte("1"), true)
+	module := newTestModule(t, broker, map[string]interface{}{
+		"power-state": map[string]interface{}{"topic": powerStateTopic, "on-value": "1"},
+		"led-state":   map[string]interface{}{"topic": ledStateTopic},
+	})
+	waitForPower(t, module, modules.PowerOn)
+
+	// The states keep being parsed while the client reconnects and renews
+	// its subscriptions
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			broker.publish(powerStateTopic, []byte("1"), true)
+			broker.publish(ledStateTopic, []byte("ON"), true)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	broker.disconnectAll()
+	<-done
+
+	moduletest.WaitFor(t, "the reconnection", func() bool { return broker.connections() >= 2 })
+
+	broker.publish(powerStateTopic, []byte("0"), true)
+	waitForPower(t, module, modules.PowerOff)
+	broker.publish(powerStateTopic, []byte("1"), true)
+	waitForPower(t, module, modules.PowerOn)
+	if module.Config.PowerState.OnValue != "1" || module.Config.LedState.OnValue != defaultOnValue {
+		t.Errorf("on values = %q and %q, want %q and %q", module.Config.PowerState.OnValue, module.Config.LedState.OnValue, "1", defaultOnValue)
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	for _, qos := range []int{0, 1, 2} {
+		t.Run(fmt.Sprintf("qos %d", qos), func(t *testing.T) {
+			broker := newBrokerStub(t)
+			module := newTestModule(t, broker, map[string]interface{}{
+				"qos":       qos,
+				"power-on":  map[string]interface{}{"topic": commandTopic, "payload": "ON"},
+				"power-off": map[string]interface{}{"topic": commandTopic, "payload": "OFF", "retain": true},
+			})
+
+			if capabilities := module.Capabilities(); !capabilities.Has(modules.CapabilityPowerOn) || !capabilities.Has(modules.CapabilityPowerOff) {
+				t.Errorf("Capabilities() = %v, want power-on and power-off", capabilities)
+			}
+			if err := module.PowerOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if err := module.PowerOff(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOff() error = %v", err)
+			}
+
+			// With QoS 0, the publication completes before the broker reads it
+			moduletest.WaitFor(t, "the published messages", func() bool { return len(broker.published.Received()) >= 2 })
+			want := []message{
+				{Topic: commandTopic, Payload: "ON", Retain: false},
+				{Topic: commandTopic, Payload: "OFF", Retain: true},
+			}
+			if got := broker.published.Received(); !slices.Equal(got, want) {
+				t.Errorf("published messages = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPowerActionsNotSupported(t *testing.T) {
+	broker := newBrokerStub(t)
+	module := newTestModule(t, broker, nil)
+
+	if capabilities := module.Capabilities(); len(capabilities) != 0 {
+		t.Errorf("Capabilities() = %v, want none", capabilities)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("PowerOn() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("PowerOff() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+}
+
+func TestClientId(t *testing.T) {
+	broker := newBrokerStub(t)
+	broker.publish(powerStateTopic, []byte("ON"), true)
+	first := newTestModule(t, broker, nil)
+	second := newTestModule(t, broker, nil)
+	named := newTestModule(t, broker, map[string]interface{}{"client-id": "server-power"})
+
+	ids := []string{first.Config.ClientId, second.Config.ClientId}
+	if ids[0] == ids[1] {
+		t.Errorf("client ids = %v, want different ids", ids)
+	}
+	for _, id := range ids {
+		if !strings.HasPrefix(id, "power-") || len(id) > 23 {
+			t.Errorf("client id = %q, want a power- prefix and at most 23 bytes", id)
+		}
+	}
+	if id := named.Config.ClientId; id != "server-power" {
+		t.Errorf("client id = %q, want the configured one", id)
+	}
+
+	// The clients don't take over the connections of each other
+	for _, module := range []*MqttModule{first, second, named} {
+		waitForPower(t, module, modules.PowerOn)
+	}
+	if connections := broker.connections(); connections != 3 {
+		t.Errorf("connections = %d, want 3 (client ids: %v)", connections, broker.clientIds.Received())
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	broker := newBrokerStub(t)
+	broker.configure(func(broker *brokerStub) {
+		broker.username = "power"
+		broker.password = "secret"
+	})
+	broker.publish(powerStateTopic, []byte("ON"), true)
+
+	module := newTestModule(t, broker, map[string]interface{}{"username": "power", "password": "secret"})
+	waitForPower(t, module, modules.PowerOn)
+
+	// The broker refuses the connection once the password has changed,
+	// while the module keeps trying to reconnect
+	broker.configure(func(broker *brokerStub) {
+		broker.password = "rotated"
+	})
+	broker.disconnectAll()
+	moduletest.WaitFor(t, "the refused reconnection", func() bool { return broker.connections() >= 3 })
+	if module.Client.IsConnectionOpen() {
+		t.Error("the client is connected with a wrong password")
+	}
+
+	refused := newDisconnectedModule(t, broker.url(), map[string]interface{}{"username": "power", "password": "secret"})
+	state := refused.State(moduletest.ContextWithTimeout(t, 200*time.Millisecond))
+	want := "power: not connected to the broker " + broker.url()
+	if state.Power != modules.PowerUnknown || !slices.Contains(state.Errors, want) {
+		t.Errorf("State() = %s %v, want %q", state.Power, state.Errors, want)
+	}
+}
+
+func TestUnreachableBroker(t *testing.T) {
+	url := "tcp://" + moduletest.ClosedAddress(t).String()
+	module := newDisconnectedModule(t, url, map[string]interface{}{
+		"power-on": map[string]interface{}{"topic": commandTopic, "payload": "ON"},
+	})
+
+	state := module.State(moduletest.ContextWithTimeout(t, 200*time.Millisecond))
+	want := "power: not connected to the broker " + url
+	if state.Power != modules.PowerUnknown || !slices.Contains(state.Errors, want) {
+		t.Errorf("State() = %s %v, want %q", state.Power, state.Errors, want)
+	}
+	err := module.PowerOn(moduletest.ContextWithTimeout(t, 200*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "error publishing on") {
+		t.Errorf("PowerOn() error = %v, want a publication error", err)
+	}
+}
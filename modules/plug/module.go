@@ -0,0 +1,132 @@
+package plug
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+const requestTimeout = 10 * time.Second
+
+type PlugClient interface {
+	SetRelay(ctx context.Context, on bool) error
+	Relay(ctx context.Context) (bool, error)
+	// Wattage returns nil when the plug has no power meter.
+	Wattage(ctx context.Context) (*float64, error)
+}
+
+type PlugModule struct {
+	modules.DefaultModule
+	Config PlugConfig
+	Client PlugClient
+}
+
+type PlugConfig struct {
+	Hostname string `validate:"required"`
+	Url      string `validate:"required"`
+	Type     string `validate:"required,oneof=tasmota shelly shelly-gen2"`
+	Relay    int    `validate:"gte=0"`
+	Username string
+	Password string
+	// WattageThreshold is the power drawn by the server, in watts, below
+	// which it is reported as switched off while the relay is on. It only
+	// affects the state, the relay is never switched by it.
+	WattageThreshold float64 `mapstructure:"wattage-threshold" validate:"gte=0"`
+}
+
+func New() modules.Module {
+	return &PlugModule{}
+}
+
+func NewClient(config *PlugConfig) (PlugClient, error) {
+	baseUrl := config.Url
+	if !strings.Contains(baseUrl, "://") {
+		baseUrl = "http://" + baseUrl
+	}
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+	client := http.Client{Timeout: requestTimeout}
+
+	switch config.Type {
+	case "tasmota":
+		return &TasmotaClient{parsedUrl, config.Relay, config.Username, config.Password, client}, nil
+	case "shelly":
+		return &ShellyClient{parsedUrl, config.Relay, config.Username, config.Password, client}, nil
+	default:
+		if config.Username != "" {
+			client.Transport = &modules.DigestTransport{Username: config.Username, Password: config.Password}
+		}
+		return &ShellyRpcClient{parsedUrl, config.Relay, client}, nil
+	}
+}
+
+func (m *PlugModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "plug", err)
+	}
+	m.Client, err = NewClient(&m.Config)
+	if err != nil {
+		return fmt.Errorf("error creating plug client: %w", err)
+	}
+	return nil
+}
+
+// idle reports whether the server draws less than the wattage threshold.
+func (m *PlugModule) idle(wattage *float64) bool {
+	return m.Config.WattageThreshold > 0 && wattage != nil && *wattage < m.Config.WattageThreshold
+}
+
+// State reports the power drawn by the server as the wattage attribute, when
+// the plug has a power meter. The server is switched off when it draws less
+// than the wattage threshold, even though the relay is on.
+func (m *PlugModule) State(ctx context.Context) modules.State {
+	relayTask, relayChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := m.Client.Relay(ctx)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	wattageTask, wattageChan := modules.MakeAsync(func() modules.Result[*float64] {
+		value, err := m.Client.Wattage(ctx)
+		return modules.Result[*float64]{Value: value, Err: err}
+	})
+
+	go relayTask()
+	go pingTask()
+	go wattageTask()
+
+	state := modules.NewState(<-relayChan, <-pingChan)
+	wattage := <-wattageChan
+	if wattage.Err != nil {
+		state.AddError("wattage", wattage.Err)
+	} else if wattage.Value != nil {
+		state.SetAttribute("wattage", *wattage.Value)
+	}
+	if state.Power == modules.PowerOn && m.idle(wattage.Value) {
+		state.Power = modules.PowerOff
+	}
+	return state
+}
+
+// PowerOn switches the relay on. A server which has been shut down while the
+// relay is on isn't started again, cutting its power supply to boot it could
+// interrupt an operating system still running below the wattage threshold.
+func (m *PlugModule) PowerOn(ctx context.Context) error {
+	return m.Client.SetRelay(ctx, true)
+}
+
+func (m *PlugModule) PowerOff(ctx context.Context) error {
+	return m.Client.SetRelay(ctx, false)
+}
@@ -0,0 +1,517 @@
+package plug
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testUsername = "admin"
+	testPassword = "secret"
+	digestRealm  = "shellyplus1pm-a8032ab12345"
+	digestNonce  = "60dc59c3"
+)
+
+// plugStub is a local stand-in for the HTTP API of a Tasmota, first
+// generation Shelly or second generation Shelly plug.
+type plugStub struct {
+	server *httptest.Server
+	kind   string
+
+	// auth checks the credentials of the requests, as the digest
+	// authentication of the second generation, or directly otherwise
+	auth moduletest.DigestAuth
+	// switches records the relay state changes, as "<relay>:<on>"
+	switches moduletest.Recorder[string]
+
+	mutex  sync.Mutex
+	relays []bool
+	// powers contains the power drawn on each relay, nil when the plug has
+	// no power meter
+	powers []float64
+	// malformed truncates the JSON responses
+	malformed bool
+}
+
+func newPlugStub(t *testing.T, kind string, relays int) *plugStub {
+	t.Helper()
+	stub := &plugStub{
+		kind:   kind,
+		relays: make([]bool, relays),
+		auth: moduletest.DigestAuth{
+			Username:  testUsername,
+			Password:  testPassword,
+			Realm:     digestRealm,
+			Nonce:     digestNonce,
+			Algorithm: "SHA-256",
+		},
+	}
+	var handler http.HandlerFunc
+	switch kind {
+	case "tasmota":
+		handler = stub.tasmota
+	case "shelly":
+		handler = stub.shelly
+	default:
+		handler = stub.shellyRpc
+	}
+	stub.server = httptest.NewServer(handler)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// configure changes the state of the plug while it is running.
+func (s *plugStub) configure(configure func(s *plugStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func (s *plugStub) relay(relay int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.relays[relay]
+}
+
+func (s *plugStub) setRelay(relay int, on bool) {
+	s.relays[relay] = on
+	s.switches.Record(fmt.Sprintf("%d:%t", relay, on))
+}
+
+// writeJSON answers with value, truncated when the responses are malformed.
+func (s *plugStub) writeJSON(w http.ResponseWriter, value interface{}) {
+	if s.malformed {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output": tr`))
+		return
+	}
+	moduletest.WriteJSON(w, http.StatusOK, value)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// tasmota serves the /cm endpoint, authenticated with query parameters.
+func (s *plugStub) tasmota(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	query := r.URL.Query()
+	if r.URL.Path != "/cm" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if query.Get("user") != s.auth.Username || query.Get("password") != s.auth.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	command, argument, _ := strings.Cut(query.Get("cmnd"), " ")
+	if command == "STATUS" && argument == "8" {
+		energy := map[string]interface{}{}
+		if len(s.powers) == 1 {
+			energy["ENERGY"] = map[string]interface{}{"Power": s.powers[0]}
+		} else if s.powers != nil {
+			energy["ENERGY"] = map[string]interface{}{"Power": s.powers}
+		}
+		s.writeJSON(w, map[string]interface{}{"StatusSNS": energy})
+		return
+	}
+
+	relay := 0
+	if strings.HasPrefix(command, "POWER") && command != "POWER" {
+		index, err := strconv.Atoi(strings.TrimPrefix(command, "POWER"))
+		if err != nil || index < 1 || index > len(s.relays) {
+			s.writeJSON(w, map[string]string{"Command": "Unknown"})
+			return
+		}
+		relay = index - 1
+	} else if command != "POWER" {
+		s.writeJSON(w, map[string]string{"Command": "Unknown"})
+		return
+	}
+	switch argument {
+	case "":
+	case "ON", "OFF":
+		s.setRelay(relay, argument == "ON")
+	default:
+		s.writeJSON(w, map[string]string{"WARNING": "Invalid argument"})
+		return
+	}
+
+	// Devices with several relays name the first one POWER1
+	name := "POWER"
+	if len(s.relays) > 1 {
+		name = fmt.Sprintf("POWER%d", relay+1)
+	}
+	s.writeJSON(w, map[string]string{name: onOff(s.relays[relay])})
+}
+
+// shelly serves the first generation API, protected by basic authentication.
+func (s *plugStub) shelly(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != s.auth.Username || password != s.auth.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/status" {
+		meters := []map[string]interface{}{}
+		for _, power := range s.powers {
+			meters = append(meters, map[string]interface{}{"power": power, "is_valid": true})
+		}
+		s.writeJSON(w, map[string]interface{}{"meters": meters})
+		return
+	}
+	index, ok := strings.CutPrefix(r.URL.Path, "/relay/")
+	relay, err := strconv.Atoi(index)
+	if !ok || err != nil || relay >= len(s.relays) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.URL.Query().Get("turn") {
+	case "":
+	case "on", "off":
+		s.setRelay(relay, r.URL.Query().Get("turn") == "on")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, map[string]interface{}{"ison": s.relays[relay]})
+}
+
+// shellyRpc serves the second generation RPC API, protected by digest
+// authentication with SHA-256, as the Shelly Plus and Pro devices.
+func (s *plugStub) shellyRpc(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.auth.Valid(r) {
+		s.auth.Challenge(w)
+		return
+	}
+
+	query := r.URL.Query()
+	relay, err := strconv.Atoi(query.Get("id"))
+	if err != nil || relay >= len(s.relays) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.URL.Path {
+	case "/rpc/Switch.Set":
+		wasOn := s.relays[relay]
+		on, err := strconv.ParseBool(query.Get("on"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.setRelay(relay, on)
+		s.writeJSON(w, map[string]interface{}{"was_on": wasOn})
+	case "/rpc/Switch.GetStatus":
+		status := map[string]interface{}{"id": relay, "output": s.relays[relay]}
+		if s.powers != nil {
+			status["apower"] = s.powers[relay]
+		}
+		s.writeJSON(w, status)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestModule(t *testing.T, stub *plugStub, config map[string]interface{}) *PlugModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*PlugModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"url":      stub.server.URL,
+		"type":     stub.kind,
+		"username": testUsername,
+		"password": testPassword,
+	}, config)
+}
+
+var plugTypes = []string{"tasmota", "shelly", "shelly-gen2"}
+
+func TestRelay(t *testing.T) {
+	for _, kind := range plugTypes {
+		for _, relay := range []int{0, 1} {
+			t.Run(fmt.Sprintf("%s/%d", kind, relay), func(t *testing.T) {
+				stub := newPlugStub(t, kind, 2)
+				module := newTestModule(t, stub, map[string]interface{}{"relay": relay})
+
+				if err := module.PowerOn(moduletest.Context(t)); err != nil {
+					t.Fatalf("PowerOn() error = %v", err)
+				}
+				if !stub.relay(relay) || stub.relay(1-relay) {
+					t.Fatalf("relay %d hasn't been switched on alone", relay)
+				}
+				if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOn {
+					t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOn, state.Errors)
+				}
+
+				if err := module.PowerOff(moduletest.Context(t)); err != nil {
+					t.Fatalf("PowerOff() error = %v", err)
+				}
+				if stub.relay(relay) {
+					t.Fatalf("relay %d hasn't been switched off", relay)
+				}
+				if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOff {
+					t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOff, state.Errors)
+				}
+			})
+		}
+	}
+}
+
+func TestSingleRelayTasmota(t *testing.T) {
+	stub := newPlugStub(t, "tasmota", 1)
+	stub.configure(func(stub *plugStub) {
+		stub.relays[0] = true
+	})
+	module := newTestModule(t, stub, nil)
+
+	on, err := module.Client.Relay(moduletest.Context(t))
+	if err != nil {
+		t.Fatalf("Relay() error = %v", err)
+	}
+	if !on {
+		t.Error("Relay() = false, want true")
+	}
+}
+
+func TestWattage(t *testing.T) {
+	for _, kind := range plugTypes {
+		t.Run(kind, func(t *testing.T) {
+			stub := newPlugStub(t, kind, 2)
+			stub.configure(func(stub *plugStub) {
+				stub.relays[1] = true
+				stub.powers = []float64{0, 42.5}
+			})
+			module := newTestModule(t, stub, map[string]interface{}{"relay": 1})
+
+			state := module.State(moduletest.Context(t))
+			if wattage := state.Attributes["wattage"]; wattage != 42.5 {
+				t.Errorf("wattage = %v, want 42.5 (errors: %v)", wattage, state.Errors)
+			}
+		})
+	}
+}
+
+func TestWattageWithoutMeter(t *testing.T) {
+	for _, kind := range plugTypes {
+		t.Run(kind, func(t *testing.T) {
+			stub := newPlugStub(t, kind, 1)
+			module := newTestModule(t, stub, nil)
+
+			state := module.State(moduletest.Context(t))
+			if _, ok := state.Attributes["wattage"]; ok {
+				t.Errorf("State().Attributes = %v, want no wattage", state.Attributes)
+			}
+			for _, err := range state.Errors {
+				if strings.HasPrefix(err, "wattage") {
+					t.Errorf("State() error = %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	for _, kind := range plugTypes {
+		t.Run(kind, func(t *testing.T) {
+			stub := newPlugStub(t, kind, 1)
+			module := newTestModule(t, stub, map[string]interface{}{"password": "wrong"})
+
+			if _, err := module.Client.Relay(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "401") {
+				t.Errorf("Relay() error = %v, want an unauthorized error", err)
+			}
+			if err := module.PowerOn(moduletest.Context(t)); err == nil {
+				t.Error("PowerOn() succeeded with a wrong password")
+			}
+			if stub.relay(0) {
+				t.Error("the relay has been switched on with a wrong password")
+			}
+		})
+	}
+}
+
+func TestTasmotaRejectedCommand(t *testing.T) {
+	stub := newPlugStub(t, "tasmota", 1)
+	module := newTestModule(t, stub, map[string]interface{}{"relay": 3})
+
+	err := module.PowerOn(moduletest.Context(t))
+	if err == nil || !strings.Contains(err.Error(), "was rejected") {
+		t.Errorf("PowerOn() error = %v, want a rejected command error", err)
+	}
+}
+
+func TestWattageThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		relay   bool
+		powers  []float64
+		want    modules.PowerState
+		wantErr bool
+	}{
+		{name: "relay off", relay: false, powers: []float64{0}, want: modules.PowerOff},
+		{name: "below threshold", relay: true, powers: []float64{3.2}, want: modules.PowerOff},
+		{name: "above threshold", relay: true, powers: []float64{65}, want: modules.PowerOn},
+		{name: "no power meter", relay: true, want: modules.PowerOn},
+	}
+	for _, kind := range plugTypes {
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				stub := newPlugStub(t, kind, 1)
+				stub.configure(func(stub *plugStub) {
+					stub.relays[0] = tt.relay
+					stub.powers = tt.powers
+				})
+				module := newTestModule(t, stub, map[string]interface{}{"wattage-threshold": 10})
+
+				state := module.State(moduletest.Context(t))
+				if state.Power != tt.want {
+					t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+				}
+			})
+		}
+	}
+}
+
+func TestPowerOnBelowThreshold(t *testing.T) {
+	tests := []struct {
+		name         string
+		relay        bool
+		power        float64
+		wantSwitches []string
+	}{
+		{name: "relay off", relay: false, power: 0, wantSwitches: []string{"0:true"}},
+		// The power supply of a server drawing less than the threshold
+		// isn't cut, it may still be running
+		{name: "below threshold", relay: true, power: 2, wantSwitches: []string{"0:true"}},
+		{name: "running", relay: true, power: 80, wantSwitches: []string{"0:true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newPlugStub(t, "shelly-gen2", 1)
+			stub.configure(func(stub *plugStub) {
+				stub.relays[0] = tt.relay
+				stub.powers = []float64{tt.power}
+			})
+			module := newTestModule(t, stub, map[string]interface{}{"wattage-threshold": 10})
+
+			if err := module.PowerOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if switches := stub.switches.Received(); !slices.Equal(switches, tt.wantSwitches) {
+				t.Errorf("switches = %v, want %v", switches, tt.wantSwitches)
+			}
+			if !stub.relay(0) {
+				t.Error("the relay is off")
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		// between is called between the two calls of the module
+		between func(stub *plugStub)
+		wantErr string
+	}{
+		{
+			// A new nonce is answered to the next request with a new
+			// challenge, as when the plug restarts
+			name: "nonce rotated",
+			between: func(stub *plugStub) {
+				stub.auth.Nonce = "7a1fc09e"
+			},
+		},
+		{
+			name: "password changed",
+			between: func(stub *plugStub) {
+				stub.auth.Password = "rotated"
+			},
+			wantErr: "401",
+		},
+	}
+	for _, kind := range plugTypes {
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				stub := newPlugStub(t, kind, 1)
+				module := newTestModule(t, stub, nil)
+
+				if _, err := module.Client.Relay(moduletest.Context(t)); err != nil {
+					t.Fatalf("first Relay() error = %v", err)
+				}
+				stub.configure(tt.between)
+				err := module.PowerOn(moduletest.Context(t))
+				if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+					t.Errorf("PowerOn() error = %v, want %q", err, tt.wantErr)
+				}
+				if on := stub.relay(0); on != (tt.wantErr == "") {
+					t.Errorf("relay = %t, want %t", on, tt.wantErr == "")
+				}
+			})
+		}
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	for _, kind := range plugTypes {
+		t.Run(kind, func(t *testing.T) {
+			stub := newPlugStub(t, kind, 1)
+			stub.configure(func(stub *plugStub) {
+				stub.relays[0] = true
+				stub.powers = []float64{42}
+				stub.malformed = true
+			})
+			module := newTestModule(t, stub, nil)
+
+			state := module.State(moduletest.Context(t))
+			if errs := moduletest.PowerErrors(state); state.Power == modules.PowerOn || len(errs) != 1 || !strings.Contains(errs[0], "error decoding the JSON response") {
+				t.Errorf("State() = %s %v, want a decoding error", state.Power, state.Errors)
+			}
+			if _, ok := state.Attributes["wattage"]; ok {
+				t.Errorf("State().Attributes = %v, want no wattage", state.Attributes)
+			}
+		})
+	}
+}
+
+func TestUnreachablePlug(t *testing.T) {
+	for _, kind := range plugTypes {
+		t.Run(kind, func(t *testing.T) {
+			module := moduletest.Init(t, New().(*PlugModule), map[string]interface{}{
+				"hostname": "127.0.0.1",
+				"url":      moduletest.ClosedAddress(t).String(),
+				"type":     kind,
+				"username": testUsername,
+				"password": testPassword,
+			}, nil)
+
+			state := module.State(moduletest.Context(t))
+			if errs := moduletest.PowerErrors(state); state.Power == modules.PowerOn || len(errs) != 1 || !strings.Contains(errs[0], "error sending the request") {
+				t.Errorf("State() = %s %v, want a connection error", state.Power, state.Errors)
+			}
+			if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+				t.Errorf("PowerOn() error = %v, want a connection error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package plug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func getJSON(ctx context.Context, client *http.Client, endpoint *url.URL, username string, password string, response interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading the response body: %w", err)
+		}
+		return fmt.Errorf("unexpected response from %s (StatusCode: %d, Body: %v)", endpoint.Path, resp.StatusCode, string(body))
+	}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return fmt.Errorf("error decoding the JSON response: %w", err)
+	}
+	return nil
+}
+
+// ShellyClient drives a first generation Shelly relay through its /relay
+// HTTP endpoint.
+type ShellyClient struct {
+	url      *url.URL
+	relay    int
+	username string
+	password string
+	client   http.Client
+}
+
+type shellyRelay struct {
+	IsOn bool `json:"ison"`
+}
+
+func (c *ShellyClient) relayEndpoint(query url.Values) *url.URL {
+	endpoint := c.url.JoinPath("/relay", strconv.Itoa(c.relay))
+	endpoint.RawQuery = query.Encode()
+	return endpoint
+}
+
+func (c *ShellyClient) SetRelay(ctx context.Context, on bool) error {
+	turn := "off"
+	if on {
+		turn = "on"
+	}
+	var relay shellyRelay
+	return getJSON(ctx, &c.client, c.relayEndpoint(url.Values{"turn": []string{turn}}), c.username, c.password, &relay)
+}
+
+func (c *ShellyClient) Relay(ctx context.Context) (bool, error) {
+	var relay shellyRelay
+	err := getJSON(ctx, &c.client, c.relayEndpoint(nil), c.username, c.password, &relay)
+	return relay.IsOn, err
+}
+
+func (c *ShellyClient) Wattage(ctx context.Context) (*float64, error) {
+	var status struct {
+		Meters []struct {
+			Power float64 `json:"power"`
+		} `json:"meters"`
+	}
+	err := getJSON(ctx, &c.client, c.url.JoinPath("/status"), c.username, c.password, &status)
+	if err != nil {
+		return nil, err
+	}
+	if c.relay >= len(status.Meters) {
+		return nil, nil
+	}
+	return &status.Meters[c.relay].Power, nil
+}
+
+// ShellyRpcClient drives a second generation (Plus, Pro) Shelly switch
+// through its RPC over HTTP API. These devices use digest authentication.
+type ShellyRpcClient struct {
+	url    *url.URL
+	relay  int
+	client http.Client
+}
+
+type shellySwitchStatus struct {
+	Output bool     `json:"output"`
+	APower *float64 `json:"apower"`
+}
+
+func (c *ShellyRpcClient) call(ctx context.Context, method string, params url.Values, response interface{}) error {
+	endpoint := c.url.JoinPath("/rpc", method)
+	params.Set("id", strconv.Itoa(c.relay))
+	endpoint.RawQuery = params.Encode()
+	return getJSON(ctx, &c.client, endpoint, "", "", response)
+}
+
+func (c *ShellyRpcClient) SetRelay(ctx context.Context, on bool) error {
+	var response struct{}
+	return c.call(ctx, "Switch.Set", url.Values{"on": []string{strconv.FormatBool(on)}}, &response)
+}
+
+func (c *ShellyRpcClient) Relay(ctx context.Context) (bool, error) {
+	var status shellySwitchStatus
+	err := c.call(ctx, "Switch.GetStatus", url.Values{}, &status)
+	return status.Output, err
+}
+
+func (c *ShellyRpcClient) Wattage(ctx context.Context) (*float64, error) {
+	var status shellySwitchStatus
+	err := c.call(ctx, "Switch.GetStatus", url.Values{}, &status)
+	return status.APower, err
+}
@@ -0,0 +1,130 @@
+package plug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TasmotaClient drives a Tasmota relay through its /cm HTTP endpoint.
+type TasmotaClient struct {
+	url      *url.URL
+	relay    int
+	username string
+	password string
+	client   http.Client
+}
+
+func (c *TasmotaClient) command(ctx context.Context, command string, response interface{}) error {
+	endpoint := c.url.JoinPath("/cm")
+	query := url.Values{"cmnd": []string{command}}
+	if c.username != "" {
+		query.Set("user", c.username)
+		query.Set("password", c.password)
+	}
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading the response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error sending the %q command (StatusCode: %d, Body: %v)", command, resp.StatusCode, string(body))
+	}
+	// Tasmota answers with 200 even when the command is rejected
+	if strings.Contains(string(body), `"WARNING"`) || strings.Contains(string(body), `"Command":"Unknown"`) {
+		return fmt.Errorf("the %q command was rejected (Body: %v)", command, string(body))
+	}
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		return fmt.Errorf("error decoding the JSON response: %w", err)
+	}
+	return nil
+}
+
+// powerCommand returns the name of the command for the configured relay,
+// "POWER" for the first one and "POWERn" for the others.
+func (c *TasmotaClient) powerCommand() string {
+	if c.relay == 0 {
+		return "POWER"
+	}
+	return fmt.Sprintf("POWER%d", c.relay+1)
+}
+
+func (c *TasmotaClient) powerResponse(ctx context.Context, command string) (bool, error) {
+	var response map[string]interface{}
+	err := c.command(ctx, command, &response)
+	if err != nil {
+		return false, err
+	}
+	value, ok := response[c.powerCommand()].(string)
+	if !ok && c.relay == 0 {
+		// Devices with several relays always answer with POWER1
+		value, ok = response["POWER1"].(string)
+	}
+	if !ok {
+		return false, fmt.Errorf("the response has no %s field", c.powerCommand())
+	}
+	return value == "ON", nil
+}
+
+func (c *TasmotaClient) SetRelay(ctx context.Context, on bool) error {
+	state := "OFF"
+	if on {
+		state = "ON"
+	}
+	_, err := c.powerResponse(ctx, c.powerCommand()+" "+state)
+	return err
+}
+
+func (c *TasmotaClient) Relay(ctx context.Context) (bool, error) {
+	return c.powerResponse(ctx, c.powerCommand())
+}
+
+func (c *TasmotaClient) Wattage(ctx context.Context) (*float64, error) {
+	var response struct {
+		StatusSNS struct {
+			Energy *struct {
+				Power json.RawMessage `json:"Power"`
+			} `json:"ENERGY"`
+		} `json:"StatusSNS"`
+	}
+	err := c.command(ctx, "STATUS 8", &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusSNS.Energy == nil {
+		return nil, nil
+	}
+
+	// Power is a number on single channel devices and an array otherwise
+	var power float64
+	err = json.Unmarshal(response.StatusSNS.Energy.Power, &power)
+	if err == nil {
+		return &power, nil
+	}
+	var powers []float64
+	err = json.Unmarshal(response.StatusSNS.Energy.Power, &powers)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the energy power: %w", err)
+	}
+	if c.relay >= len(powers) {
+		return nil, nil
+	}
+	return &powers[c.relay], nil
+}
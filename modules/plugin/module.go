@@ -0,0 +1,340 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	callTimeout      = 60 * time.Second
+	// A crashing plugin is restarted on the next call, but not more often
+	// than restartDelay.
+	restartDelay = 5 * time.Second
+)
+
+// process is a running plugin executable.
+type process struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan Response
+	exited    chan struct{}
+	nextId    uint64
+}
+
+func startProcess(path string) (*process, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+	// The plugin runs in its own process group, so that killing it also
+	// kills the processes it started.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating the stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating the stdout pipe: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error starting %q: %w", path, err)
+	}
+
+	p := &process{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan Response),
+		exited:    make(chan struct{}),
+	}
+	go func() {
+		defer close(p.exited)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var response Response
+			err := json.Unmarshal(scanner.Bytes(), &response)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid response from %q: %s\n", path, err)
+				continue
+			}
+			select {
+			case p.responses <- response:
+			case <-time.After(time.Second):
+				// Nobody is waiting anymore for this response
+			}
+		}
+		cmd.Wait()
+	}()
+	return p, nil
+}
+
+func (p *process) call(ctx context.Context, method string, params interface{}, result interface{}, timeout time.Duration) error {
+	p.nextId++
+	request := Request{JsonRpc: "2.0", Id: p.nextId, Method: method}
+	if params != nil {
+		var err error
+		request.Params, err = json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("error encoding the parameters: %w", err)
+		}
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding the request: %w", err)
+	}
+	_, err = p.stdin.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing the request: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case response := <-p.responses:
+			if response.Id != request.Id {
+				continue
+			}
+			if response.Error != nil {
+				return response.Error
+			}
+			if result != nil {
+				err := json.Unmarshal(response.Result, result)
+				if err != nil {
+					return fmt.Errorf("error decoding the result: %w", err)
+				}
+			}
+			return nil
+		case <-p.exited:
+			return errExited
+		case <-timer.C:
+			return fmt.Errorf("no response to %q after %s", method, timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *process) kill() {
+	p.stdin.Close()
+	syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	<-p.exited
+}
+
+var errExited = errors.New("the plugin exited unexpectedly")
+
+// PluginModule proxies the module calls to an external module executable.
+type PluginModule struct {
+	Path   string
+	config map[string]interface{}
+
+	// mutex is held for the whole duration of the calls
+	mutex     sync.Mutex
+	process   *process
+	lastStart time.Time
+	// capabilities holds the modules.Capabilities of the running plugin, so
+	// that they can be read while a call is in progress
+	capabilities atomic.Value
+}
+
+func New(path string) modules.Module {
+	return &PluginModule{Path: path}
+}
+
+// start launches the executable, then checks the protocol version and
+// initializes the module.
+func (m *PluginModule) start(ctx context.Context) error {
+	m.lastStart = time.Now()
+
+	p, err := startProcess(m.Path)
+	if err != nil {
+		return err
+	}
+
+	var handshake HandshakeResult
+	err = p.call(ctx, MethodHandshake, HandshakeParams{ProtocolVersion}, &handshake, handshakeTimeout)
+	if err != nil {
+		p.kill()
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	if handshake.ProtocolVersion != ProtocolVersion {
+		p.kill()
+		return fmt.Errorf("unsupported protocol version %d (expected: %d)", handshake.ProtocolVersion, ProtocolVersion)
+	}
+
+	err = p.call(ctx, MethodInit, InitParams{m.config}, nil, callTimeout)
+	if err != nil {
+		p.kill()
+		return fmt.Errorf("error initializing the plugin: %w", err)
+	}
+
+	var capabilities CapabilitiesResult
+	err = p.call(ctx, MethodCapabilities, nil, &capabilities, callTimeout)
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) && rpcErr.Code == CodeMethodNotFound {
+		capabilities.Capabilities = modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff}
+	} else if err != nil {
+		p.kill()
+		return fmt.Errorf("error retrieving the plugin capabilities: %w", err)
+	}
+	m.capabilities.Store(capabilities.Capabilities)
+
+	m.process = p
+	return nil
+}
+
+// call sends a request to the plugin, (re)starting it when needed. A plugin
+// that exits or doesn't answer in time is killed, and restarted on the next
+// call.
+func (m *PluginModule) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for m.process == nil {
+		// The restart delay is waited without holding the lock, another call
+		// may then have restarted the plugin in the meantime
+		if wait := restartDelay - time.Since(m.lastStart); wait > 0 {
+			m.mutex.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+			m.mutex.Lock()
+			if ctx.Err() != nil {
+				return fmt.Errorf("error starting the plugin %q: %w", filepath.Base(m.Path), ctx.Err())
+			}
+			continue
+		}
+		err := m.start(ctx)
+		if err != nil {
+			return fmt.Errorf("error starting the plugin %q: %w", filepath.Base(m.Path), err)
+		}
+	}
+
+	err := m.process.call(ctx, method, params, result, callTimeout)
+	if err != nil {
+		// A canceled call is answered later, the stale response is then
+		// skipped by the next call
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) && !errors.Is(err, context.Canceled) {
+			m.process.kill()
+			m.process = nil
+		}
+		return fmt.Errorf("error calling %q on the plugin %q: %w", method, filepath.Base(m.Path), err)
+	}
+	return nil
+}
+
+func (m *PluginModule) Init(config map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.process != nil {
+		m.process.kill()
+		m.process = nil
+	}
+	m.config = config
+	err := m.start(context.Background())
+	if err != nil {
+		return fmt.Errorf("error starting the plugin %q: %w", filepath.Base(m.Path), err)
+	}
+	return nil
+}
+
+func (m *PluginModule) Capabilities() modules.Capabilities {
+	capabilities, _ := m.capabilities.Load().(modules.Capabilities)
+	return capabilities
+}
+
+func stateResult(value StateValue) modules.Result[bool] {
+	result := modules.Result[bool]{Value: value.Value}
+	if value.Error != "" {
+		result.Err = errors.New(value.Error)
+	}
+	return result
+}
+
+func (m *PluginModule) State(ctx context.Context) modules.State {
+	var result StateResult
+	err := m.call(ctx, MethodState, nil, &result)
+	if err != nil {
+		return modules.UnknownState(err)
+	}
+	state := modules.NewState(stateResult(result.Power), stateResult(result.Led))
+	if result.PowerState != "" {
+		state.Power = result.PowerState
+	}
+	state.Attributes = result.Attributes
+	return state
+}
+
+func (m *PluginModule) PowerOn(ctx context.Context) error {
+	return m.call(ctx, MethodPowerOn, nil, nil)
+}
+
+func (m *PluginModule) PowerOff(ctx context.Context) error {
+	return m.call(ctx, MethodPowerOff, nil, nil)
+}
+
+func (m *PluginModule) Suspend(ctx context.Context) error {
+	if !m.Capabilities().Has(modules.CapabilitySuspend) {
+		return modules.ErrNotSupported
+	}
+	return m.call(ctx, MethodSuspend, nil, nil)
+}
+
+func (m *PluginModule) Hibernate(ctx context.Context) error {
+	if !m.Capabilities().Has(modules.CapabilityHibernate) {
+		return modules.ErrNotSupported
+	}
+	return m.call(ctx, MethodHibernate, nil, nil)
+}
+
+func (m *PluginModule) Perform(ctx context.Context, action modules.Capability) error {
+	if !m.Capabilities().Has(action) {
+		return modules.ErrNotSupported
+	}
+	return m.call(ctx, MethodPerform, PerformParams{action}, nil)
+}
+
+// Discover returns the path of the external module executables found in
+// directory, indexed by module name. A missing directory contains no module.
+func Discover(directory string) (map[string]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading the plugin directory: %w", err)
+	}
+
+	plugins := make(map[string]string)
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), ExecutablePrefix)
+		if !ok || name == "" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		plugins[name] = filepath.Join(directory, entry.Name())
+	}
+	return plugins, nil
+}
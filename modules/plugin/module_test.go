@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// pluginEnv makes the test binary serve testModule instead of running the
+// tests, so that it can be used as the plugin executable.
+const pluginEnv = "POWER_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		Serve(&testModule{})
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testModule is served by the plugin executable. Switching it off makes the
+// executable exit, and switching it on lasts for the configured delay.
+type testModule struct {
+	modules.DefaultModule
+	powerOnDelay time.Duration
+}
+
+func (m *testModule) Init(config map[string]interface{}) error {
+	delay, _ := config["power-on-delay"].(string)
+	if delay != "" {
+		var err error
+		m.powerOnDelay, err = time.ParseDuration(delay)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *testModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityNmi}
+}
+
+func (m *testModule) State(ctx context.Context) modules.State {
+	return modules.NewState(modules.Result[bool]{Value: true}, modules.Result[bool]{Value: true})
+}
+
+func (m *testModule) PowerOn(ctx context.Context) error {
+	time.Sleep(m.powerOnDelay)
+	return nil
+}
+
+func (m *testModule) PowerOff(ctx context.Context) error {
+	os.Exit(1)
+	return nil
+}
+
+func newTestModule(t *testing.T, config map[string]interface{}) *PluginModule {
+	t.Helper()
+	t.Setenv(pluginEnv, "1")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := New(executable).(*PluginModule)
+	if err := module.Init(config); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	t.Cleanup(func() {
+		module.mutex.Lock()
+		defer module.mutex.Unlock()
+		if module.process != nil {
+			module.process.kill()
+		}
+	})
+	return module
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCalls(t *testing.T) {
+	module := newTestModule(t, nil)
+
+	want := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityNmi}
+	if capabilities := module.Capabilities(); !slices.Equal(capabilities, want) {
+		t.Errorf("Capabilities() = %v, want %v", capabilities, want)
+	}
+	if state := module.State(testContext(t)); state.Power != modules.PowerOn || len(state.Errors) != 0 {
+		t.Errorf("State() = %s %v, want a server switched on", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(testContext(t)); err != nil {
+		t.Errorf("PowerOn() error = %v", err)
+	}
+	if err := module.Suspend(testContext(t)); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("Suspend() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+	// The capability is advertised, but the module doesn't perform actions
+	if err := module.Perform(testContext(t), modules.CapabilityNmi); err == nil || !strings.Contains(err.Error(), modules.ErrNotSupported.Error()) {
+		t.Errorf("Perform() error = %v, want a not supported error", err)
+	}
+}
+
+func TestCapabilitiesDuringCall(t *testing.T) {
+	module := newTestModule(t, map[string]interface{}{"power-on-delay": "2s"})
+
+	done := make(chan error)
+	go func() { done <- module.PowerOn(testContext(t)) }()
+	// Wait for the call to hold the lock
+	for module.mutex.TryLock() {
+		module.mutex.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	capabilities := module.Capabilities()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Capabilities() took %s while a call was in progress", elapsed)
+	}
+	if !capabilities.Has(modules.CapabilityPowerOn) {
+		t.Errorf("Capabilities() = %v, want the capabilities of the plugin", capabilities)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("PowerOn() error = %v", err)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	module := newTestModule(t, nil)
+
+	err := module.PowerOff(testContext(t))
+	if err == nil || !strings.Contains(err.Error(), errExited.Error()) {
+		t.Fatalf("PowerOff() error = %v, want %v", err, errExited)
+	}
+
+	t.Run("canceled during the restart delay", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		state := module.State(ctx)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("State() took %s, want it to return once canceled", elapsed)
+		}
+		if state.Power != modules.PowerUnknown || len(state.Errors) != 1 || !strings.Contains(state.Errors[0], context.DeadlineExceeded.Error()) {
+			t.Errorf("State() = %s %v, want an unknown state with a deadline error", state.Power, state.Errors)
+		}
+		// The lock isn't held while waiting
+		if !module.mutex.TryLock() {
+			t.Fatal("the lock is still held")
+		}
+		module.mutex.Unlock()
+	})
+
+	t.Run("restarted after the delay", func(t *testing.T) {
+		start := time.Now()
+		state := module.State(testContext(t))
+		if state.Power != modules.PowerOn {
+			t.Errorf("State() = %s %v, want a server switched on", state.Power, state.Errors)
+		}
+		if elapsed := time.Since(start); elapsed > restartDelay+time.Second {
+			t.Errorf("State() took %s, want at most the restart delay", elapsed)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// External modules are executables named `power-module-<name>`. They receive
+// JSON-RPC 2.0 requests on their standard input and write the responses on
+// their standard output, one JSON object per line. The standard error is
+// forwarded to the logs of power.
+//
+// The first request is always a handshake carrying the protocol version,
+// followed by the init request carrying the module configuration. Requests
+// are sent one at a time. The capabilities request is optional, an executable
+// which doesn't implement it is considered able to switch the server on and
+// off.
+const ProtocolVersion = 1
+
+const ExecutablePrefix = "power-module-"
+
+const (
+	MethodHandshake    = "handshake"
+	MethodInit         = "init"
+	MethodCapabilities = "capabilities"
+	MethodState        = "state"
+	MethodPowerOn      = "power_on"
+	MethodPowerOff     = "power_off"
+	MethodSuspend      = "suspend"
+	MethodHibernate    = "hibernate"
+	MethodPerform      = "perform"
+)
+
+// Error codes defined by the JSON-RPC 2.0 specification, and the code used
+// for the errors returned by the module itself.
+const (
+	CodeParseError     = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeModuleError    = -32000
+)
+
+type Request struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code: %d)", e.Message, e.Code)
+}
+
+type HandshakeParams struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+type HandshakeResult struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+type InitParams struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+type CapabilitiesResult struct {
+	Capabilities modules.Capabilities `json:"capabilities"`
+}
+
+// PerformParams carries one of the extended actions (force-off,
+// graceful-shutdown, force-restart, power-cycle, press-and-hold or nmi).
+type PerformParams struct {
+	Action modules.Capability `json:"action"`
+}
+
+type StateValue struct {
+	Value bool   `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// StateResult carries the power state and the reachability of the server.
+// PowerState optionally reports a transition or a sleeping server, and
+// Attributes the additional information specific to the module.
+type StateResult struct {
+	Power      StateValue         `json:"power"`
+	Led        StateValue         `json:"led"`
+	PowerState modules.PowerState `json:"power_state,omitempty"`
+	Attributes map[string]any     `json:"attributes,omitempty"`
+}
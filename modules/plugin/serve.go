@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// newStateResult converts a state into the power and led values understood
+// by every version of power, along with the typed power state.
+func newStateResult(state modules.State) StateResult {
+	result := StateResult{
+		Power:      StateValue{Value: state.Power.Powered()},
+		Led:        StateValue{Value: state.IsReachable()},
+		PowerState: state.Power,
+		Attributes: state.Attributes,
+	}
+	errorMessage := strings.Join(state.Errors, "; ")
+	if errorMessage == "" {
+		errorMessage = "unknown state"
+	}
+	if state.Power == modules.PowerUnknown {
+		result.Power.Error = errorMessage
+	}
+	if state.Reachable == nil {
+		result.Led.Error = errorMessage
+	}
+	return result
+}
+
+func dispatch(ctx context.Context, module modules.Module, request *Request) (interface{}, *Error) {
+	switch request.Method {
+	case MethodHandshake:
+		var params HandshakeParams
+		err := json.Unmarshal(request.Params, &params)
+		if err != nil {
+			return nil, &Error{CodeInvalidParams, err.Error()}
+		}
+		if params.ProtocolVersion != ProtocolVersion {
+			return nil, &Error{CodeInvalidParams, fmt.Sprintf("unsupported protocol version %d", params.ProtocolVersion)}
+		}
+		return HandshakeResult{ProtocolVersion}, nil
+	case MethodInit:
+		var params InitParams
+		err := json.Unmarshal(request.Params, &params)
+		if err != nil {
+			return nil, &Error{CodeInvalidParams, err.Error()}
+		}
+		err = module.Init(params.Config)
+		if err != nil {
+			return nil, &Error{CodeModuleError, err.Error()}
+		}
+		return struct{}{}, nil
+	case MethodCapabilities:
+		return CapabilitiesResult{module.Capabilities()}, nil
+	case MethodState:
+		return newStateResult(module.State(ctx)), nil
+	case MethodPowerOn:
+		err := module.PowerOn(ctx)
+		if err != nil {
+			return nil, &Error{CodeModuleError, err.Error()}
+		}
+		return struct{}{}, nil
+	case MethodPowerOff:
+		err := module.PowerOff(ctx)
+		if err != nil {
+			return nil, &Error{CodeModuleError, err.Error()}
+		}
+		return struct{}{}, nil
+	case MethodSuspend, MethodHibernate:
+		sleeper, ok := module.(modules.Sleeper)
+		if !ok {
+			return nil, &Error{CodeMethodNotFound, modules.ErrNotSupported.Error()}
+		}
+		sleep := sleeper.Suspend
+		if request.Method == MethodHibernate {
+			sleep = sleeper.Hibernate
+		}
+		err := sleep(ctx)
+		if err != nil {
+			return nil, &Error{CodeModuleError, err.Error()}
+		}
+		return struct{}{}, nil
+	case MethodPerform:
+		var params PerformParams
+		err := json.Unmarshal(request.Params, &params)
+		if err != nil {
+			return nil, &Error{CodeInvalidParams, err.Error()}
+		}
+		performer, ok := module.(modules.ActionPerformer)
+		if !ok {
+			return nil, &Error{CodeMethodNotFound, modules.ErrNotSupported.Error()}
+		}
+		err = performer.Perform(ctx, params.Action)
+		if err != nil {
+			return nil, &Error{CodeModuleError, err.Error()}
+		}
+		return struct{}{}, nil
+	}
+	return nil, &Error{CodeMethodNotFound, fmt.Sprintf("unknown method %q", request.Method)}
+}
+
+// ServeIO answers the requests read from reader with module until reader is
+// closed.
+func ServeIO(module modules.Module, reader io.Reader, writer io.Writer) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(writer)
+
+	for scanner.Scan() {
+		var request Request
+		response := Response{JsonRpc: "2.0"}
+
+		err := json.Unmarshal(scanner.Bytes(), &request)
+		if err != nil {
+			response.Error = &Error{CodeParseError, err.Error()}
+		} else {
+			response.Id = request.Id
+			result, rpcErr := dispatch(context.Background(), module, &request)
+			if rpcErr != nil {
+				response.Error = rpcErr
+			} else {
+				response.Result, err = json.Marshal(result)
+				if err != nil {
+					response.Error = &Error{CodeModuleError, err.Error()}
+				}
+			}
+		}
+
+		err = encoder.Encode(response)
+		if err != nil {
+			return fmt.Errorf("error writing the response: %w", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Serve turns module into an external module. It is meant to be called from
+// the main function of a `power-module-<name>` executable written in Go.
+func Serve(module modules.Module) {
+	err := ServeIO(module, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving the module: %s\n", err)
+		os.Exit(1)
+	}
+}
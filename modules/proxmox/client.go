@@ -0,0 +1,256 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	// Tickets are valid for two hours, they are renewed well before
+	ticketLifetime = time.Hour
+)
+
+type GuestStatus string
+
+const (
+	GuestStatusRunning GuestStatus = "running"
+	GuestStatusStopped GuestStatus = "stopped"
+	// GuestStatusPaused is reported for the running virtual machines which
+	// are paused, or suspended to memory by their operating system
+	GuestStatusPaused GuestStatus = "paused"
+)
+
+type guestStatus struct {
+	Data struct {
+		Status    GuestStatus `json:"status"`
+		QmpStatus string      `json:"qmpstatus"`
+	} `json:"data"`
+}
+
+type statusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response (Status: %s, Body: %v)", e.Status, e.Body)
+}
+
+type ticket struct {
+	Ticket    string `json:"ticket"`
+	CsrfToken string `json:"CSRFPreventionToken"`
+	createdAt time.Time
+}
+
+type ticketResponse struct {
+	Data ticket `json:"data"`
+}
+
+// Credentials authenticate the client, either with an API token or with the
+// username and password of a user, in exchange for a ticket.
+type Credentials struct {
+	TokenId  string
+	Secret   string
+	Username string
+	Password string
+}
+
+// ProxmoxClient drives a single VM or container through the Proxmox VE API.
+type ProxmoxClient struct {
+	apiUrl      *url.URL
+	url         *url.URL
+	credentials Credentials
+	client      http.Client
+
+	mutex  sync.Mutex
+	ticket *ticket
+}
+
+func checkStatus(resp *http.Response, method string, path string) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading the response body: %w", err)
+	}
+	// The API reports the reason of the failure in the status text
+	return fmt.Errorf("error calling %s %s: %w", method, path, &statusError{resp.StatusCode, resp.Status, string(body)})
+}
+
+// login exchanges the username and password for a ticket.
+func (c *ProxmoxClient) login(ctx context.Context) (*ticket, error) {
+	form := url.Values{"username": {c.credentials.Username}, "password": {c.credentials.Password}}
+	endpoint := c.apiUrl.JoinPath("access/ticket")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkStatus(resp, http.MethodPost, "access/ticket")
+	if err != nil {
+		return nil, err
+	}
+
+	var response ticketResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the JSON response: %w", err)
+	}
+	response.Data.createdAt = time.Now()
+	return &response.Data, nil
+}
+
+// currentTicket returns a valid ticket, and logs in again when the previous
+// one is about to expire or has been rejected.
+func (c *ProxmoxClient) currentTicket(ctx context.Context, renew bool) (*ticket, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ticket == nil || renew || time.Since(c.ticket.createdAt) > ticketLifetime {
+		ticket, err := c.login(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error logging in: %w", err)
+		}
+		c.ticket = ticket
+	}
+	return c.ticket, nil
+}
+
+func (c *ProxmoxClient) send(ctx context.Context, method string, path string, renewTicket bool) (*http.Response, error) {
+	endpoint := c.url.JoinPath(path)
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request: %w", err)
+	}
+	if c.credentials.TokenId != "" {
+		req.Header.Set("Authorization", "PVEAPIToken="+c.credentials.TokenId+"="+c.credentials.Secret)
+	} else {
+		ticket, err := c.currentTicket(ctx, renewTicket)
+		if err != nil {
+			return nil, err
+		}
+		req.AddCookie(&http.Cookie{Name: "PVEAuthCookie", Value: ticket.Ticket})
+		// Requests modifying the guest must also prove that they don't come
+		// from a forged form
+		if method != http.MethodGet {
+			req.Header.Set("CSRFPreventionToken", ticket.CsrfToken)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the request: %w", err)
+	}
+	return resp, nil
+}
+
+func (c *ProxmoxClient) do(ctx context.Context, method string, path string, response interface{}) error {
+	resp, err := c.send(ctx, method, path, false)
+	if err != nil {
+		return err
+	}
+	// The ticket may have been invalidated before its expiration, by a
+	// restart of the node for example
+	if resp.StatusCode == http.StatusUnauthorized && c.credentials.TokenId == "" {
+		resp.Body.Close()
+		resp, err = c.send(ctx, method, path, true)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	err = checkStatus(resp, method, path)
+	if err != nil {
+		return err
+	}
+
+	if response != nil {
+		err = json.NewDecoder(resp.Body).Decode(response)
+		if err != nil {
+			return fmt.Errorf("error decoding the JSON response: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *ProxmoxClient) Status(ctx context.Context) (GuestStatus, error) {
+	var status guestStatus
+	err := c.do(ctx, http.MethodGet, "status/current", &status)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving the guest status: %w", err)
+	}
+	// The QEMU status is only reported for the virtual machines
+	if status.Data.Status == GuestStatusRunning && (status.Data.QmpStatus == "paused" || status.Data.QmpStatus == "suspended") {
+		return GuestStatusPaused, nil
+	}
+	return status.Data.Status, nil
+}
+
+// Action starts a status change task (start, shutdown, stop, ...). The task
+// runs asynchronously on the node.
+func (c *ProxmoxClient) Action(ctx context.Context, action string) error {
+	err := c.do(ctx, http.MethodPost, "status/"+action, nil)
+	if err != nil {
+		return fmt.Errorf("error sending the %s action: %w", action, err)
+	}
+	return nil
+}
+
+// AgentPing checks whether the QEMU guest agent answers.
+func (c *ProxmoxClient) AgentPing(ctx context.Context) (bool, error) {
+	err := c.do(ctx, http.MethodPost, "agent/ping", nil)
+	if err != nil {
+		// The API answers with 500 when the agent is not running
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusInternalServerError {
+			return false, nil
+		}
+		return false, fmt.Errorf("error pinging the guest agent: %w", err)
+	}
+	return true, nil
+}
+
+func NewClient(baseUrl string, credentials Credentials, node string, guestType string, vmid int) (*ProxmoxClient, error) {
+	if !strings.Contains(baseUrl, "://") {
+		baseUrl = "https://" + baseUrl
+	}
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+	if parsedUrl.Port() == "" {
+		parsedUrl.Host = parsedUrl.Host + ":8006"
+	}
+	apiUrl := parsedUrl.JoinPath("/api2/json")
+
+	// Ignore SSL certificate verification, Proxmox VE uses a self-signed certificate by default
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	return &ProxmoxClient{
+		apiUrl:      apiUrl,
+		url:         apiUrl.JoinPath("nodes", node, guestType, strconv.Itoa(vmid)),
+		credentials: credentials,
+		client:      http.Client{Transport: tr, Timeout: requestTimeout},
+	}, nil
+}
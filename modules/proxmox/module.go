@@ -0,0 +1,120 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type ProxmoxModule struct {
+	modules.DefaultModule
+	Config ProxmoxConfig
+	Client *ProxmoxClient
+}
+
+type ProxmoxConfig struct {
+	Url      string `validate:"required"`
+	TokenId  string `mapstructure:"token-id" validate:"required_without=Username,excluded_with=Username"`
+	Secret   string `validate:"required_with=TokenId"`
+	Username string `validate:"required_without=TokenId"`
+	Password string `validate:"required_with=Username"`
+	Node     string `validate:"required"`
+	Vmid     int    `validate:"required,gt=0"`
+	Type     string `validate:"omitempty,oneof=qemu lxc"`
+	Hostname string
+	PowerOff string `mapstructure:"power-off" validate:"omitempty,oneof=shutdown stop"`
+}
+
+func New() modules.Module {
+	return &ProxmoxModule{}
+}
+
+func (m *ProxmoxModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "proxmox", err)
+	}
+	if m.Config.Type == "" {
+		m.Config.Type = "qemu"
+	}
+	if m.Config.PowerOff == "" {
+		m.Config.PowerOff = "shutdown"
+	}
+	credentials := Credentials{
+		TokenId:  m.Config.TokenId,
+		Secret:   m.Config.Secret,
+		Username: m.Config.Username,
+		Password: m.Config.Password,
+	}
+	m.Client, err = NewClient(m.Config.Url, credentials, m.Config.Node, m.Config.Type, m.Config.Vmid)
+	if err != nil {
+		return fmt.Errorf("error creating proxmox client: %w", err)
+	}
+	return nil
+}
+
+// reachability uses the ping when a hostname is defined, then the QEMU guest
+// agent, and finally falls back on the power state for containers.
+func (m *ProxmoxModule) reachability(ctx context.Context, powerState modules.Result[bool]) modules.Result[bool] {
+	switch {
+	case m.Config.Hostname != "":
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	case m.Config.Type == "qemu":
+		if powerState.Err == nil && !powerState.Value {
+			return modules.Result[bool]{Value: false}
+		}
+		value, err := m.Client.AgentPing(ctx)
+		return modules.Result[bool]{Value: value, Err: err}
+	default:
+		return powerState
+	}
+}
+
+// State reports a paused or suspended virtual machine as sleeping, and
+// unreachable.
+func (m *ProxmoxModule) State(ctx context.Context) modules.State {
+	status, err := m.Client.Status(ctx)
+	powerState := modules.Result[bool]{Value: status == GuestStatusRunning, Err: err}
+	state := modules.NewState(powerState, m.reachability(ctx, powerState))
+	if status == GuestStatusPaused {
+		state.Power = modules.PowerSleeping
+	}
+	return state
+}
+
+func (m *ProxmoxModule) PowerOn(ctx context.Context) error {
+	return m.Client.Action(ctx, "start")
+}
+
+func (m *ProxmoxModule) PowerOff(ctx context.Context) error {
+	return m.Client.Action(ctx, m.Config.PowerOff)
+}
+
+func (m *ProxmoxModule) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{
+		modules.CapabilityPowerOn,
+		modules.CapabilityPowerOff,
+		modules.CapabilityForceOff,
+		modules.CapabilityGracefulShutdown,
+	}
+	// Containers can't be reset
+	if m.Config.Type == "qemu" {
+		capabilities = append(capabilities, modules.CapabilityForceRestart)
+	}
+	return capabilities
+}
+
+func (m *ProxmoxModule) Perform(ctx context.Context, action modules.Capability) error {
+	switch {
+	case action == modules.CapabilityForceOff:
+		return m.Client.Action(ctx, "stop")
+	case action == modules.CapabilityGracefulShutdown:
+		return m.Client.Action(ctx, "shutdown")
+	case action == modules.CapabilityForceRestart && m.Config.Type == "qemu":
+		return m.Client.Action(ctx, "reset")
+	default:
+		return modules.ErrNotSupported
+	}
+}
@@ -0,0 +1,451 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testNode     = "pve"
+	testVmid     = 100
+	testTokenId  = "power@pve!power"
+	testSecret   = "00000000-0000-0000-0000-000000000000"
+	testUsername = "power@pve"
+	testPassword = "secret"
+	testCsrf     = "6547A1B2:csrf"
+)
+
+// pveStub is a local mock of the Proxmox VE API, serving a single guest.
+type pveStub struct {
+	server    *httptest.Server
+	guestType string
+	actions   moduletest.Recorder[string]
+
+	mutex  sync.Mutex
+	status GuestStatus
+	// qmpStatus is the QEMU status of a virtual machine, the status when
+	// empty
+	qmpStatus string
+	// agent reports whether the QEMU guest agent is running
+	agent bool
+	// password is the password of the user logging in
+	password string
+	// ticket is the ticket currently accepted, the clients must log in again
+	// when it changes
+	ticket string
+	logins int
+	// actionStatus is the status code answered to the actions
+	actionStatus int
+	// malformed truncates the JSON responses
+	malformed bool
+}
+
+func newPveStub(t *testing.T, guestType string) *pveStub {
+	t.Helper()
+	stub := &pveStub{guestType: guestType, status: GuestStatusStopped, password: testPassword, actionStatus: http.StatusOK}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// configure changes the state of the node while it is running.
+func (s *pveStub) configure(configure func(s *pveStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+// writeData answers with data in the envelope of the API, truncated when the
+// responses are malformed.
+func (s *pveStub) writeData(w http.ResponseWriter, data interface{}) {
+	if s.malformed {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"status": "run`))
+		return
+	}
+	moduletest.WriteJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *pveStub) authorized(r *http.Request) bool {
+	if r.Header.Get("Authorization") == "PVEAPIToken="+testTokenId+"="+testSecret {
+		return true
+	}
+	cookie, err := r.Cookie("PVEAuthCookie")
+	if err != nil || s.ticket == "" || cookie.Value != s.ticket {
+		return false
+	}
+	return r.Method == http.MethodGet || r.Header.Get("CSRFPreventionToken") == testCsrf
+}
+
+func (s *pveStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.URL.Path == "/api2/json/access/ticket" {
+		if r.Method != http.MethodPost || r.PostFormValue("username") != testUsername || r.PostFormValue("password") != s.password {
+			http.Error(w, "authentication failure", http.StatusUnauthorized)
+			return
+		}
+		s.logins++
+		s.ticket = "PVE:" + testUsername + ":" + time.Now().Format(time.RFC3339Nano)
+		s.writeData(w, map[string]string{"ticket": s.ticket, "CSRFPreventionToken": testCsrf, "username": testUsername})
+		return
+	}
+
+	guest := "/api2/json/nodes/" + testNode + "/" + s.guestType + "/100/"
+	path, ok := strings.CutPrefix(r.URL.Path, guest)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "permission denied - invalid PVE ticket", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "status/current":
+		status := map[string]interface{}{"status": s.status, "vmid": testVmid}
+		if s.guestType == "qemu" {
+			status["qmpstatus"] = s.status
+			if s.qmpStatus != "" {
+				status["qmpstatus"] = s.qmpStatus
+			}
+		}
+		s.writeData(w, status)
+	case r.Method == http.MethodPost && path == "agent/ping" && s.guestType == "qemu":
+		if s.status != GuestStatusRunning || !s.agent {
+			http.Error(w, "QEMU guest agent is not running", http.StatusInternalServerError)
+			return
+		}
+		s.writeData(w, map[string]interface{}{})
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "status/"):
+		action := strings.TrimPrefix(path, "status/")
+		s.actions.Record(action)
+		if s.actionStatus != http.StatusOK {
+			http.Error(w, "VM 100 is locked (backup)", s.actionStatus)
+			return
+		}
+		switch action {
+		case "start", "reset":
+			s.status = GuestStatusRunning
+		case "shutdown", "stop":
+			s.status = GuestStatusStopped
+		}
+		s.writeData(w, "UPID:pve:000A1B2C:0000D3E4:65000000:qm"+action+":100:"+testUsername+":")
+	default:
+		http.Error(w, "Method '"+r.Method+" "+r.URL.Path+"' not implemented", http.StatusNotImplemented)
+	}
+}
+
+func newTestModule(t *testing.T, stub *pveStub, config map[string]interface{}) *ProxmoxModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*ProxmoxModule), map[string]interface{}{
+		"url":      stub.server.URL,
+		"token-id": testTokenId,
+		"secret":   testSecret,
+		"node":     testNode,
+		"vmid":     testVmid,
+		"type":     stub.guestType,
+	}, config)
+}
+
+var ticketConfig = map[string]interface{}{
+	"token-id": nil,
+	"secret":   nil,
+	"username": testUsername,
+	"password": testPassword,
+}
+
+func TestAuthentication(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"token", nil, ""},
+		{"ticket", ticketConfig, ""},
+		{"invalid token", map[string]interface{}{"secret": "invalid"}, "401"},
+		{"invalid password", map[string]interface{}{"token-id": nil, "secret": nil, "username": testUsername, "password": "invalid"}, "error logging in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newPveStub(t, "qemu")
+			module := newTestModule(t, stub, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			err := module.PowerOn(moduletest.Context(t))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("PowerOn() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				if state.Power != modules.PowerUnknown {
+					t.Errorf("State().Power = %s, want %s", state.Power, modules.PowerUnknown)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if state.Power != modules.PowerOff {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOff, state.Errors)
+			}
+			if actions := stub.actions.Received(); !slices.Equal(actions, []string{"start"}) {
+				t.Errorf("actions = %v, want [start]", actions)
+			}
+		})
+	}
+}
+
+func TestTicketRenewal(t *testing.T) {
+	stub := newPveStub(t, "lxc")
+	module := newTestModule(t, stub, ticketConfig)
+
+	for i := 0; i < 3; i++ {
+		if err := module.PowerOn(moduletest.Context(t)); err != nil {
+			t.Fatalf("PowerOn() error = %v", err)
+		}
+	}
+	stub.configure(func(s *pveStub) {
+		if s.logins != 1 {
+			t.Errorf("logins = %d, want the ticket to be reused", s.logins)
+		}
+		// The node forgets the ticket, after a restart for example
+		s.ticket = ""
+	})
+
+	if err := module.PowerOff(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOff() error = %v", err)
+	}
+	stub.configure(func(s *pveStub) {
+		if s.logins != 2 {
+			t.Errorf("logins = %d, want the ticket to be renewed once", s.logins)
+		}
+	})
+
+	// The ticket is also renewed before its expiration
+	module.Client.mutex.Lock()
+	module.Client.ticket.createdAt = time.Now().Add(-2 * ticketLifetime)
+	module.Client.mutex.Unlock()
+	if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOff {
+		t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOff, state.Errors)
+	}
+	stub.configure(func(s *pveStub) {
+		if s.logins != 3 {
+			t.Errorf("logins = %d, want the expired ticket to be renewed", s.logins)
+		}
+	})
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name          string
+		guestType     string
+		config        map[string]interface{}
+		status        GuestStatus
+		qmpStatus     string
+		agent         bool
+		wantPower     modules.PowerState
+		wantReachable bool
+	}{
+		{"qemu running with agent", "qemu", nil, GuestStatusRunning, "", true, modules.PowerOn, true},
+		{"qemu running without agent", "qemu", nil, GuestStatusRunning, "", false, modules.PowerOn, false},
+		{"qemu stopped", "qemu", nil, GuestStatusStopped, "", false, modules.PowerOff, false},
+		// The agent of a paused virtual machine isn't pinged, it can't answer
+		{"qemu paused", "qemu", nil, GuestStatusRunning, "paused", true, modules.PowerSleeping, false},
+		{"qemu suspended", "qemu", nil, GuestStatusRunning, "suspended", true, modules.PowerSleeping, false},
+		{"qemu migrating", "qemu", nil, GuestStatusRunning, "inmigrate", true, modules.PowerOn, true},
+		{"lxc running", "lxc", nil, GuestStatusRunning, "", false, modules.PowerOn, true},
+		{"lxc stopped", "lxc", nil, GuestStatusStopped, "", false, modules.PowerOff, false},
+		{"ticket", "qemu", ticketConfig, GuestStatusRunning, "", true, modules.PowerOn, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newPveStub(t, tt.guestType)
+			stub.configure(func(s *pveStub) {
+				s.status = tt.status
+				s.qmpStatus = tt.qmpStatus
+				s.agent = tt.agent
+			})
+			module := newTestModule(t, stub, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.wantPower {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.wantPower, state.Errors)
+			}
+			if state.Reachable == nil || *state.Reachable != tt.wantReachable {
+				t.Errorf("State().Reachable = %v, want %t (errors: %v)", state.Reachable, tt.wantReachable, state.Errors)
+			}
+			if len(state.Errors) != 0 {
+				t.Errorf("State().Errors = %v, want none", state.Errors)
+			}
+		})
+	}
+}
+
+func TestStateError(t *testing.T) {
+	stub := newPveStub(t, "qemu")
+	module := newTestModule(t, stub, map[string]interface{}{"vmid": 101})
+
+	state := module.State(moduletest.Context(t))
+	if state.Power != modules.PowerUnknown {
+		t.Errorf("State().Power = %s, want %s", state.Power, modules.PowerUnknown)
+	}
+	if len(state.Errors) == 0 || !strings.HasPrefix(state.Errors[0], "power: error retrieving the guest status") {
+		t.Errorf("State().Errors = %v, want a power error", state.Errors)
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	powerOn := func(m *ProxmoxModule, ctx context.Context) error { return m.PowerOn(ctx) }
+	powerOff := func(m *ProxmoxModule, ctx context.Context) error { return m.PowerOff(ctx) }
+	perform := func(action modules.Capability) func(m *ProxmoxModule, ctx context.Context) error {
+		return func(m *ProxmoxModule, ctx context.Context) error { return m.Perform(ctx, action) }
+	}
+
+	tests := []struct {
+		name      string
+		guestType string
+		config    map[string]interface{}
+		call      func(m *ProxmoxModule, ctx context.Context) error
+		want      string
+		wantErr   error
+	}{
+		{"power on", "qemu", nil, powerOn, "start", nil},
+		{"power off", "qemu", nil, powerOff, "shutdown", nil},
+		{"power off with stop", "qemu", map[string]interface{}{"power-off": "stop"}, powerOff, "stop", nil},
+		{"power on with ticket", "qemu", ticketConfig, powerOn, "start", nil},
+		{"force off", "qemu", nil, perform(modules.CapabilityForceOff), "stop", nil},
+		{"graceful shutdown", "lxc", nil, perform(modules.CapabilityGracefulShutdown), "shutdown", nil},
+		{"force restart", "qemu", nil, perform(modules.CapabilityForceRestart), "reset", nil},
+		{"container force restart", "lxc", nil, perform(modules.CapabilityForceRestart), "", modules.ErrNotSupported},
+		{"suspend", "qemu", nil, perform(modules.CapabilitySuspend), "", modules.ErrNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newPveStub(t, tt.guestType)
+			module := newTestModule(t, stub, tt.config)
+
+			err := tt.call(module, moduletest.Context(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			var want []string
+			if tt.want != "" {
+				want = []string{tt.want}
+			}
+			if actions := stub.actions.Received(); !slices.Equal(actions, want) {
+				t.Errorf("actions = %v, want %v", actions, want)
+			}
+		})
+	}
+}
+
+func TestActionError(t *testing.T) {
+	stub := newPveStub(t, "qemu")
+	stub.configure(func(s *pveStub) { s.actionStatus = http.StatusInternalServerError })
+	module := newTestModule(t, stub, nil)
+
+	err := module.PowerOn(moduletest.Context(t))
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("PowerOn() error = %v, want a status error", err)
+	}
+	if !strings.Contains(err.Error(), "error sending the start action") || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("PowerOn() error = %v, want the action and the reason", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		guestType   string
+		wantRestart bool
+	}{
+		{"qemu", true},
+		{"lxc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.guestType, func(t *testing.T) {
+			stub := newPveStub(t, tt.guestType)
+			module := newTestModule(t, stub, nil)
+
+			capabilities := module.Capabilities()
+			for _, capability := range []modules.Capability{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityForceOff, modules.CapabilityGracefulShutdown} {
+				if !capabilities.Has(capability) {
+					t.Errorf("Capabilities() = %v, want %s", capabilities, capability)
+				}
+			}
+			if capabilities.Has(modules.CapabilityForceRestart) != tt.wantRestart {
+				t.Errorf("Capabilities() = %v, want force-restart: %t", capabilities, tt.wantRestart)
+			}
+		})
+	}
+}
+
+func TestPasswordChanged(t *testing.T) {
+	stub := newPveStub(t, "qemu")
+	module := newTestModule(t, stub, ticketConfig)
+
+	if err := module.PowerOn(moduletest.Context(t)); err != nil {
+		t.Fatalf("PowerOn() error = %v", err)
+	}
+	// The ticket is rejected once the node restarts, and the new password
+	// is required to get another one
+	stub.configure(func(s *pveStub) {
+		s.ticket = ""
+		s.password = "rotated"
+	})
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error logging in") {
+		t.Errorf("State() = %s %v, want a login error", state.Power, state.Errors)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error logging in") {
+		t.Errorf("PowerOff() error = %v, want a login error", err)
+	}
+	if actions := stub.actions.Received(); !slices.Equal(actions, []string{"start"}) {
+		t.Errorf("actions = %v, want [start]", actions)
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	stub := newPveStub(t, "qemu")
+	stub.configure(func(s *pveStub) {
+		s.status = GuestStatusRunning
+		s.malformed = true
+	})
+	module := newTestModule(t, stub, nil)
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error decoding the JSON response") {
+		t.Errorf("State() = %s %v, want a decoding error", state.Power, state.Errors)
+	}
+}
+
+func TestUnreachableNode(t *testing.T) {
+	module := moduletest.Init(t, New().(*ProxmoxModule), map[string]interface{}{
+		"url":      "http://" + moduletest.ClosedAddress(t).String(),
+		"token-id": testTokenId,
+		"secret":   testSecret,
+		"node":     testNode,
+		"vmid":     testVmid,
+	}, nil)
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error sending the request") {
+		t.Errorf("State() = %s %v, want a connection error", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+		t.Errorf("PowerOn() error = %v, want a connection error", err)
+	}
+}
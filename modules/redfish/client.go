@@ -0,0 +1,246 @@
+package redfish
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds every request sent to the BMC, in addition to the
+// deadline of the context.
+const requestTimeout = 30 * time.Second
+
+type PowerState string
+
+const (
+	PowerStateOn          PowerState = "On"
+	PowerStateOff         PowerState = "Off"
+	PowerStatePoweringOn  PowerState = "PoweringOn"
+	PowerStatePoweringOff PowerState = "PoweringOff"
+	PowerStatePaused      PowerState = "Paused"
+)
+
+type ResetType string
+
+const (
+	ResetTypeOn               ResetType = "On"
+	ResetTypeForceOn          ResetType = "ForceOn"
+	ResetTypeForceOff         ResetType = "ForceOff"
+	ResetTypeGracefulShutdown ResetType = "GracefulShutdown"
+	ResetTypeGracefulRestart  ResetType = "GracefulRestart"
+	ResetTypeForceRestart     ResetType = "ForceRestart"
+	ResetTypePowerCycle       ResetType = "PowerCycle"
+	ResetTypePushPowerButton  ResetType = "PushPowerButton"
+	ResetTypeNmi              ResetType = "Nmi"
+)
+
+type link struct {
+	Id string `json:"@odata.id"`
+}
+
+type collection struct {
+	Members []link `json:"Members"`
+}
+
+type resetAction struct {
+	Target              string      `json:"target"`
+	ActionInfo          string      `json:"@Redfish.ActionInfo"`
+	AllowableResetTypes []ResetType `json:"ResetType@Redfish.AllowableValues"`
+}
+
+type actionInfo struct {
+	Parameters []struct {
+		Name            string      `json:"Name"`
+		AllowableValues []ResetType `json:"AllowableValues"`
+	} `json:"Parameters"`
+}
+
+type ComputerSystem struct {
+	Path         string     `json:"@odata.id"`
+	Id           string     `json:"Id"`
+	SerialNumber string     `json:"SerialNumber"`
+	PowerState   PowerState `json:"PowerState"`
+	Actions      struct {
+		Reset resetAction `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+// ResetTarget returns the URI of the ComputerSystem.Reset action.
+func (s *ComputerSystem) ResetTarget() string {
+	if s.Actions.Reset.Target != "" {
+		return s.Actions.Reset.Target
+	}
+	return s.Path + "/Actions/ComputerSystem.Reset"
+}
+
+type RedfishClient struct {
+	url      *url.URL
+	username string
+	password string
+	client   http.Client
+}
+
+func (c *RedfishClient) do(ctx context.Context, method string, path string, reqBody interface{}, respBody interface{}) error {
+	// Resolve the URI against the service root
+	endpoint := c.url.ResolveReference(&url.URL{Path: path})
+
+	var body io.Reader
+	if reqBody != nil {
+		jsonData, err := json.Marshal(reqBody)
+		if err != nil {
+			return fmt.Errorf("error encoding JSON: %w", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Actions may answer with 200, 202 or 204 depending on the vendor
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading the response body: %w", err)
+		}
+		return fmt.Errorf("unexpected response from %s %s (StatusCode: %d, Body: %v)", method, endpoint.Path, resp.StatusCode, string(body))
+	}
+
+	if respBody != nil {
+		err = json.NewDecoder(resp.Body).Decode(respBody)
+		if err != nil {
+			return fmt.Errorf("error decoding the JSON response: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *RedfishClient) Systems(ctx context.Context) ([]string, error) {
+	var systems collection
+	err := c.do(ctx, http.MethodGet, "Systems", nil, &systems)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the systems: %w", err)
+	}
+	paths := make([]string, 0, len(systems.Members))
+	for _, member := range systems.Members {
+		paths = append(paths, member.Id)
+	}
+	return paths, nil
+}
+
+func (c *RedfishClient) System(ctx context.Context, path string) (*ComputerSystem, error) {
+	var system ComputerSystem
+	err := c.do(ctx, http.MethodGet, path, nil, &system)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the system %q: %w", path, err)
+	}
+	if system.Path == "" {
+		system.Path = path
+	}
+	return &system, nil
+}
+
+// FindSystem walks the Systems collection and returns the member whose Id or
+// SerialNumber matches selector. An empty selector only matches when the
+// collection has a single member.
+func (c *RedfishClient) FindSystem(ctx context.Context, selector string) (*ComputerSystem, error) {
+	paths, err := c.Systems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if selector == "" {
+		if len(paths) != 1 {
+			return nil, fmt.Errorf("found %d systems, a system must be selected by ID or serial number", len(paths))
+		}
+		return c.System(ctx, paths[0])
+	}
+	for _, path := range paths {
+		system, err := c.System(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if system.Id == selector || system.SerialNumber == selector {
+			return system, nil
+		}
+	}
+	return nil, fmt.Errorf("no system matches the ID or serial number %q", selector)
+}
+
+// AllowableResetTypes returns the reset types advertised by the BMC, either
+// inline on the action or through its ActionInfo resource.
+func (c *RedfishClient) AllowableResetTypes(ctx context.Context, system *ComputerSystem) ([]ResetType, error) {
+	action := system.Actions.Reset
+	if len(action.AllowableResetTypes) > 0 || action.ActionInfo == "" {
+		return action.AllowableResetTypes, nil
+	}
+	var info actionInfo
+	err := c.do(ctx, http.MethodGet, action.ActionInfo, nil, &info)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the reset action info: %w", err)
+	}
+	for _, parameter := range info.Parameters {
+		if parameter.Name == "ResetType" {
+			return parameter.AllowableValues, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *RedfishClient) Reset(ctx context.Context, system *ComputerSystem, resetType ResetType) error {
+	err := c.do(ctx, http.MethodPost, system.ResetTarget(), map[string]ResetType{"ResetType": resetType}, nil)
+	if err != nil {
+		return fmt.Errorf("error sending the %s reset: %w", resetType, err)
+	}
+	return nil
+}
+
+// PickResetType returns the first preferred reset type allowed by the BMC.
+// When the BMC does not advertise any value, the first preference is used.
+func PickResetType(allowed []ResetType, preferences ...ResetType) (ResetType, error) {
+	if len(allowed) == 0 {
+		return preferences[0], nil
+	}
+	for _, preference := range preferences {
+		if slices.Contains(allowed, preference) {
+			return preference, nil
+		}
+	}
+	return "", fmt.Errorf("none of the reset types %v is allowed (allowed: %v)", preferences, allowed)
+}
+
+func NewClient(baseUrl string, username string, password string) (*RedfishClient, error) {
+	if !strings.Contains(baseUrl, "://") {
+		baseUrl = "https://" + baseUrl
+	}
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+	parsedUrl = parsedUrl.JoinPath("/redfish/v1/")
+
+	// Ignore SSL certificate verification, BMCs mostly use self-signed certificates
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	return &RedfishClient{parsedUrl, username, password, http.Client{Transport: tr, Timeout: requestTimeout}}, nil
+}
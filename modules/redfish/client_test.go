@@ -0,0 +1,296 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testUsername = "admin"
+	testPassword = "secret"
+)
+
+// bmcStub is a Redfish service exposing a Systems collection, with the reset
+// types of each system advertised inline or through an ActionInfo resource.
+type bmcStub struct {
+	server *httptest.Server
+
+	resets moduletest.Recorder[ResetType]
+	// requests records the path of the received requests
+	requests moduletest.Recorder[string]
+
+	mutex   sync.Mutex
+	systems []*stubSystem
+	// password is the password accepted by the BMC
+	password string
+	// malformed answers the system resources with truncated JSON
+	malformed bool
+	// resetStatus is the status code answered to the reset actions
+	resetStatus int
+}
+
+type stubSystem struct {
+	Id           string
+	SerialNumber string
+	PowerState   PowerState
+	// Inline is advertised as ResetType@Redfish.AllowableValues
+	Inline []ResetType
+	// Info is advertised through an ActionInfo resource
+	Info []ResetType
+}
+
+func newBmcStub(t *testing.T, systems ...*stubSystem) *bmcStub {
+	t.Helper()
+	stub := &bmcStub{systems: systems, password: testPassword, resetStatus: http.StatusNoContent}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *bmcStub) client(t *testing.T) *RedfishClient {
+	t.Helper()
+	client, err := NewClient(s.server.URL, testUsername, testPassword)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+// configure changes the behaviour of the stub while it is running.
+func (s *bmcStub) configure(configure func(s *bmcStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func (s *bmcStub) receivedResets() []ResetType {
+	return s.resets.Received()
+}
+
+func (s *bmcStub) receivedRequests() []string {
+	return s.requests.Received()
+}
+
+func (s *bmcStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != testUsername || password != s.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.requests.Record(r.URL.Path)
+
+	if r.URL.Path == "/redfish/v1/Systems" {
+		var members []link
+		for _, system := range s.systems {
+			members = append(members, link{Id: "/redfish/v1/Systems/" + system.Id})
+		}
+		json.NewEncoder(w).Encode(collection{Members: members})
+		return
+	}
+	for _, system := range s.systems {
+		path := "/redfish/v1/Systems/" + system.Id
+		switch r.URL.Path {
+		case path:
+			if s.malformed {
+				w.Write([]byte(`{"@odata.id": "` + path))
+				return
+			}
+			reset := map[string]interface{}{"target": path + "/Actions/ComputerSystem.Reset"}
+			if system.Inline != nil {
+				reset["ResetType@Redfish.AllowableValues"] = system.Inline
+			}
+			if system.Info != nil {
+				reset["@Redfish.ActionInfo"] = path + "/ResetActionInfo"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"@odata.id":    path,
+				"Id":           system.Id,
+				"SerialNumber": system.SerialNumber,
+				"PowerState":   system.PowerState,
+				"Actions":      map[string]interface{}{"#ComputerSystem.Reset": reset},
+			})
+			return
+		case path + "/ResetActionInfo":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Parameters": []map[string]interface{}{
+					{"Name": "ResetType", "AllowableValues": system.Info},
+				},
+			})
+			return
+		case path + "/Actions/ComputerSystem.Reset":
+			var body struct {
+				ResetType ResetType
+			}
+			if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if s.resetStatus >= 300 {
+				w.WriteHeader(s.resetStatus)
+				w.Write([]byte(`{"error":{"message":"reset refused"}}`))
+				return
+			}
+			s.resets.Record(body.ResetType)
+			w.WriteHeader(s.resetStatus)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func TestFindSystem(t *testing.T) {
+	first := &stubSystem{Id: "1", SerialNumber: "SN-0001", PowerState: PowerStateOn}
+	second := &stubSystem{Id: "2", SerialNumber: "SN-0002", PowerState: PowerStateOff}
+
+	tests := []struct {
+		name     string
+		systems  []*stubSystem
+		selector string
+		wantId   string
+		wantErr  string
+	}{
+		{name: "single system", systems: []*stubSystem{first}, wantId: "1"},
+		{name: "several systems without selector", systems: []*stubSystem{first, second}, wantErr: "found 2 systems"},
+		{name: "by id", systems: []*stubSystem{first, second}, selector: "2", wantId: "2"},
+		{name: "by serial number", systems: []*stubSystem{first, second}, selector: "SN-0001", wantId: "1"},
+		{name: "no match", systems: []*stubSystem{first, second}, selector: "3", wantErr: "no system matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newBmcStub(t, tt.systems...)
+
+			system, err := stub.client(t).FindSystem(moduletest.Context(t), tt.selector)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FindSystem() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindSystem() error = %v", err)
+			}
+			if system.Id != tt.wantId {
+				t.Errorf("FindSystem().Id = %q, want %q", system.Id, tt.wantId)
+			}
+			if want := "/redfish/v1/Systems/" + tt.wantId + "/Actions/ComputerSystem.Reset"; system.ResetTarget() != want {
+				t.Errorf("ResetTarget() = %q, want %q", system.ResetTarget(), want)
+			}
+		})
+	}
+}
+
+func TestFindSystemUnauthorized(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1"})
+	client, err := NewClient(stub.server.URL, testUsername, "wrong")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	_, err = client.FindSystem(moduletest.Context(t), "")
+	if err == nil || !strings.Contains(err.Error(), "StatusCode: 401") {
+		t.Errorf("FindSystem() error = %v, want an unauthorized error", err)
+	}
+}
+
+func TestAllowableResetTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		system *stubSystem
+		want   []ResetType
+	}{
+		{
+			name:   "allowable values",
+			system: &stubSystem{Id: "1", Inline: []ResetType{ResetTypeOn, ResetTypeForceOff}},
+			want:   []ResetType{ResetTypeOn, ResetTypeForceOff},
+		},
+		{
+			name:   "action info",
+			system: &stubSystem{Id: "1", Info: []ResetType{ResetTypeForceOn, ResetTypeNmi}},
+			want:   []ResetType{ResetTypeForceOn, ResetTypeNmi},
+		},
+		{
+			name:   "allowable values preferred over action info",
+			system: &stubSystem{Id: "1", Inline: []ResetType{ResetTypeOn}, Info: []ResetType{ResetTypeForceOn}},
+			want:   []ResetType{ResetTypeOn},
+		},
+		{
+			name:   "not advertised",
+			system: &stubSystem{Id: "1"},
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newBmcStub(t, tt.system)
+			client := stub.client(t)
+			system, err := client.FindSystem(moduletest.Context(t), "")
+			if err != nil {
+				t.Fatalf("FindSystem() error = %v", err)
+			}
+
+			got, err := client.AllowableResetTypes(moduletest.Context(t), system)
+			if err != nil {
+				t.Fatalf("AllowableResetTypes() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("AllowableResetTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickResetType(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     []ResetType
+		preferences []ResetType
+		want        ResetType
+		wantErr     bool
+	}{
+		{
+			name:        "first preference allowed",
+			allowed:     []ResetType{ResetTypeOn, ResetTypeForceOn},
+			preferences: []ResetType{ResetTypeOn, ResetTypeForceOn},
+			want:        ResetTypeOn,
+		},
+		{
+			name:        "fallback",
+			allowed:     []ResetType{ResetTypeForceOff, ResetTypePushPowerButton},
+			preferences: []ResetType{ResetTypeOn, ResetTypeForceOn, ResetTypePushPowerButton},
+			want:        ResetTypePushPowerButton,
+		},
+		{
+			name:        "nothing advertised",
+			allowed:     nil,
+			preferences: []ResetType{ResetTypeGracefulShutdown, ResetTypePushPowerButton},
+			want:        ResetTypeGracefulShutdown,
+		},
+		{
+			name:        "no preference allowed",
+			allowed:     []ResetType{ResetTypeForceRestart},
+			preferences: []ResetType{ResetTypeForceOff},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PickResetType(tt.allowed, tt.preferences...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PickResetType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PickResetType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package redfish
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type RedfishModule struct {
+	modules.DefaultModule
+	Config RedfishConfig
+	Client *RedfishClient
+
+	mu      sync.Mutex
+	system  *ComputerSystem
+	allowed []ResetType
+}
+
+type RedfishConfig struct {
+	Hostname string `validate:"required"`
+	Url      string `validate:"required"`
+	Username string `validate:"required"`
+	Password string `validate:"required"`
+	// System is the ID or the serial number of the system to control. It can
+	// be omitted when the BMC only exposes a single system.
+	System   string
+	PowerOff string `mapstructure:"power-off" validate:"omitempty,oneof=graceful force"`
+}
+
+func New() modules.Module {
+	return &RedfishModule{}
+}
+
+func (m *RedfishModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "redfish", err)
+	}
+	if m.Config.PowerOff == "" {
+		m.Config.PowerOff = "graceful"
+	}
+	m.Client, err = NewClient(m.Config.Url, m.Config.Username, m.Config.Password)
+	if err != nil {
+		return fmt.Errorf("error creating redfish client: %w", err)
+	}
+	return nil
+}
+
+// selectedSystem returns the selected system with a fresh state. The system
+// URI is discovered once and then reused, until it can't be retrieved anymore,
+// as when the BMC renumbers its systems. The lock is only held to access the
+// cached values, not during the requests.
+func (m *RedfishModule) selectedSystem(ctx context.Context) (*ComputerSystem, error) {
+	m.mu.Lock()
+	system := m.system
+	m.mu.Unlock()
+	if system != nil {
+		fresh, err := m.Client.System(ctx, system.Path)
+		if err != nil {
+			m.mu.Lock()
+			if m.system == system {
+				m.system = nil
+			}
+			m.mu.Unlock()
+		}
+		return fresh, err
+	}
+
+	system, err := m.Client.FindSystem(ctx, m.Config.System)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering the system: %w", err)
+	}
+	m.mu.Lock()
+	m.system = system
+	// The reset types of a rediscovered system are retrieved again
+	m.allowed = nil
+	m.mu.Unlock()
+	return system, nil
+}
+
+// allowableResetTypes returns the reset types allowed by the BMC for the
+// selected system. They are retrieved once and then reused.
+func (m *RedfishModule) allowableResetTypes(ctx context.Context, system *ComputerSystem) ([]ResetType, error) {
+	m.mu.Lock()
+	allowed := m.allowed
+	m.mu.Unlock()
+	if allowed != nil {
+		return allowed, nil
+	}
+
+	allowed, err := m.Client.AllowableResetTypes(ctx, system)
+	if err != nil {
+		return nil, err
+	}
+	if allowed == nil {
+		allowed = []ResetType{}
+	}
+	m.mu.Lock()
+	m.allowed = allowed
+	m.mu.Unlock()
+	return allowed, nil
+}
+
+func (m *RedfishModule) reset(ctx context.Context, preferences ...ResetType) error {
+	system, err := m.selectedSystem(ctx)
+	if err != nil {
+		return err
+	}
+	allowed, err := m.allowableResetTypes(ctx, system)
+	if err != nil {
+		return err
+	}
+	resetType, err := PickResetType(allowed, preferences...)
+	if err != nil {
+		return err
+	}
+	return m.Client.Reset(ctx, system, resetType)
+}
+
+// extendedResetTypes contains the reset type of each extended action.
+var extendedResetTypes = []struct {
+	capability modules.Capability
+	resetType  ResetType
+}{
+	{modules.CapabilityForceOff, ResetTypeForceOff},
+	{modules.CapabilityGracefulShutdown, ResetTypeGracefulShutdown},
+	{modules.CapabilityForceRestart, ResetTypeForceRestart},
+	{modules.CapabilityPowerCycle, ResetTypePowerCycle},
+	{modules.CapabilityNmi, ResetTypeNmi},
+}
+
+// Capabilities returns the extended actions matching the reset types allowed
+// by the BMC, as discovered by the state polls and the actions. When the BMC
+// does not advertise any value, or before they are discovered, all of them
+// are returned.
+func (m *RedfishModule) Capabilities() modules.Capabilities {
+	m.mu.Lock()
+	allowed := m.allowed
+	m.mu.Unlock()
+
+	capabilities := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff}
+	for _, extended := range extendedResetTypes {
+		if len(allowed) == 0 || slices.Contains(allowed, extended.resetType) {
+			capabilities = append(capabilities, extended.capability)
+		}
+	}
+	return capabilities
+}
+
+func (m *RedfishModule) State(ctx context.Context) modules.State {
+	powerStateTask, powerStateChan := modules.MakeAsync(func() modules.Result[PowerState] {
+		system, err := m.selectedSystem(ctx)
+		if err != nil {
+			return modules.Result[PowerState]{Err: err}
+		}
+		// The reset types are discovered along with the state, so that
+		// Capabilities doesn't have to, a failure is retried on the next poll
+		m.allowableResetTypes(ctx, system)
+		switch system.PowerState {
+		case PowerStateOn, PowerStateOff, PowerStatePoweringOn, PowerStatePoweringOff, PowerStatePaused:
+			return modules.Result[PowerState]{Value: system.PowerState}
+		}
+		return modules.Result[PowerState]{Err: fmt.Errorf("unknown power state %q", system.PowerState)}
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	go powerStateTask()
+	go pingTask()
+
+	powerState := <-powerStateChan
+	state := modules.NewState(modules.Result[bool]{Value: powerState.Value == PowerStateOn, Err: powerState.Err}, <-pingChan)
+	switch powerState.Value {
+	case PowerStatePoweringOn:
+		state.Power = modules.PoweringOn
+	case PowerStatePoweringOff:
+		state.Power = modules.PoweringOff
+	case PowerStatePaused:
+		state.Power = modules.PowerSleeping
+	}
+	return state
+}
+
+func (m *RedfishModule) PowerOn(ctx context.Context) error {
+	return m.reset(ctx, ResetTypeOn, ResetTypeForceOn, ResetTypePushPowerButton)
+}
+
+func (m *RedfishModule) PowerOff(ctx context.Context) error {
+	if m.Config.PowerOff == "force" {
+		return m.reset(ctx, ResetTypeForceOff)
+	}
+	return m.reset(ctx, ResetTypeGracefulShutdown, ResetTypePushPowerButton)
+}
+
+func (m *RedfishModule) Perform(ctx context.Context, action modules.Capability) error {
+	for _, extended := range extendedResetTypes {
+		if extended.capability == action {
+			return m.reset(ctx, extended.resetType)
+		}
+	}
+	return modules.ErrNotSupported
+}
@@ -0,0 +1,312 @@
+package redfish
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+func newTestModule(t *testing.T, stub *bmcStub, config map[string]interface{}) *RedfishModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*RedfishModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"url":      stub.server.URL,
+		"username": testUsername,
+		"password": testPassword,
+	}, config)
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		powerState PowerState
+		want       modules.PowerState
+	}{
+		{powerState: PowerStateOn, want: modules.PowerOn},
+		{powerState: PowerStateOff, want: modules.PowerOff},
+		{powerState: PowerStatePoweringOn, want: modules.PoweringOn},
+		{powerState: PowerStatePoweringOff, want: modules.PoweringOff},
+		{powerState: PowerStatePaused, want: modules.PowerSleeping},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.powerState), func(t *testing.T) {
+			stub := newBmcStub(t, &stubSystem{Id: "1", PowerState: tt.powerState})
+			module := newTestModule(t, stub, nil)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.want {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+			}
+		})
+	}
+}
+
+func TestStateError(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1"}, &stubSystem{Id: "2"})
+	module := newTestModule(t, stub, nil)
+
+	state := module.State(moduletest.Context(t))
+	if state.Power != modules.PowerUnknown {
+		t.Errorf("State().Power = %s, want %s", state.Power, modules.PowerUnknown)
+	}
+	if len(state.Errors) == 0 || !strings.Contains(state.Errors[0], "found 2 systems") {
+		t.Errorf("State().Errors = %v, want a discovery error", state.Errors)
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	tests := []struct {
+		name    string
+		inline  []ResetType
+		config  map[string]interface{}
+		powerOn bool
+		want    ResetType
+	}{
+		{name: "on", inline: []ResetType{ResetTypeOn, ResetTypeForceOff}, powerOn: true, want: ResetTypeOn},
+		{name: "on fallback", inline: []ResetType{ResetTypeForceOn, ResetTypeForceOff}, powerOn: true, want: ResetTypeForceOn},
+		{name: "on with power button", inline: []ResetType{ResetTypePushPowerButton}, powerOn: true, want: ResetTypePushPowerButton},
+		{name: "on without allowable values", powerOn: true, want: ResetTypeOn},
+		{name: "graceful off", inline: []ResetType{ResetTypeGracefulShutdown, ResetTypeForceOff}, want: ResetTypeGracefulShutdown},
+		{name: "graceful off with power button", inline: []ResetType{ResetTypeForceOff, ResetTypePushPowerButton}, want: ResetTypePushPowerButton},
+		{name: "forced off", inline: []ResetType{ResetTypeGracefulShutdown, ResetTypeForceOff}, config: map[string]interface{}{"power-off": "force"}, want: ResetTypeForceOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newBmcStub(t, &stubSystem{Id: "1", Inline: tt.inline})
+			module := newTestModule(t, stub, tt.config)
+
+			var err error
+			if tt.powerOn {
+				err = module.PowerOn(moduletest.Context(t))
+			} else {
+				err = module.PowerOff(moduletest.Context(t))
+			}
+			if err != nil {
+				t.Fatalf("power action error = %v", err)
+			}
+			if resets := stub.receivedResets(); !slices.Equal(resets, []ResetType{tt.want}) {
+				t.Errorf("resets = %v, want %v", resets, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerOffNotAllowed(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1", Inline: []ResetType{ResetTypeOn, ResetTypeGracefulShutdown}})
+	module := newTestModule(t, stub, map[string]interface{}{"power-off": "force"})
+
+	err := module.PowerOff(moduletest.Context(t))
+	if err == nil || !strings.Contains(err.Error(), "none of the reset types") {
+		t.Errorf("PowerOff() error = %v, want a reset type error", err)
+	}
+	if resets := stub.receivedResets(); len(resets) != 0 {
+		t.Errorf("resets = %v, want none", resets)
+	}
+}
+
+func TestResetError(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1"})
+	stub.configure(func(stub *bmcStub) {
+		stub.resetStatus = http.StatusConflict
+	})
+	module := newTestModule(t, stub, nil)
+
+	err := module.PowerOn(moduletest.Context(t))
+	if err == nil || !strings.Contains(err.Error(), "StatusCode: 409") {
+		t.Errorf("PowerOn() error = %v, want a conflict error", err)
+	}
+}
+
+func TestPerform(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1", Info: []ResetType{ResetTypeOn, ResetTypeForceOff, ResetTypeNmi}})
+	module := newTestModule(t, stub, nil)
+
+	if err := module.Perform(moduletest.Context(t), modules.CapabilityNmi); err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	if err := module.Perform(moduletest.Context(t), modules.CapabilityForceRestart); err == nil {
+		t.Error("Perform() succeeded with a reset type which isn't allowed")
+	}
+	if err := module.Perform(moduletest.Context(t), modules.CapabilitySuspend); !errors.Is(err, modules.ErrNotSupported) {
+		t.Errorf("Perform() error = %v, want %v", err, modules.ErrNotSupported)
+	}
+	if resets := stub.receivedResets(); !slices.Equal(resets, []ResetType{ResetTypeNmi}) {
+		t.Errorf("resets = %v, want %v", resets, ResetTypeNmi)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	allExtended := modules.Capabilities{
+		modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityForceOff, modules.CapabilityGracefulShutdown,
+		modules.CapabilityForceRestart, modules.CapabilityPowerCycle, modules.CapabilityNmi,
+	}
+	tests := []struct {
+		name string
+		info []ResetType
+		want modules.Capabilities
+	}{
+		{
+			name: "allowable values",
+			info: []ResetType{ResetTypeOn, ResetTypeForceOff, ResetTypeGracefulShutdown},
+			want: modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityForceOff, modules.CapabilityGracefulShutdown},
+		},
+		{
+			name: "not advertised",
+			want: allExtended,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newBmcStub(t, &stubSystem{Id: "1", Info: tt.info})
+			module := newTestModule(t, stub, nil)
+
+			// The reset types aren't known before the first state poll
+			if got := module.Capabilities(); !slices.Equal(got, allExtended) {
+				t.Errorf("Capabilities() before discovery = %v, want %v", got, allExtended)
+			}
+			module.State(moduletest.Context(t))
+			if got := module.Capabilities(); !slices.Equal(got, tt.want) {
+				t.Errorf("Capabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1", PowerState: PowerStateOn, Info: []ResetType{ResetTypeOn, ResetTypeNmi}})
+	module := newTestModule(t, stub, nil)
+
+	module.State(moduletest.Context(t))
+	want := []string{"/redfish/v1/Systems", "/redfish/v1/Systems/1", "/redfish/v1/Systems/1/ResetActionInfo"}
+	if requests := stub.receivedRequests(); !slices.Equal(requests, want) {
+		t.Fatalf("requests = %v, want %v", requests, want)
+	}
+
+	// Once discovered, only the state of the system is retrieved, and the
+	// capabilities are computed without any request
+	module.Capabilities()
+	module.State(moduletest.Context(t))
+	if err := module.Perform(moduletest.Context(t), modules.CapabilityNmi); err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	want = append(want, "/redfish/v1/Systems/1", "/redfish/v1/Systems/1", "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset")
+	if requests := stub.receivedRequests(); !slices.Equal(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
+
+func TestCapabilitiesDuringState(t *testing.T) {
+	stub := newBmcStub(t, &stubSystem{Id: "1", Info: []ResetType{ResetTypeOn}})
+	module := newTestModule(t, stub, nil)
+	module.State(moduletest.Context(t))
+
+	// The BMC doesn't answer while the state is retrieved
+	stub.mutex.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		module.State(moduletest.Context(t))
+	}()
+	capabilities := make(chan modules.Capabilities)
+	go func() { capabilities <- module.Capabilities() }()
+	select {
+	case <-capabilities:
+	case <-time.After(time.Second):
+		t.Error("Capabilities() blocked while the state was retrieved")
+	}
+	stub.mutex.Unlock()
+	<-done
+}
+
+func TestStateChanges(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is applied to the BMC once the system has been discovered
+		change    func(s *bmcStub)
+		wantPower modules.PowerState
+		wantErr   string
+		// wantNext is the power state retrieved by the next poll, when the
+		// module recovers
+		wantNext modules.PowerState
+	}{
+		{
+			name:      "credentials revoked",
+			change:    func(s *bmcStub) { s.password = "rotated" },
+			wantPower: modules.PowerUnknown,
+			wantErr:   "StatusCode: 401",
+		},
+		{
+			name:      "malformed system",
+			change:    func(s *bmcStub) { s.malformed = true },
+			wantPower: modules.PowerUnknown,
+			wantErr:   "error decoding the JSON response",
+		},
+		{
+			name:      "unknown power state",
+			change:    func(s *bmcStub) { s.systems[0].PowerState = "Unknown" },
+			wantPower: modules.PowerUnknown,
+			wantErr:   `unknown power state "Unknown"`,
+		},
+		{
+			name:      "missing power state",
+			change:    func(s *bmcStub) { s.systems[0].PowerState = "" },
+			wantPower: modules.PowerUnknown,
+			wantErr:   `unknown power state ""`,
+		},
+		{
+			// The BMC renumbered its systems, after a firmware update
+			name:      "system removed",
+			change:    func(s *bmcStub) { s.systems = []*stubSystem{{Id: "2", PowerState: PowerStateOff}} },
+			wantPower: modules.PowerUnknown,
+			wantErr:   "StatusCode: 404",
+			wantNext:  modules.PowerOff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newBmcStub(t, &stubSystem{Id: "1", PowerState: PowerStateOn})
+			module := newTestModule(t, stub, nil)
+			if state := module.State(moduletest.Context(t)); state.Power != modules.PowerOn {
+				t.Fatalf("State().Power = %s, want %s (errors: %v)", state.Power, modules.PowerOn, state.Errors)
+			}
+
+			stub.configure(tt.change)
+			state := module.State(moduletest.Context(t))
+			errs := moduletest.PowerErrors(state)
+			if state.Power != tt.wantPower || len(errs) != 1 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Errorf("State() = %s %v, want %s with %q", state.Power, state.Errors, tt.wantPower, tt.wantErr)
+			}
+			if tt.wantNext != "" {
+				if state := module.State(moduletest.Context(t)); state.Power != tt.wantNext {
+					t.Errorf("next State().Power = %s, want %s (errors: %v)", state.Power, tt.wantNext, state.Errors)
+				}
+			}
+		})
+	}
+}
+
+func TestUnreachableBmc(t *testing.T) {
+	module := moduletest.Init(t, New().(*RedfishModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"url":      "http://" + moduletest.ClosedAddress(t).String(),
+		"username": testUsername,
+		"password": testPassword,
+	}, nil)
+
+	state := module.State(moduletest.Context(t))
+	if errs := moduletest.PowerErrors(state); state.Power != modules.PowerUnknown || len(errs) != 1 || !strings.Contains(errs[0], "error sending the request") {
+		t.Errorf("State() = %s %v, want a connection error", state.Power, state.Errors)
+	}
+	if err := module.PowerOn(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+		t.Errorf("PowerOn() error = %v, want a connection error", err)
+	}
+	// The capabilities don't wait for the BMC
+	if got := module.Capabilities(); !got.Has(modules.CapabilityNmi) {
+		t.Errorf("Capabilities() = %v, want every action before the discovery", got)
+	}
+}
@@ -0,0 +1,243 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// SimModule simulates a server, for demonstrations, development and tests.
+// Once switched on, the server is powered after BootDelay and answers to
+// ping after ReachabilityDelay. Once switched off, it stops answering to ping
+// immediately and is unpowered after ShutdownDelay.
+type SimModule struct {
+	Config SimConfig
+
+	mutex sync.Mutex
+	state simState
+}
+
+type FailureConfig struct {
+	State    float64 `validate:"gte=0,lte=1"`
+	PowerOn  float64 `mapstructure:"power-on" validate:"gte=0,lte=1"`
+	PowerOff float64 `mapstructure:"power-off" validate:"gte=0,lte=1"`
+	Sleep    float64 `validate:"gte=0,lte=1"`
+}
+
+type SimConfig struct {
+	BootDelay         time.Duration `mapstructure:"boot-delay" validate:"gte=0"`
+	ShutdownDelay     time.Duration `mapstructure:"shutdown-delay" validate:"gte=0"`
+	ReachabilityDelay time.Duration `mapstructure:"reachability-delay" validate:"gte=0"`
+	// Failure contains the probability, between 0 and 1, of each operation
+	// to fail.
+	Failure FailureConfig `mapstructure:"failure-probability"`
+	// StateFile persists the state, which can then be shared between several
+	// power processes. The state is kept in memory otherwise.
+	StateFile string `mapstructure:"state-file"`
+	On        bool
+}
+
+type simState struct {
+	On       bool      `json:"on"`
+	Sleeping bool      `json:"sleeping"`
+	Since    time.Time `json:"since"`
+}
+
+func New() modules.Module {
+	return &SimModule{}
+}
+
+func (m *SimModule) Init(config map[string]interface{}) error {
+	m.Config = SimConfig{
+		BootDelay:         10 * time.Second,
+		ShutdownDelay:     5 * time.Second,
+		ReachabilityDelay: 20 * time.Second,
+	}
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "sim", err)
+	}
+
+	m.state = simState{On: m.Config.On}
+	if m.Config.StateFile != "" {
+		_, err = os.Stat(m.Config.StateFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return m.saveState()
+		}
+		return m.loadState()
+	}
+	return nil
+}
+
+func (m *SimModule) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate}
+	return append(capabilities, modules.ExtendedActions...)
+}
+
+func (m *SimModule) loadState() error {
+	if m.Config.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.Config.StateFile)
+	if err != nil {
+		return fmt.Errorf("error reading the state file: %w", err)
+	}
+	err = json.Unmarshal(data, &m.state)
+	if err != nil {
+		return fmt.Errorf("error decoding the state file: %w", err)
+	}
+	return nil
+}
+
+func (m *SimModule) saveState() error {
+	if m.Config.StateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return fmt.Errorf("error encoding the state: %w", err)
+	}
+	err = os.WriteFile(m.Config.StateFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing the state file: %w", err)
+	}
+	return nil
+}
+
+func fail(probability float64) bool {
+	return rand.Float64() < probability
+}
+
+func (m *SimModule) State(ctx context.Context) modules.State {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.loadState()
+	if err == nil && fail(m.Config.Failure.State) {
+		err = errors.New("simulated state failure")
+	}
+	if err != nil {
+		return modules.UnknownState(err)
+	}
+
+	elapsed := time.Since(m.state.Since)
+	state := modules.State{Power: modules.PowerOff}
+	state.SetReachable(false)
+	switch {
+	case m.state.Sleeping:
+		state.Power = modules.PowerSleeping
+	case m.state.On && elapsed < m.Config.BootDelay:
+		state.Power = modules.PoweringOn
+	case m.state.On:
+		state.Power = modules.PowerOn
+		state.SetReachable(elapsed >= m.Config.BootDelay+m.Config.ReachabilityDelay)
+	case elapsed < m.Config.ShutdownDelay:
+		state.Power = modules.PoweringOff
+	}
+	return state
+}
+
+func (m *SimModule) switchPower(ctx context.Context, on bool, failureProbability float64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.loadState()
+	if err != nil {
+		return err
+	}
+	if fail(failureProbability) {
+		if on {
+			return errors.New("simulated power-on failure")
+		}
+		return errors.New("simulated power-off failure")
+	}
+	if m.state.On == on && !m.state.Sleeping {
+		return nil
+	}
+	if m.state.Sleeping && !on {
+		// A sleeping server is switched off without any delay
+		m.state = simState{}
+		return m.saveState()
+	}
+	m.state = simState{On: on, Since: time.Now()}
+	return m.saveState()
+}
+
+// sleep puts the server to sleep immediately. The server is resumed by
+// PowerOn, then goes through the boot delays again.
+func (m *SimModule) sleep(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.loadState()
+	if err != nil {
+		return err
+	}
+	if fail(m.Config.Failure.Sleep) {
+		return errors.New("simulated sleep failure")
+	}
+	if !m.state.On {
+		return errors.New("the server is switched off")
+	}
+	m.state = simState{Sleeping: true, Since: time.Now()}
+	return m.saveState()
+}
+
+func (m *SimModule) Suspend(ctx context.Context) error {
+	return m.sleep(ctx)
+}
+
+func (m *SimModule) Hibernate(ctx context.Context) error {
+	return m.sleep(ctx)
+}
+
+func (m *SimModule) PowerOn(ctx context.Context) error {
+	return m.switchPower(ctx, true, m.Config.Failure.PowerOn)
+}
+
+func (m *SimModule) PowerOff(ctx context.Context) error {
+	return m.switchPower(ctx, false, m.Config.Failure.PowerOff)
+}
+
+// Perform simulates the extended actions. Forced power-offs are immediate,
+// restarts go through the boot delays again and NMIs have no effect.
+func (m *SimModule) Perform(ctx context.Context, action modules.Capability) error {
+	if action == modules.CapabilityGracefulShutdown {
+		return m.PowerOff(ctx)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.loadState()
+	if err != nil {
+		return err
+	}
+	switch action {
+	case modules.CapabilityForceOff, modules.CapabilityPressAndHold:
+		if fail(m.Config.Failure.PowerOff) {
+			return errors.New("simulated power-off failure")
+		}
+		m.state = simState{}
+	case modules.CapabilityForceRestart, modules.CapabilityPowerCycle:
+		if fail(m.Config.Failure.PowerOn) {
+			return errors.New("simulated power-on failure")
+		}
+		m.state = simState{On: true, Since: time.Now()}
+	case modules.CapabilityNmi:
+		if !m.state.On {
+			return errors.New("the server is switched off")
+		}
+		return nil
+	default:
+		return modules.ErrNotSupported
+	}
+	return m.saveState()
+}
@@ -0,0 +1,156 @@
+package snmppdu
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	defaultPort    = 161
+	requestTimeout = 5 * time.Second
+)
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":    gosnmp.DES,
+	"AES":    gosnmp.AES,
+	"AES192": gosnmp.AES192,
+	"AES256": gosnmp.AES256,
+}
+
+type V3Config struct {
+	Username     string `validate:"required"`
+	AuthProtocol string `mapstructure:"auth-protocol" validate:"omitempty,oneof=MD5 SHA SHA224 SHA256 SHA384 SHA512"`
+	AuthPassword string `mapstructure:"auth-password" validate:"required_with=AuthProtocol"`
+	PrivProtocol string `mapstructure:"priv-protocol" validate:"omitempty,oneof=DES AES AES192 AES256"`
+	PrivPassword string `mapstructure:"priv-password" validate:"required_with=PrivProtocol"`
+}
+
+// SnmpClient reads and switches a single outlet of a PDU.
+type SnmpClient struct {
+	address   string
+	port      int
+	version   string
+	community string
+	v3        *V3Config
+	profile   Profile
+	outlet    int
+}
+
+// newParams builds the connection parameters. GoSNMP is not safe for
+// concurrent use, so every session gets its own.
+func (c *SnmpClient) newParams() *gosnmp.GoSNMP {
+	params := &gosnmp.GoSNMP{
+		Target:    c.address,
+		Port:      uint16(c.port),
+		Community: c.community,
+		Version:   gosnmp.Version2c,
+		Timeout:   requestTimeout,
+		Retries:   1,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	if c.version != "3" {
+		return params
+	}
+
+	securityParameters := &gosnmp.UsmSecurityParameters{
+		UserName:               c.v3.Username,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+	params.MsgFlags = gosnmp.NoAuthNoPriv
+	if c.v3.AuthProtocol != "" {
+		securityParameters.AuthenticationProtocol = authProtocols[c.v3.AuthProtocol]
+		securityParameters.AuthenticationPassphrase = c.v3.AuthPassword
+		params.MsgFlags = gosnmp.AuthNoPriv
+	}
+	if c.v3.PrivProtocol != "" {
+		securityParameters.PrivacyProtocol = privProtocols[c.v3.PrivProtocol]
+		securityParameters.PrivacyPassphrase = c.v3.PrivPassword
+		params.MsgFlags = gosnmp.AuthPriv
+	}
+	params.Version = gosnmp.Version3
+	params.SecurityModel = gosnmp.UserSecurityModel
+	params.SecurityParameters = securityParameters
+	return params
+}
+
+func (c *SnmpClient) session(ctx context.Context, routine func(client *gosnmp.GoSNMP) error) error {
+	client := c.newParams()
+	client.Context = ctx
+	err := client.Connect()
+	if err != nil {
+		return fmt.Errorf("error connecting to the PDU: %w", err)
+	}
+	defer client.Conn.Close()
+	return routine(client)
+}
+
+func (c *SnmpClient) OutletOn(ctx context.Context) (bool, error) {
+	oid := c.profile.StateOidFor(c.outlet)
+	var on bool
+	err := c.session(ctx, func(client *gosnmp.GoSNMP) error {
+		result, err := client.Get([]string{oid})
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", oid, err)
+		}
+		if len(result.Variables) != 1 {
+			return fmt.Errorf("unexpected number of variables for %s", oid)
+		}
+		variable := result.Variables[0]
+		if variable.Type == gosnmp.NoSuchObject || variable.Type == gosnmp.NoSuchInstance {
+			return fmt.Errorf("the PDU has no %s object, check the outlet and the profile", oid)
+		}
+		// Any other value would be read as 0, and the outlet reported off
+		if variable.Type != gosnmp.Integer && variable.Type != gosnmp.Gauge32 && variable.Type != gosnmp.Uinteger32 {
+			return fmt.Errorf("the %s object has the %s type instead of an integer, check the outlet and the profile", oid, variable.Type)
+		}
+		on = gosnmp.ToBigInt(variable.Value).Int64() == int64(c.profile.StateOn)
+		return nil
+	})
+	return on, err
+}
+
+func (c *SnmpClient) SetOutlet(ctx context.Context, on bool) error {
+	oid := c.profile.ControlOidFor(c.outlet)
+	value := c.profile.ControlOff
+	if on {
+		value = c.profile.ControlOn
+	}
+	return c.session(ctx, func(client *gosnmp.GoSNMP) error {
+		result, err := client.Set([]gosnmp.SnmpPDU{{Name: oid, Type: gosnmp.Integer, Value: value}})
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", oid, err)
+		}
+		if result.Error != gosnmp.NoError {
+			return fmt.Errorf("error writing %s: %s", oid, result.Error)
+		}
+		return nil
+	})
+}
+
+func NewClient(address string, port int, version string, community string, v3 *V3Config, profile Profile, outlet int) (*SnmpClient, error) {
+	if port == 0 {
+		port = defaultPort
+	}
+	if version == "3" {
+		if v3 == nil {
+			return nil, fmt.Errorf("the v3 settings are required with SNMP v3")
+		}
+		if v3.PrivProtocol != "" && v3.AuthProtocol == "" {
+			return nil, fmt.Errorf("privacy requires an authentication protocol")
+		}
+	}
+	return &SnmpClient{address, port, version, community, v3, profile, outlet}, nil
+}
@@ -0,0 +1,92 @@
+package snmppdu
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type SnmpPduModule struct {
+	modules.DefaultModule
+	Config SnmpPduConfig
+	Client *SnmpClient
+}
+
+type SnmpPduConfig struct {
+	Hostname  string
+	Address   string             `validate:"required"`
+	Port      int                `validate:"gte=0,lte=65535"`
+	Outlet    int                `validate:"required,gt=0"`
+	Profile   string             `validate:"required"`
+	Profiles  map[string]Profile `validate:"dive"`
+	Version   string             `validate:"omitempty,oneof=2c 3"`
+	Community string             `validate:"required_unless=Version 3"`
+	V3        *V3Config
+}
+
+func New() modules.Module {
+	return &SnmpPduModule{}
+}
+
+func (m *SnmpPduModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "snmp-pdu", err)
+	}
+
+	// The profiles of the configuration take precedence over the builtin ones
+	profile, ok := m.Config.Profiles[m.Config.Profile]
+	if !ok {
+		profile, ok = builtinProfiles[m.Config.Profile]
+	}
+	if !ok {
+		profileNames := make([]string, 0, len(builtinProfiles)+len(m.Config.Profiles))
+		for profileName := range builtinProfiles {
+			profileNames = append(profileNames, profileName)
+		}
+		for profileName := range m.Config.Profiles {
+			profileNames = append(profileNames, profileName)
+		}
+		sort.Strings(profileNames)
+		return fmt.Errorf("can't find the %q profile (available profiles: %s)", m.Config.Profile, strings.Join(profileNames, ", "))
+	}
+
+	m.Client, err = NewClient(m.Config.Address, m.Config.Port, m.Config.Version, m.Config.Community, m.Config.V3, profile, m.Config.Outlet)
+	if err != nil {
+		return fmt.Errorf("error creating snmp client: %w", err)
+	}
+	return nil
+}
+
+func (m *SnmpPduModule) State(ctx context.Context) modules.State {
+	if m.Config.Hostname == "" {
+		value, err := m.Client.OutletOn(ctx)
+		return modules.NewState(modules.Result[bool]{Value: value, Err: err}, modules.Result[bool]{Value: value, Err: err})
+	}
+
+	outletTask, outletChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := m.Client.OutletOn(ctx)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	go outletTask()
+	go pingTask()
+
+	return modules.NewState(<-outletChan, <-pingChan)
+}
+
+func (m *SnmpPduModule) PowerOn(ctx context.Context) error {
+	return m.Client.SetOutlet(ctx, true)
+}
+
+func (m *SnmpPduModule) PowerOff(ctx context.Context) error {
+	return m.Client.SetOutlet(ctx, false)
+}
@@ -0,0 +1,318 @@
+package snmppdu
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const testCommunity = "private"
+
+// agentStub is a local stand-in for the SNMP v2c agent of a PDU. It serves
+// integer objects, which can be read and written.
+type agentStub struct {
+	conn *net.UDPConn
+	// sets records the received writes, as "<oid>=<value>"
+	sets moduletest.Recorder[string]
+
+	mutex  sync.Mutex
+	values map[string]int
+	// setError is answered to the writes instead of performing them
+	setError gosnmp.SNMPError
+	// strings answers the reads with the values as strings, as the agents
+	// exposing a textual status
+	strings bool
+}
+
+func newAgentStub(t *testing.T, values map[string]int) *agentStub {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &agentStub{conn: conn, values: values}
+	go agent.serve()
+	t.Cleanup(func() { conn.Close() })
+	return agent
+}
+
+func (a *agentStub) port() int {
+	return a.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// configure changes the objects of the agent while it is running.
+func (a *agentStub) configure(configure func(a *agentStub)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	configure(a)
+}
+
+func (a *agentStub) serve() {
+	buffer := make([]byte, 65535)
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: testCommunity}
+	for {
+		n, address, err := a.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		request, err := decoder.SnmpDecodePacket(buffer[:n])
+		// Like real agents, requests with another community are ignored
+		if err != nil || request.Community != testCommunity {
+			continue
+		}
+		response := a.handle(request)
+		data, err := response.MarshalMsg()
+		if err != nil {
+			continue
+		}
+		a.conn.WriteToUDP(data, address)
+	}
+}
+
+func (a *agentStub) handle(request *gosnmp.SnmpPacket) *gosnmp.SnmpPacket {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	response := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: request.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: request.RequestID,
+	}
+	for i, variable := range request.Variables {
+		oid := strings.TrimPrefix(variable.Name, ".")
+		value, ok := a.values[oid]
+		switch {
+		case request.PDUType == gosnmp.GetRequest && !ok:
+			response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: variable.Name, Type: gosnmp.NoSuchInstance})
+		case request.PDUType == gosnmp.GetRequest && a.strings:
+			response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: variable.Name, Type: gosnmp.OctetString, Value: []byte(fmt.Sprint(value))})
+		case request.PDUType == gosnmp.GetRequest:
+			response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: variable.Name, Type: gosnmp.Integer, Value: value})
+		case request.PDUType == gosnmp.SetRequest && (!ok || a.setError != gosnmp.NoError):
+			response.Error = a.setError
+			if !ok {
+				response.Error = gosnmp.NotWritable
+			}
+			response.ErrorIndex = uint8(i + 1)
+			response.Variables = request.Variables
+			return response
+		case request.PDUType == gosnmp.SetRequest:
+			value = int(gosnmp.ToBigInt(variable.Value).Int64())
+			a.values[oid] = value
+			a.sets.Record(fmt.Sprintf("%s=%d", oid, value))
+			response.Variables = append(response.Variables, variable)
+		}
+	}
+	return response
+}
+
+func newTestModule(t *testing.T, agent *agentStub, config map[string]interface{}) *SnmpPduModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*SnmpPduModule), map[string]interface{}{
+		"address":   "127.0.0.1",
+		"port":      agent.port(),
+		"community": testCommunity,
+		"outlet":    3,
+		"profile":   "apc",
+	}, config)
+}
+
+var customProfile = map[string]interface{}{
+	"custom": map[string]interface{}{
+		"state-oid":   "1.3.6.1.4.1.99999.1.{outlet}.1",
+		"state-on":    3,
+		"control-oid": "1.3.6.1.4.1.99999.2.{outlet}",
+		"control-on":  5,
+		"control-off": 6,
+	},
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		oid     string
+		value   int
+		want    modules.PowerState
+	}{
+		{"apc on", "apc", "1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3", 1, modules.PowerOn},
+		{"apc off", "apc", "1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3", 2, modules.PowerOff},
+		{"cyberpower on", "cyberpower", "1.3.6.1.4.1.3808.1.1.3.3.5.1.1.4.3", 1, modules.PowerOn},
+		{"cyberpower off", "cyberpower", "1.3.6.1.4.1.3808.1.1.3.3.5.1.1.4.3", 2, modules.PowerOff},
+		{"raritan on", "raritan", "1.3.6.1.4.1.13742.6.5.4.3.1.3.1.3.14", 7, modules.PowerOn},
+		{"raritan off", "raritan", "1.3.6.1.4.1.13742.6.5.4.3.1.3.1.3.14", 8, modules.PowerOff},
+		{"custom on", "custom", "1.3.6.1.4.1.99999.1.3.1", 3, modules.PowerOn},
+		{"custom off", "custom", "1.3.6.1.4.1.99999.1.3.1", 1, modules.PowerOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newAgentStub(t, map[string]int{tt.oid: tt.value})
+			module := newTestModule(t, agent, map[string]interface{}{"profile": tt.profile, "profiles": customProfile})
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != tt.want {
+				t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.want, state.Errors)
+			}
+			// Without hostname, the server is reachable when the outlet is on
+			if state.Reachable == nil || *state.Reachable != (tt.want == modules.PowerOn) {
+				t.Errorf("State().Reachable = %v, want %t", state.Reachable, tt.want == modules.PowerOn)
+			}
+		})
+	}
+}
+
+func TestStateErrors(t *testing.T) {
+	agent := newAgentStub(t, map[string]int{"1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3": 1})
+
+	t.Run("unknown outlet", func(t *testing.T) {
+		module := newTestModule(t, agent, map[string]interface{}{"outlet": 4})
+		state := module.State(moduletest.Context(t))
+		want := "power: the PDU has no 1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.4 object, check the outlet and the profile"
+		if state.Power != modules.PowerUnknown || !slices.Contains(state.Errors, want) {
+			t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, state.Errors, want)
+		}
+	})
+
+	t.Run("wrong community", func(t *testing.T) {
+		module := newTestModule(t, agent, map[string]interface{}{"community": "public"})
+		state := module.State(moduletest.ContextWithTimeout(t, 500*time.Millisecond))
+		if state.Power != modules.PowerUnknown || len(state.Errors) == 0 || !strings.HasPrefix(state.Errors[0], "power: error reading 1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3") {
+			t.Errorf("State() = %s %v, want an unknown state with a read error", state.Power, state.Errors)
+		}
+	})
+
+	t.Run("string value", func(t *testing.T) {
+		agent := newAgentStub(t, map[string]int{"1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3": 1})
+		agent.configure(func(a *agentStub) { a.strings = true })
+		module := newTestModule(t, agent, nil)
+		state := module.State(moduletest.Context(t))
+		want := "power: the 1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3 object has the OctetString type instead of an integer, check the outlet and the profile"
+		if state.Power != modules.PowerUnknown || !slices.Contains(state.Errors, want) {
+			t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, state.Errors, want)
+		}
+	})
+
+	t.Run("unreachable agent", func(t *testing.T) {
+		address := moduletest.ClosedUDPAddress(t)
+		module := moduletest.Init(t, New().(*SnmpPduModule), map[string]interface{}{
+			"address":   address.IP.String(),
+			"port":      address.Port,
+			"community": testCommunity,
+			"outlet":    3,
+			"profile":   "apc",
+		}, nil)
+		start := time.Now()
+		state := module.State(moduletest.ContextWithTimeout(t, 500*time.Millisecond))
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("State() took %s, want it to give up with the context", elapsed)
+		}
+		if state.Power != modules.PowerUnknown || len(state.Errors) == 0 || !strings.HasPrefix(state.Errors[0], "power: error reading 1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.3") {
+			t.Errorf("State() = %s %v, want an unknown state with a read error", state.Power, state.Errors)
+		}
+		if err := module.PowerOn(moduletest.ContextWithTimeout(t, 500*time.Millisecond)); err == nil {
+			t.Error("PowerOn() succeeded without agent")
+		}
+	})
+}
+
+func TestPowerActions(t *testing.T) {
+	tests := []struct {
+		profile    string
+		controlOid string
+		on, off    int
+	}{
+		{"apc", "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.3", 1, 2},
+		{"cyberpower", "1.3.6.1.4.1.3808.1.1.3.3.3.1.1.4.3", 1, 2},
+		{"raritan", "1.3.6.1.4.1.13742.6.4.1.2.1.2.1.3", 1, 0},
+		{"custom", "1.3.6.1.4.1.99999.2.3", 5, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			agent := newAgentStub(t, map[string]int{tt.controlOid: 0})
+			module := newTestModule(t, agent, map[string]interface{}{"profile": tt.profile, "profiles": customProfile})
+
+			if err := module.PowerOn(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOn() error = %v", err)
+			}
+			if err := module.PowerOff(moduletest.Context(t)); err != nil {
+				t.Fatalf("PowerOff() error = %v", err)
+			}
+			want := []string{fmt.Sprintf("%s=%d", tt.controlOid, tt.on), fmt.Sprintf("%s=%d", tt.controlOid, tt.off)}
+			if sets := agent.sets.Received(); !slices.Equal(sets, want) {
+				t.Errorf("sets = %v, want %v", sets, want)
+			}
+		})
+	}
+}
+
+func TestPowerActionErrors(t *testing.T) {
+	controlOid := "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.3"
+	tests := []struct {
+		name     string
+		outlet   int
+		setError gosnmp.SNMPError
+		want     string
+	}{
+		{"read-only community", 3, gosnmp.NoAccess, "error writing " + controlOid + ": NoAccess"},
+		{"unknown outlet", 4, gosnmp.NoError, "error writing 1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.4: NotWritable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newAgentStub(t, map[string]int{controlOid: 0})
+			agent.configure(func(a *agentStub) { a.setError = tt.setError })
+			module := newTestModule(t, agent, map[string]interface{}{"outlet": tt.outlet})
+
+			err := module.PowerOn(moduletest.Context(t))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("PowerOn() error = %v, want %s", err, tt.want)
+			}
+			if sets := agent.sets.Received(); len(sets) != 0 {
+				t.Errorf("sets = %v, want none", sets)
+			}
+		})
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		want   string
+	}{
+		{"unknown profile", map[string]interface{}{"profile": "eaton"}, `can't find the "eaton" profile (available profiles: apc, custom, cyberpower, raritan)`},
+		{"v3 without settings", map[string]interface{}{"version": "3"}, "error creating snmp client: the v3 settings are required with SNMP v3"},
+		{
+			"v3 privacy without authentication",
+			map[string]interface{}{"version": "3", "v3": map[string]interface{}{"username": "power", "priv-protocol": "AES", "priv-password": "secret"}},
+			"error creating snmp client: privacy requires an authentication protocol",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moduleConfig := map[string]interface{}{
+				"address":   "127.0.0.1",
+				"community": testCommunity,
+				"outlet":    3,
+				"profile":   "apc",
+				"profiles":  customProfile,
+			}
+			for key, value := range tt.config {
+				moduleConfig[key] = value
+			}
+			err := New().Init(moduleConfig)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Init() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
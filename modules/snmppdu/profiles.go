@@ -0,0 +1,56 @@
+package snmppdu
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Profile describes the outlet OIDs of a PDU model. The {outlet} placeholder
+// of the OIDs is replaced with the outlet number.
+type Profile struct {
+	StateOid   string `mapstructure:"state-oid" validate:"required"`
+	StateOn    int    `mapstructure:"state-on"`
+	ControlOid string `mapstructure:"control-oid" validate:"required"`
+	ControlOn  int    `mapstructure:"control-on"`
+	ControlOff int    `mapstructure:"control-off"`
+}
+
+func (p *Profile) oid(template string, outlet int) string {
+	return strings.ReplaceAll(template, "{outlet}", strconv.Itoa(outlet))
+}
+
+func (p *Profile) StateOidFor(outlet int) string {
+	return p.oid(p.StateOid, outlet)
+}
+
+func (p *Profile) ControlOidFor(outlet int) string {
+	return p.oid(p.ControlOid, outlet)
+}
+
+var builtinProfiles = map[string]Profile{
+	// PowerNet-MIB, rPDUOutletStatusOutletState and rPDUOutletControlOutletCommand
+	"apc": {
+		StateOid:   "1.3.6.1.4.1.318.1.1.12.3.5.1.1.4.{outlet}",
+		StateOn:    1,
+		ControlOid: "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.{outlet}",
+		ControlOn:  1,
+		ControlOff: 2,
+	},
+	// CPS-MIB, ePDUOutletStatusOutletState and ePDUOutletControlOutletCommand
+	"cyberpower": {
+		StateOid:   "1.3.6.1.4.1.3808.1.1.3.3.5.1.1.4.{outlet}",
+		StateOn:    1,
+		ControlOid: "1.3.6.1.4.1.3808.1.1.3.3.3.1.1.4.{outlet}",
+		ControlOn:  1,
+		ControlOff: 2,
+	},
+	// PDU2-MIB, measurementsOutletSensorState of the onOff sensor and
+	// switchingOperation of the first PDU
+	"raritan": {
+		StateOid:   "1.3.6.1.4.1.13742.6.5.4.3.1.3.1.{outlet}.14",
+		StateOn:    7,
+		ControlOid: "1.3.6.1.4.1.13742.6.4.1.2.1.2.1.{outlet}",
+		ControlOn:  1,
+		ControlOff: 0,
+	},
+}
@@ -0,0 +1,195 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultPort             = 22
+	defaultPowerOffCommand  = "sudo systemctl poweroff"
+	defaultSuspendCommand   = "sudo systemctl suspend"
+	defaultHibernateCommand = "sudo systemctl hibernate"
+	dialTimeout             = 10 * time.Second
+	// The server may fall asleep before the sleep commands return, so they
+	// are considered successful once they have been running for sleepDelay.
+	sleepDelay = 5 * time.Second
+)
+
+// ClientConfig holds the settings used to run commands on the server. It is
+// shared by the ssh module and the ssh option of the wol module.
+type ClientConfig struct {
+	Address          string
+	Port             int    `validate:"gte=0,lte=65535"`
+	Username         string `validate:"required"`
+	PrivateKey       string `mapstructure:"private-key" validate:"required"`
+	Passphrase       string
+	KnownHosts       string `mapstructure:"known-hosts"`
+	PowerOffCommand  string `mapstructure:"power-off-command"`
+	SuspendCommand   string `mapstructure:"suspend-command"`
+	HibernateCommand string `mapstructure:"hibernate-command"`
+}
+
+type SshClient struct {
+	address          string
+	config           *gossh.ClientConfig
+	powerOffCommand  string
+	suspendCommand   string
+	hibernateCommand string
+}
+
+func (c *SshClient) Run(ctx context.Context, command string) error {
+	return c.run(ctx, command, 0)
+}
+
+// run runs command on the server. When returnAfter is positive, the command
+// is left behind and considered successful if it is still running after
+// returnAfter.
+func (c *SshClient) run(ctx context.Context, command string, returnAfter time.Duration) error {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", c.address, err)
+	}
+	// Closing the connection interrupts the handshake and the command
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	clientConn, channels, requests, err := gossh.NewClientConn(conn, c.address, c.config)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return fmt.Errorf("error connecting to %s: %w", c.address, ctx.Err())
+		}
+		return fmt.Errorf("error connecting to %s: %w", c.address, err)
+	}
+	client := gossh.NewClient(clientConn, channels, requests)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("error opening the SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var output bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &output
+
+	err = session.Start(command)
+	if err != nil {
+		return fmt.Errorf("error starting %q: %w", command, err)
+	}
+	var leftBehind atomic.Bool
+	if returnAfter > 0 {
+		timer := time.AfterFunc(returnAfter, func() {
+			leftBehind.Store(true)
+			client.Close()
+		})
+		defer timer.Stop()
+	}
+	err = session.Wait()
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("error running %q: %w", command, ctx.Err())
+	}
+	if err != nil && !leftBehind.Load() {
+		// The connection is often torn down by the shutdown before the exit
+		// status can be sent back
+		var exitMissingError *gossh.ExitMissingError
+		if errors.As(err, &exitMissingError) {
+			return nil
+		}
+		return fmt.Errorf("error running %q (Output: %v): %w", command, output.String(), err)
+	}
+	return nil
+}
+
+func (c *SshClient) PowerOff(ctx context.Context) error {
+	return c.Run(ctx, c.powerOffCommand)
+}
+
+func (c *SshClient) Suspend(ctx context.Context) error {
+	return c.run(ctx, c.suspendCommand, sleepDelay)
+}
+
+func (c *SshClient) Hibernate(ctx context.Context) error {
+	return c.run(ctx, c.hibernateCommand, sleepDelay)
+}
+
+func readPrivateKey(config *ClientConfig) (gossh.Signer, error) {
+	key, err := os.ReadFile(config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the private key: %w", err)
+	}
+	if config.Passphrase != "" {
+		return gossh.ParsePrivateKeyWithPassphrase(key, []byte(config.Passphrase))
+	}
+	return gossh.ParsePrivateKey(key)
+}
+
+// NewClient creates a client authenticating with a private key. The server
+// host key must be listed in the known_hosts file.
+func NewClient(hostname string, config *ClientConfig) (*SshClient, error) {
+	address := config.Address
+	if address == "" {
+		address = hostname
+	}
+	port := config.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	powerOffCommand := config.PowerOffCommand
+	if powerOffCommand == "" {
+		powerOffCommand = defaultPowerOffCommand
+	}
+	suspendCommand := config.SuspendCommand
+	if suspendCommand == "" {
+		suspendCommand = defaultSuspendCommand
+	}
+	hibernateCommand := config.HibernateCommand
+	if hibernateCommand == "" {
+		hibernateCommand = defaultHibernateCommand
+	}
+
+	signer, err := readPrivateKey(config)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the private key: %w", err)
+	}
+
+	knownHostsFile := config.KnownHosts
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error locating the known_hosts file: %w", err)
+		}
+		knownHostsFile = path.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the known_hosts file: %w", err)
+	}
+
+	return &SshClient{
+		address: net.JoinHostPort(address, strconv.Itoa(port)),
+		config: &gossh.ClientConfig{
+			User:            config.Username,
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         dialTimeout,
+		},
+		powerOffCommand:  powerOffCommand,
+		suspendCommand:   suspendCommand,
+		hibernateCommand: hibernateCommand,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tr4cks/power/modules"
+)
+
+type SshModule struct {
+	modules.DefaultModule
+	Config SshConfig
+	Client *SshClient
+
+	// asleep is set once the server has been put to sleep, until it answers
+	// to ping again
+	asleep atomic.Bool
+}
+
+type SshConfig struct {
+	Hostname     string `validate:"required"`
+	ClientConfig `mapstructure:",squash"`
+}
+
+func New() modules.Module {
+	return &SshModule{}
+}
+
+func (m *SshModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "ssh", err)
+	}
+	m.Client, err = NewClient(m.Config.Hostname, &m.Config.ClientConfig)
+	if err != nil {
+		return fmt.Errorf("error creating ssh client: %w", err)
+	}
+	return nil
+}
+
+// State reports the server as sleeping once it has been put to sleep, until
+// it answers again.
+func (m *SshModule) State(ctx context.Context) modules.State {
+	ping, err := modules.Ping(ctx, m.Config.Hostname)
+	state := modules.NewState(modules.Result[bool]{Value: ping, Err: err}, modules.Result[bool]{Value: ping, Err: err})
+	if err == nil && ping {
+		m.asleep.Store(false)
+	}
+	if err == nil && !ping && m.asleep.Load() {
+		state.Power = modules.PowerSleeping
+	}
+	return state
+}
+
+func (m *SshModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate}
+}
+
+func (m *SshModule) PowerOff(ctx context.Context) error {
+	return m.Client.PowerOff(ctx)
+}
+
+func (m *SshModule) Suspend(ctx context.Context) error {
+	err := m.Client.Suspend(ctx)
+	if err != nil {
+		return err
+	}
+	m.asleep.Store(true)
+	return nil
+}
+
+func (m *SshModule) Hibernate(ctx context.Context) error {
+	err := m.Client.Hibernate(ctx)
+	if err != nil {
+		return err
+	}
+	m.asleep.Store(true)
+	return nil
+}
@@ -0,0 +1,117 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PowerState is the power state of a server.
+type PowerState string
+
+const (
+	PowerOn       PowerState = "on"
+	PowerOff      PowerState = "off"
+	PoweringOn    PowerState = "powering-on"
+	PoweringOff   PowerState = "powering-off"
+	PowerSleeping PowerState = "sleeping"
+	// PowerUnknown is reported when the power state couldn't be retrieved
+	PowerUnknown PowerState = "unknown"
+)
+
+// PowerStateOf returns PowerOn when on is true, and PowerOff otherwise.
+func PowerStateOf(on bool) PowerState {
+	if on {
+		return PowerOn
+	}
+	return PowerOff
+}
+
+// Powered reports whether the server is switched on, or is being switched on
+// or off.
+func (p PowerState) Powered() bool {
+	return p == PowerOn || p == PoweringOn || p == PoweringOff
+}
+
+// Transitioning reports whether the server is being switched on or off.
+func (p PowerState) Transitioning() bool {
+	return p == PoweringOn || p == PoweringOff
+}
+
+// State is the state of a server, as retrieved by a module.
+type State struct {
+	Power PowerState `json:"power"`
+	// Reachable reports whether the server answers on the network, or
+	// whether its operating system or service is up, depending on the module.
+	// It is nil when unknown.
+	Reachable *bool `json:"reachable"`
+	// CheckedAt is the time at which the state has been retrieved
+	CheckedAt time.Time `json:"checked_at"`
+	// Errors contains the errors which occurred while retrieving the state
+	Errors []string `json:"errors,omitempty"`
+	// Attributes contains the additional information specific to the module,
+	// such as the power drawn by the server
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// NewState returns the state of a server from the results of its power and
+// reachability checks. A server answering on the network is switched on,
+// even when its power state couldn't be retrieved.
+func NewState(power Result[bool], reachable Result[bool]) State {
+	state := State{Power: PowerUnknown}
+	if power.Err != nil {
+		state.AddError("power", power.Err)
+	} else {
+		state.Power = PowerStateOf(power.Value)
+	}
+	if reachable.Err != nil {
+		state.AddError("reachability", reachable.Err)
+	} else {
+		state.SetReachable(reachable.Value)
+		if reachable.Value && state.Power == PowerUnknown {
+			state.Power = PowerOn
+		}
+	}
+	return state
+}
+
+// UnknownState returns the state of a server which couldn't be retrieved
+// because of err.
+func UnknownState(err error) State {
+	state := State{Power: PowerUnknown}
+	state.AddError("state", err)
+	return state
+}
+
+func (s *State) SetReachable(reachable bool) {
+	s.Reachable = &reachable
+}
+
+// IsReachable reports whether the server is known to be reachable.
+func (s State) IsReachable() bool {
+	return s.Reachable != nil && *s.Reachable
+}
+
+// AddError records an error which occurred while retrieving a part of the
+// state.
+func (s *State) AddError(part string, err error) {
+	s.Errors = append(s.Errors, fmt.Sprintf("%s: %s", part, err))
+}
+
+func (s *State) SetAttribute(name string, value any) {
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]any)
+	}
+	s.Attributes[name] = value
+}
+
+// ReadState retrieves the state of the server with module, and records the
+// time at which it has been checked.
+func ReadState(ctx context.Context, module Module) State {
+	state := module.State(ctx)
+	if state.Power == "" {
+		state.Power = PowerUnknown
+	}
+	state.CheckedAt = time.Now()
+	return state
+}
@@ -1,6 +1,7 @@
 package modules
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net"
@@ -40,14 +41,16 @@ func isNoRouteOrDownError(err error) bool {
 	return syscallErr.Err == syscall.EHOSTUNREACH || syscallErr.Err == syscall.EHOSTDOWN
 }
 
-func Ping(addr string) (bool, error) {
+// Ping sends a single ICMP echo request to addr, and gives up after 500ms or
+// once ctx is done.
+func Ping(ctx context.Context, addr string) (bool, error) {
 	pinger, err := probing.NewPinger(addr)
 	if err != nil {
 		return false, fmt.Errorf("error creating new pinger: %w", err)
 	}
 	pinger.Count = 1
 	pinger.Timeout = 500 * time.Millisecond
-	err = pinger.Run()
+	err = pinger.RunWithContext(ctx)
 	if err != nil {
 		if isNoRouteOrDownError(err) {
 			return false, nil
@@ -59,7 +62,14 @@ func Ping(addr string) (bool, error) {
 }
 
 func Validate[T any](input map[string]interface{}, output *T) error {
-	err := mapstructure.Decode(input, output)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     output,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating the input decoder: %w", err)
+	}
+	err = decoder.Decode(input)
 	if err != nil {
 		return fmt.Errorf("input decoding error: %w", err)
 	}
@@ -0,0 +1,131 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	requestTimeout = 2 * time.Minute
+	// logoutTimeout bounds the logout, which is sent even when the context
+	// of the session is canceled or has expired
+	logoutTimeout = 10 * time.Second
+)
+
+type VmState struct {
+	PowerState      types.VirtualMachinePowerState
+	HeartbeatStatus types.ManagedEntityStatus
+}
+
+// VsphereClient drives a single virtual machine through the vSphere API of a
+// vCenter Server or a standalone ESXi host.
+type VsphereClient struct {
+	url  *url.URL
+	path string
+	moid string
+}
+
+func (c *VsphereClient) session(ctx context.Context, routine func(ctx context.Context, vm *object.VirtualMachine) error) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	// Ignore SSL certificate verification, vSphere uses self-signed certificates by default
+	client, err := govmomi.NewClient(ctx, c.url, true)
+	if err != nil {
+		return fmt.Errorf("error logging in to vSphere: %w", err)
+	}
+	// The session would otherwise stay open on the server until it expires
+	defer func() {
+		logoutCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), logoutTimeout)
+		defer cancel()
+		client.Logout(logoutCtx)
+	}()
+
+	var vm *object.VirtualMachine
+	if c.moid != "" {
+		vm = object.NewVirtualMachine(client.Client, types.ManagedObjectReference{Type: "VirtualMachine", Value: c.moid})
+	} else {
+		vm, err = find.NewFinder(client.Client, true).VirtualMachine(ctx, c.path)
+		if err != nil {
+			return fmt.Errorf("error finding the virtual machine %q: %w", c.path, err)
+		}
+	}
+	return routine(ctx, vm)
+}
+
+func (c *VsphereClient) State(ctx context.Context) (*VmState, error) {
+	var state VmState
+	err := c.session(ctx, func(ctx context.Context, vm *object.VirtualMachine) error {
+		var properties mo.VirtualMachine
+		err := vm.Properties(ctx, vm.Reference(), []string{"runtime.powerState", "guestHeartbeatStatus"}, &properties)
+		if err != nil {
+			return fmt.Errorf("error retrieving the virtual machine properties: %w", err)
+		}
+		state.PowerState = properties.Runtime.PowerState
+		state.HeartbeatStatus = properties.GuestHeartbeatStatus
+		return nil
+	})
+	return &state, err
+}
+
+func (c *VsphereClient) runTask(ctx context.Context, name string, start func(ctx context.Context, vm *object.VirtualMachine) (*object.Task, error)) error {
+	return c.session(ctx, func(ctx context.Context, vm *object.VirtualMachine) error {
+		task, err := start(ctx, vm)
+		if err != nil {
+			return fmt.Errorf("error starting the %s task: %w", name, err)
+		}
+		err = task.Wait(ctx)
+		if err != nil {
+			return fmt.Errorf("error during the %s task: %w", name, err)
+		}
+		return nil
+	})
+}
+
+func (c *VsphereClient) PowerOn(ctx context.Context) error {
+	return c.runTask(ctx, "power on", func(ctx context.Context, vm *object.VirtualMachine) (*object.Task, error) {
+		return vm.PowerOn(ctx)
+	})
+}
+
+func (c *VsphereClient) PowerOff(ctx context.Context) error {
+	return c.runTask(ctx, "power off", func(ctx context.Context, vm *object.VirtualMachine) (*object.Task, error) {
+		return vm.PowerOff(ctx)
+	})
+}
+
+func (c *VsphereClient) Reset(ctx context.Context) error {
+	return c.runTask(ctx, "reset", func(ctx context.Context, vm *object.VirtualMachine) (*object.Task, error) {
+		return vm.Reset(ctx)
+	})
+}
+
+// ShutdownGuest asks VMware Tools to shut down the guest operating system.
+// The request returns without waiting for the shutdown to complete.
+func (c *VsphereClient) ShutdownGuest(ctx context.Context) error {
+	return c.session(ctx, func(ctx context.Context, vm *object.VirtualMachine) error {
+		err := vm.ShutdownGuest(ctx)
+		if err != nil {
+			return fmt.Errorf("error shutting down the guest: %w", err)
+		}
+		return nil
+	})
+}
+
+func NewClient(baseUrl string, username string, password string, path string, moid string) (*VsphereClient, error) {
+	parsedUrl, err := soap.ParseURL(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+	parsedUrl.User = url.UserPassword(username, password)
+	return &VsphereClient{parsedUrl, path, moid}, nil
+}
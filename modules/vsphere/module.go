@@ -0,0 +1,119 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tr4cks/power/modules"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type VsphereModule struct {
+	modules.DefaultModule
+	Config VsphereConfig
+	Client *VsphereClient
+}
+
+type VsphereConfig struct {
+	Url      string `validate:"required"`
+	Username string `validate:"required"`
+	Password string `validate:"required"`
+	Vm       string `validate:"required_without=Moid,excluded_with=Moid"`
+	Moid     string `validate:"required_without=Vm"`
+	Hostname string
+	PowerOff string `mapstructure:"power-off" validate:"omitempty,oneof=graceful hard"`
+}
+
+func New() modules.Module {
+	return &VsphereModule{}
+}
+
+func (m *VsphereModule) Init(config map[string]interface{}) error {
+	err := modules.Validate(config, &m.Config)
+	if err != nil {
+		return fmt.Errorf("error validating %q module configuration: %w", "vsphere", err)
+	}
+	if m.Config.PowerOff == "" {
+		m.Config.PowerOff = "graceful"
+	}
+	m.Client, err = NewClient(m.Config.Url, m.Config.Username, m.Config.Password, m.Config.Vm, m.Config.Moid)
+	if err != nil {
+		return fmt.Errorf("error creating vsphere client: %w", err)
+	}
+	return nil
+}
+
+// State reports a suspended virtual machine as sleeping.
+func (m *VsphereModule) State(ctx context.Context) modules.State {
+	if m.Config.Hostname == "" {
+		vmState, err := m.Client.State(ctx)
+		if err != nil {
+			return modules.UnknownState(err)
+		}
+		// A yellow heartbeat is intermittent, the guest is still considered reachable
+		heartbeat := vmState.HeartbeatStatus == types.ManagedEntityStatusGreen || vmState.HeartbeatStatus == types.ManagedEntityStatusYellow
+		state := modules.NewState(modules.Result[bool]{Value: vmState.PowerState == types.VirtualMachinePowerStatePoweredOn}, modules.Result[bool]{Value: heartbeat})
+		if vmState.PowerState == types.VirtualMachinePowerStateSuspended {
+			state.Power = modules.PowerSleeping
+		}
+		return state
+	}
+
+	powerStateTask, powerStateChan := modules.MakeAsync(func() modules.Result[types.VirtualMachinePowerState] {
+		vmState, err := m.Client.State(ctx)
+		if err != nil {
+			return modules.Result[types.VirtualMachinePowerState]{Err: err}
+		}
+		return modules.Result[types.VirtualMachinePowerState]{Value: vmState.PowerState}
+	})
+
+	pingTask, pingChan := modules.MakeAsync(func() modules.Result[bool] {
+		value, err := modules.Ping(ctx, m.Config.Hostname)
+		return modules.Result[bool]{Value: value, Err: err}
+	})
+
+	go powerStateTask()
+	go pingTask()
+
+	powerState := <-powerStateChan
+	state := modules.NewState(modules.Result[bool]{Value: powerState.Value == types.VirtualMachinePowerStatePoweredOn, Err: powerState.Err}, <-pingChan)
+	if powerState.Value == types.VirtualMachinePowerStateSuspended {
+		state.Power = modules.PowerSleeping
+	}
+	return state
+}
+
+func (m *VsphereModule) PowerOn(ctx context.Context) error {
+	return m.Client.PowerOn(ctx)
+}
+
+func (m *VsphereModule) PowerOff(ctx context.Context) error {
+	if m.Config.PowerOff == "hard" {
+		return m.Client.PowerOff(ctx)
+	}
+	return m.Client.ShutdownGuest(ctx)
+}
+
+func (m *VsphereModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{
+		modules.CapabilityPowerOn,
+		modules.CapabilityPowerOff,
+		modules.CapabilityForceOff,
+		modules.CapabilityGracefulShutdown,
+		modules.CapabilityForceRestart,
+	}
+}
+
+func (m *VsphereModule) Perform(ctx context.Context, action modules.Capability) error {
+	switch action {
+	case modules.CapabilityForceOff:
+		return m.Client.PowerOff(ctx)
+	case modules.CapabilityGracefulShutdown:
+		return m.Client.ShutdownGuest(ctx)
+	case modules.CapabilityForceRestart:
+		return m.Client.Reset(ctx)
+	default:
+		return modules.ErrNotSupported
+	}
+}
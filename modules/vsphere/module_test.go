@@ -0,0 +1,284 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/moduletest"
+)
+
+const (
+	testUsername = "power@vsphere.local"
+	testPassword = "secret"
+	testVmPath   = "/DC0/vm/DC0_H0_VM0"
+)
+
+// newVcsim starts a simulated vCenter Server, with powered on virtual
+// machines.
+func newVcsim(t *testing.T) (*simulator.Model, *simulator.Server) {
+	t.Helper()
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	// The simulator only accepts these credentials
+	model.Service.Listen = &url.URL{User: url.UserPassword(testUsername, testPassword)}
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+	return model, server
+}
+
+// testVm returns the simulated virtual machine driven by the tests.
+func testVm(t *testing.T, model *simulator.Model) *simulator.VirtualMachine {
+	t.Helper()
+	for _, reference := range model.Map().All("VirtualMachine") {
+		vm := reference.(*simulator.VirtualMachine)
+		if vm.Name == "DC0_H0_VM0" {
+			return vm
+		}
+	}
+	t.Fatal("the simulator has no DC0_H0_VM0 virtual machine")
+	return nil
+}
+
+// configureVm changes the simulated virtual machine while the simulator is
+// running.
+func configureVm(model *simulator.Model, vm *simulator.VirtualMachine, configure func(vm *simulator.VirtualMachine)) {
+	model.Map().WithLock(model.Service.Context, vm, func() { configure(vm) })
+}
+
+func vmPowerState(model *simulator.Model, vm *simulator.VirtualMachine) types.VirtualMachinePowerState {
+	var powerState types.VirtualMachinePowerState
+	configureVm(model, vm, func(vm *simulator.VirtualMachine) { powerState = vm.Runtime.PowerState })
+	return powerState
+}
+
+// openSessions returns the number of sessions open on the simulator, besides
+// the one used to count them.
+func openSessions(t *testing.T, server *simulator.Server) int {
+	t.Helper()
+	ctx := moduletest.Context(t)
+	client, err := govmomi.NewClient(ctx, server.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Logout(ctx)
+	var manager mo.SessionManager
+	err = client.RetrieveOne(ctx, *client.ServiceContent.SessionManager, []string{"sessionList"}, &manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(manager.SessionList) - 1
+}
+
+func newTestModule(t *testing.T, server *simulator.Server, config map[string]interface{}) *VsphereModule {
+	t.Helper()
+	return newModule(t, server.URL.Host, config)
+}
+
+func newModule(t *testing.T, host string, config map[string]interface{}) *VsphereModule {
+	t.Helper()
+	module := moduletest.Init(t, New().(*VsphereModule), map[string]interface{}{
+		"url":      host,
+		"username": testUsername,
+		"password": testPassword,
+		"vm":       testVmPath,
+	}, config)
+	// The simulator only serves plain HTTP
+	module.Client.url.Scheme = "http"
+	return module
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name          string
+		powerState    types.VirtualMachinePowerState
+		heartbeat     types.ManagedEntityStatus
+		wantPower     modules.PowerState
+		wantReachable bool
+	}{
+		{"on", types.VirtualMachinePowerStatePoweredOn, types.ManagedEntityStatusGreen, modules.PowerOn, true},
+		{"intermittent heartbeat", types.VirtualMachinePowerStatePoweredOn, types.ManagedEntityStatusYellow, modules.PowerOn, true},
+		{"no heartbeat", types.VirtualMachinePowerStatePoweredOn, types.ManagedEntityStatusRed, modules.PowerOn, false},
+		{"no tools", types.VirtualMachinePowerStatePoweredOn, types.ManagedEntityStatusGray, modules.PowerOn, false},
+		{"off", types.VirtualMachinePowerStatePoweredOff, types.ManagedEntityStatusGray, modules.PowerOff, false},
+		{"suspended", types.VirtualMachinePowerStateSuspended, types.ManagedEntityStatusGray, modules.PowerSleeping, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, server := newVcsim(t)
+			vm := testVm(t, model)
+			configureVm(model, vm, func(vm *simulator.VirtualMachine) {
+				vm.Runtime.PowerState = tt.powerState
+				vm.GuestHeartbeatStatus = tt.heartbeat
+			})
+
+			// The virtual machine can be found by path or by identifier
+			for _, config := range []map[string]interface{}{nil, {"vm": nil, "moid": vm.Self.Value}} {
+				module := newTestModule(t, server, config)
+				state := module.State(moduletest.Context(t))
+				if state.Power != tt.wantPower {
+					t.Errorf("State().Power = %s, want %s (errors: %v)", state.Power, tt.wantPower, state.Errors)
+				}
+				if state.Reachable == nil || *state.Reachable != tt.wantReachable {
+					t.Errorf("State().Reachable = %v, want %t", state.Reachable, tt.wantReachable)
+				}
+			}
+		})
+	}
+}
+
+func TestPowerActions(t *testing.T) {
+	powerOn := func(m *VsphereModule, ctx context.Context) error { return m.PowerOn(ctx) }
+	powerOff := func(m *VsphereModule, ctx context.Context) error { return m.PowerOff(ctx) }
+	perform := func(action modules.Capability) func(m *VsphereModule, ctx context.Context) error {
+		return func(m *VsphereModule, ctx context.Context) error { return m.Perform(ctx, action) }
+	}
+
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		initial   types.VirtualMachinePowerState
+		call      func(m *VsphereModule, ctx context.Context) error
+		want      types.VirtualMachinePowerState
+		wantErr   string
+		wantErrIs error
+	}{
+		{"power on", nil, types.VirtualMachinePowerStatePoweredOff, powerOn, types.VirtualMachinePowerStatePoweredOn, "", nil},
+		{"power on while on", nil, types.VirtualMachinePowerStatePoweredOn, powerOn, types.VirtualMachinePowerStatePoweredOn, "error during the power on task", nil},
+		{"shutdown guest", nil, types.VirtualMachinePowerStatePoweredOn, powerOff, types.VirtualMachinePowerStatePoweredOff, "", nil},
+		{"shutdown guest while off", nil, types.VirtualMachinePowerStatePoweredOff, powerOff, types.VirtualMachinePowerStatePoweredOff, "error shutting down the guest", nil},
+		{"hard power off", map[string]interface{}{"power-off": "hard"}, types.VirtualMachinePowerStatePoweredOn, powerOff, types.VirtualMachinePowerStatePoweredOff, "", nil},
+		{"force off", nil, types.VirtualMachinePowerStatePoweredOn, perform(modules.CapabilityForceOff), types.VirtualMachinePowerStatePoweredOff, "", nil},
+		{"graceful shutdown", nil, types.VirtualMachinePowerStatePoweredOn, perform(modules.CapabilityGracefulShutdown), types.VirtualMachinePowerStatePoweredOff, "", nil},
+		{"force restart", nil, types.VirtualMachinePowerStatePoweredOn, perform(modules.CapabilityForceRestart), types.VirtualMachinePowerStatePoweredOn, "", nil},
+		{"force restart while off", nil, types.VirtualMachinePowerStatePoweredOff, perform(modules.CapabilityForceRestart), types.VirtualMachinePowerStatePoweredOff, "error during the reset task", nil},
+		{"suspend", nil, types.VirtualMachinePowerStatePoweredOn, perform(modules.CapabilitySuspend), types.VirtualMachinePowerStatePoweredOn, "", modules.ErrNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, server := newVcsim(t)
+			vm := testVm(t, model)
+			configureVm(model, vm, func(vm *simulator.VirtualMachine) { vm.Runtime.PowerState = tt.initial })
+			module := newTestModule(t, server, tt.config)
+
+			err := tt.call(module, moduletest.Context(t))
+			switch {
+			case tt.wantErrIs != nil:
+				if !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErrIs)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want an error containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("error = %v", err)
+			}
+
+			// The guest shutdown returns before the virtual machine is off
+			moduletest.WaitFor(t, "the "+string(tt.want)+" power state", func() bool { return vmPowerState(model, vm) == tt.want })
+			if sessions := openSessions(t, server); sessions != 0 {
+				t.Errorf("open sessions = %d, want none", sessions)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"unknown virtual machine", map[string]interface{}{"vm": "/DC0/vm/missing"}, `state: error finding the virtual machine "/DC0/vm/missing"`},
+		{"unknown identifier", map[string]interface{}{"vm": nil, "moid": "vm-404"}, "state: error retrieving the virtual machine properties"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, server := newVcsim(t)
+			module := newTestModule(t, server, tt.config)
+
+			state := module.State(moduletest.Context(t))
+			if state.Power != modules.PowerUnknown || len(state.Errors) != 1 || !strings.HasPrefix(state.Errors[0], tt.wantErr) {
+				t.Errorf("State() = %s %v, want an unknown state with %q", state.Power, state.Errors, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, server := newVcsim(t)
+		module := newTestModule(t, server, map[string]interface{}{"password": "invalid"})
+
+		err := module.PowerOn(moduletest.Context(t))
+		if err == nil || !strings.HasPrefix(err.Error(), "error logging in to vSphere") {
+			t.Errorf("PowerOn() error = %v, want a login error", err)
+		}
+	})
+
+	t.Run("unreachable server", func(t *testing.T) {
+		module := newModule(t, moduletest.ClosedAddress(t).String(), nil)
+
+		state := module.State(moduletest.Context(t))
+		if state.Power != modules.PowerUnknown || len(state.Errors) != 1 || !strings.HasPrefix(state.Errors[0], "state: error logging in to vSphere") {
+			t.Errorf("State() = %s %v, want an unknown state with a login error", state.Power, state.Errors)
+		}
+	})
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name string
+		// routine runs in the session, with the cancellation of its context
+		routine func(ctx context.Context, cancel context.CancelFunc) error
+	}{
+		{
+			name:    "succeeded",
+			routine: func(ctx context.Context, cancel context.CancelFunc) error { return nil },
+		},
+		{
+			name:    "failed",
+			routine: func(ctx context.Context, cancel context.CancelFunc) error { return errors.New("failed") },
+		},
+		{
+			// The session is still closed when the request is canceled or
+			// times out
+			name: "canceled",
+			routine: func(ctx context.Context, cancel context.CancelFunc) error {
+				cancel()
+				return ctx.Err()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, server := newVcsim(t)
+			module := newTestModule(t, server, nil)
+
+			ctx, cancel := context.WithCancel(moduletest.Context(t))
+			defer cancel()
+			module.Client.session(ctx, func(ctx context.Context, vm *object.VirtualMachine) error {
+				if sessions := openSessions(t, server); sessions != 1 {
+					t.Errorf("open sessions = %d, want the one of the module", sessions)
+				}
+				return tt.routine(ctx, cancel)
+			})
+			if sessions := openSessions(t, server); sessions != 0 {
+				t.Errorf("open sessions = %d, want none", sessions)
+			}
+		})
+	}
+}
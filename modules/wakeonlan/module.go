@@ -1,21 +1,34 @@
 package wakeonlan
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/agent"
+	"github.com/tr4cks/power/modules/ssh"
 
 	"github.com/linde12/gowol"
 )
 
 type WakeOnLanModule struct {
 	modules.DefaultModule
-	Config WakeOnLanConfig
+	Config      WakeOnLanConfig
+	SshClient   *ssh.SshClient
+	AgentClient *agent.AgentClient
+
+	// asleep is set once the server has been put to sleep, until it is
+	// switched on or answers again
+	asleep atomic.Bool
 }
 
 type WakeOnLanConfig struct {
 	Hostname string `validate:"required"`
 	Mac      string `validate:"required"`
+	Ssh      *ssh.ClientConfig
+	Agent    *agent.ClientConfig
 }
 
 func New() modules.Module {
@@ -27,15 +40,77 @@ func (m *WakeOnLanModule) Init(config map[string]interface{}) error {
 	if err != nil {
 		return fmt.Errorf("error validating %q module configuration: %w", "wol", err)
 	}
+	if m.Config.Ssh != nil {
+		m.SshClient, err = ssh.NewClient(m.Config.Hostname, m.Config.Ssh)
+		if err != nil {
+			return fmt.Errorf("error creating ssh client: %w", err)
+		}
+	}
+	if m.Config.Agent != nil {
+		m.AgentClient, err = agent.NewClient(m.Config.Agent)
+		if err != nil {
+			return fmt.Errorf("error creating agent client: %w", err)
+		}
+	}
 	return nil
 }
 
-func (m *WakeOnLanModule) State() (modules.Result[bool], modules.Result[bool]) {
-	ping, err := modules.Ping(m.Config.Hostname)
-	return modules.Result[bool]{ping, err}, modules.Result[bool]{ping, err}
+// State reports the server as sleeping once it has been put to sleep, until
+// it is switched on or answers again. With the agent, the boot time, uptime,
+// load average and memory of the server are reported as attributes.
+func (m *WakeOnLanModule) State(ctx context.Context) modules.State {
+	var state modules.State
+	if m.AgentClient != nil {
+		// An answering agent means that the operating system is up, whereas
+		// a server answering only to ping is probably still booting or
+		// shutting down.
+		status, err := m.AgentClient.Status(ctx)
+		if err == nil {
+			m.asleep.Store(false)
+			state = modules.NewState(modules.Result[bool]{Value: true}, modules.Result[bool]{Value: true})
+			setStatusAttributes(&state, status)
+			return state
+		}
+		ping, err := modules.Ping(ctx, m.Config.Hostname)
+		state = modules.NewState(modules.Result[bool]{Value: ping, Err: err}, modules.Result[bool]{Value: false})
+	} else {
+		ping, err := modules.Ping(ctx, m.Config.Hostname)
+		state = modules.NewState(modules.Result[bool]{Value: ping, Err: err}, modules.Result[bool]{Value: ping, Err: err})
+	}
+
+	switch {
+	case state.Power == modules.PowerOn:
+		m.asleep.Store(false)
+	case state.Power == modules.PowerOff && m.asleep.Load():
+		state.Power = modules.PowerSleeping
+	}
+	return state
+}
+
+func setStatusAttributes(state *modules.State, status *agent.Status) {
+	if status.BootTime != nil {
+		state.SetAttribute("boot_time", *status.BootTime)
+		state.SetAttribute("uptime", int64(time.Since(*status.BootTime).Seconds()))
+	}
+	if len(status.Load) > 0 {
+		state.SetAttribute("load", status.Load)
+	}
+	if status.Memory != nil {
+		state.SetAttribute("memory", *status.Memory)
+	}
+}
+
+// Capabilities doesn't report the reboot of the agent, which restarts the
+// operating system gracefully instead of performing the force-restart action.
+func (m *WakeOnLanModule) Capabilities() modules.Capabilities {
+	if m.AgentClient != nil || m.SshClient != nil {
+		return modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate}
+	}
+	return modules.Capabilities{modules.CapabilityPowerOn}
 }
 
-func (m *WakeOnLanModule) PowerOn() error {
+func (m *WakeOnLanModule) PowerOn(ctx context.Context) error {
+	m.asleep.Store(false)
 	packet, err := gowol.NewMagicPacket(m.Config.Mac)
 	if err != nil {
 		return fmt.Errorf("error creating the magic packet: %w", err)
@@ -46,3 +121,38 @@ func (m *WakeOnLanModule) PowerOn() error {
 	}
 	return nil
 }
+
+func (m *WakeOnLanModule) PowerOff(ctx context.Context) error {
+	if m.AgentClient != nil {
+		return m.AgentClient.Do(ctx, m.Config.Agent.PowerOff)
+	}
+	if m.SshClient != nil {
+		return m.SshClient.PowerOff(ctx)
+	}
+	return modules.ErrNotSupported
+}
+
+func (m *WakeOnLanModule) sleep(ctx context.Context, action agent.Action, sshSleep func() error) error {
+	var err error
+	switch {
+	case m.AgentClient != nil:
+		err = m.AgentClient.Do(ctx, action)
+	case m.SshClient != nil:
+		err = sshSleep()
+	default:
+		return modules.ErrNotSupported
+	}
+	if err != nil {
+		return err
+	}
+	m.asleep.Store(true)
+	return nil
+}
+
+func (m *WakeOnLanModule) Suspend(ctx context.Context) error {
+	return m.sleep(ctx, agent.ActionSuspend, func() error { return m.SshClient.Suspend(ctx) })
+}
+
+func (m *WakeOnLanModule) Hibernate(ctx context.Context) error {
+	return m.sleep(ctx, agent.ActionHibernate, func() error { return m.SshClient.Hibernate(ctx) })
+}
@@ -0,0 +1,332 @@
+package wakeonlan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/agent"
+	"github.com/tr4cks/power/modules/moduletest"
+	"github.com/tr4cks/power/modules/ssh"
+)
+
+const testSecret = "shared_secret"
+
+// agentStub is a local stand-in for the `power agent`, which authenticates
+// the requests the same way.
+type agentStub struct {
+	server *httptest.Server
+	nonces *agent.NonceCache
+
+	mutex  sync.Mutex
+	secret string
+	status agent.Status
+	// available reports whether the agent answers the status queries
+	available bool
+	// malformed truncates the JSON status
+	malformed bool
+	actions   moduletest.Recorder[agent.Action]
+	// requests records the received requests, so that they can be replayed
+	requests moduletest.Recorder[*http.Request]
+}
+
+func newAgentStub(t *testing.T) *agentStub {
+	t.Helper()
+	bootTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	stub := &agentStub{
+		nonces: agent.NewNonceCache(),
+		secret: testSecret,
+		status: agent.Status{
+			Hostname: "server",
+			Os:       "linux",
+			Arch:     "amd64",
+			BootTime: &bootTime,
+			Load:     []float64{0.5, 0.25, 0.125},
+			Memory:   &agent.Memory{Total: 8 << 30, Available: 6 << 30},
+			Actions:  agent.Actions,
+		},
+		available: true,
+	}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// configure changes the agent while it is running.
+func (s *agentStub) configure(configure func(s *agentStub)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configure(s)
+}
+
+func (s *agentStub) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	nonce := r.Header.Get(agent.NonceHeader)
+	err = agent.Verify(s.secret, r.Method, r.URL.Path, r.Header.Get(agent.TimestampHeader), nonce, body, r.Header.Get(agent.SignatureHeader))
+	if err != nil || !s.nonces.Use(nonce, time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.requests.Record(r)
+
+	if r.Method == http.MethodGet && r.URL.Path == "/api/status" {
+		switch {
+		case !s.available:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case s.malformed:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"hostname": "serv`))
+		default:
+			moduletest.WriteJSON(w, http.StatusOK, s.status)
+		}
+		return
+	}
+	action := agent.Action(strings.TrimPrefix(r.URL.Path, "/api/"))
+	if r.Method != http.MethodPost || !slices.Contains(agent.Actions, action) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.actions.Record(action)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func newTestModule(t *testing.T, stub *agentStub, config map[string]interface{}) *WakeOnLanModule {
+	t.Helper()
+	return newModule(t, stub.server.URL, config)
+}
+
+// newModule returns a module relying on the agent answering at url.
+func newModule(t *testing.T, url string, config map[string]interface{}) *WakeOnLanModule {
+	t.Helper()
+	return moduletest.Init(t, New().(*WakeOnLanModule), map[string]interface{}{
+		"hostname": "127.0.0.1",
+		"mac":      "42:42:42:42:42:42",
+		"agent":    map[string]interface{}{"url": url, "secret": testSecret},
+	}, config)
+}
+
+func TestStateWithAgent(t *testing.T) {
+	stub := newAgentStub(t)
+	module := newTestModule(t, stub, nil)
+
+	state := module.State(moduletest.Context(t))
+	if state.Power != modules.PowerOn || state.Reachable == nil || !*state.Reachable || len(state.Errors) != 0 {
+		t.Fatalf("State() = %s %v %v, want a reachable server switched on", state.Power, state.Reachable, state.Errors)
+	}
+
+	bootTime, ok := state.Attributes["boot_time"].(time.Time)
+	if !ok || !bootTime.Equal(*stub.status.BootTime) {
+		t.Errorf("boot_time = %v, want %v", state.Attributes["boot_time"], *stub.status.BootTime)
+	}
+	uptime, ok := state.Attributes["uptime"].(int64)
+	if !ok || uptime < 3600 || uptime > 3660 {
+		t.Errorf("uptime = %v, want about 3600 seconds", state.Attributes["uptime"])
+	}
+	if load, ok := state.Attributes["load"].([]float64); !ok || !slices.Equal(load, stub.status.Load) {
+		t.Errorf("load = %v, want %v", state.Attributes["load"], stub.status.Load)
+	}
+	if memory, ok := state.Attributes["memory"].(agent.Memory); !ok || memory != *stub.status.Memory {
+		t.Errorf("memory = %v, want %v", state.Attributes["memory"], *stub.status.Memory)
+	}
+
+	t.Run("partial status", func(t *testing.T) {
+		stub.configure(func(s *agentStub) {
+			s.status.BootTime = nil
+			s.status.Load = nil
+			s.status.Memory = nil
+		})
+		state := module.State(moduletest.Context(t))
+		if state.Power != modules.PowerOn || len(state.Attributes) != 0 {
+			t.Errorf("State() = %s %v, want a server switched on without attributes", state.Power, state.Attributes)
+		}
+	})
+
+	t.Run("agent not answering", func(t *testing.T) {
+		stub.configure(func(s *agentStub) { s.available = false })
+		state := module.State(moduletest.Context(t))
+		// The server is reachable only through the agent
+		if state.Reachable == nil || *state.Reachable || len(state.Attributes) != 0 {
+			t.Errorf("State() = %v %v, want an unreachable server without attributes", state.Reachable, state.Attributes)
+		}
+	})
+}
+
+func TestActions(t *testing.T) {
+	powerOff := func(m *WakeOnLanModule, ctx context.Context) error { return m.PowerOff(ctx) }
+	suspend := func(m *WakeOnLanModule, ctx context.Context) error { return m.Suspend(ctx) }
+	hibernate := func(m *WakeOnLanModule, ctx context.Context) error { return m.Hibernate(ctx) }
+	perform := func(action modules.Capability) func(m *WakeOnLanModule, ctx context.Context) error {
+		return func(m *WakeOnLanModule, ctx context.Context) error { return modules.Perform(ctx, m, action) }
+	}
+
+	tests := []struct {
+		name       string
+		powerOff   string
+		call       func(m *WakeOnLanModule, ctx context.Context) error
+		want       []agent.Action
+		wantAsleep bool
+		wantErrIs  error
+	}{
+		{"power off", "", powerOff, []agent.Action{agent.ActionShutdown}, false, nil},
+		{"power off by suspending", "suspend", powerOff, []agent.Action{agent.ActionSuspend}, false, nil},
+		{"suspend", "", suspend, []agent.Action{agent.ActionSuspend}, true, nil},
+		{"hibernate", "", hibernate, []agent.Action{agent.ActionHibernate}, true, nil},
+		// The reboot of the agent is graceful, unlike the force-restart action
+		{"force restart", "", perform(modules.CapabilityForceRestart), nil, false, modules.ErrNotSupported},
+		{"nmi", "", perform(modules.CapabilityNmi), nil, false, modules.ErrNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newAgentStub(t)
+			config := map[string]interface{}{}
+			if tt.powerOff != "" {
+				config["agent"] = map[string]interface{}{"url": stub.server.URL, "secret": testSecret, "power-off": tt.powerOff}
+			}
+			module := newTestModule(t, stub, config)
+
+			err := tt.call(module, moduletest.Context(t))
+			if !errors.Is(err, tt.wantErrIs) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErrIs)
+			}
+			if actions := stub.actions.Received(); !slices.Equal(actions, tt.want) {
+				t.Errorf("actions = %v, want %v", actions, tt.want)
+			}
+			if asleep := module.asleep.Load(); asleep != tt.wantAsleep {
+				t.Errorf("asleep = %t, want %t", asleep, tt.wantAsleep)
+			}
+		})
+	}
+}
+
+func TestReplayedRequest(t *testing.T) {
+	stub := newAgentStub(t)
+	module := newTestModule(t, stub, nil)
+
+	if err := module.Suspend(moduletest.Context(t)); err != nil {
+		t.Fatalf("Suspend() error = %v", err)
+	}
+	if err := module.Suspend(moduletest.Context(t)); err != nil {
+		t.Fatalf("Suspend() error = %v", err)
+	}
+
+	// Each request has its own nonce, so a captured request can't be sent
+	// again within the allowed clock skew
+	requests := stub.requests.Received()
+	if len(requests) != 2 || requests[0].Header.Get(agent.NonceHeader) == requests[1].Header.Get(agent.NonceHeader) {
+		t.Fatalf("received %d requests, want 2 with distinct nonces", len(requests))
+	}
+	replay, err := http.NewRequestWithContext(moduletest.Context(t), requests[0].Method, stub.server.URL+requests[0].URL.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Header = requests[0].Header.Clone()
+	resp, err := http.DefaultClient.Do(replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("replayed request status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if actions := stub.actions.Received(); len(actions) != 2 {
+		t.Errorf("actions = %v, want 2 suspensions", actions)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	stub := newAgentStub(t)
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		ssh    bool
+		want   modules.Capabilities
+	}{
+		{"wake-on-lan only", map[string]interface{}{"agent": nil}, false, modules.Capabilities{modules.CapabilityPowerOn}},
+		{
+			"ssh",
+			map[string]interface{}{"agent": nil},
+			true,
+			modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate},
+		},
+		{
+			"agent",
+			nil,
+			false,
+			modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilitySuspend, modules.CapabilityHibernate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := newTestModule(t, stub, tt.config)
+			if tt.ssh {
+				// The client isn't used, sparing the need for a private key
+				module.SshClient = &ssh.SshClient{}
+			}
+			if got := module.Capabilities(); !slices.Equal(got, tt.want) {
+				t.Errorf("Capabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgentSecretChanged(t *testing.T) {
+	stub := newAgentStub(t)
+	module := newTestModule(t, stub, nil)
+	stub.configure(func(s *agentStub) { s.secret = "rotated_secret" })
+
+	// The server answering only to ping isn't reported as started
+	state := module.State(moduletest.Context(t))
+	if state.Power == modules.PowerOn || state.Reachable == nil || *state.Reachable || len(state.Attributes) != 0 {
+		t.Errorf("State() = %s %v %v, want an unreachable server without attributes", state.Power, state.Reachable, state.Attributes)
+	}
+	if err := module.PowerOff(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "StatusCode: 401") {
+		t.Errorf("PowerOff() error = %v, want a 401 error", err)
+	}
+	if actions := stub.actions.Received(); len(actions) != 0 {
+		t.Errorf("actions = %v, want none", actions)
+	}
+}
+
+func TestMalformedStatus(t *testing.T) {
+	stub := newAgentStub(t)
+	stub.configure(func(s *agentStub) { s.malformed = true })
+	module := newTestModule(t, stub, nil)
+
+	state := module.State(moduletest.Context(t))
+	if state.Power == modules.PowerOn || state.Reachable == nil || *state.Reachable || len(state.Attributes) != 0 {
+		t.Errorf("State() = %s %v %v, want an unreachable server without attributes", state.Power, state.Reachable, state.Attributes)
+	}
+}
+
+func TestUnreachableAgent(t *testing.T) {
+	module := newModule(t, "http://"+moduletest.ClosedAddress(t).String(), nil)
+
+	state := module.State(moduletest.Context(t))
+	if state.Power == modules.PowerOn || state.Reachable == nil || *state.Reachable {
+		t.Errorf("State() = %s %v, want an unreachable server", state.Power, state.Reachable)
+	}
+	for name, action := range map[string]func(ctx context.Context) error{"PowerOff": module.PowerOff, "Suspend": module.Suspend} {
+		if err := action(moduletest.Context(t)); err == nil || !strings.Contains(err.Error(), "error sending the request") {
+			t.Errorf("%s() error = %v, want a request error", name, err)
+		}
+	}
+	if module.asleep.Load() {
+		t.Error("asleep = true, want false after a failed suspension")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tr4cks/power/modules"
+	"golang.org/x/sync/singleflight"
+)
+
+// settleDuration bounds the fast polling following an action, for the modules
+// which don't report the transitions.
+const settleDuration = 3 * time.Minute
+
+// StatePoller refreshes the state of a target in the background, so that the
+// requests are answered with the cached state instead of querying the module
+// every time. The concurrent retrievals of the state are collapsed into a
+// single module call.
+type StatePoller struct {
+	ctx     context.Context
+	module  modules.Module
+	config  *PollingConfig
+	timeout time.Duration
+	logger  zerolog.Logger
+
+	group singleflight.Group
+	wake  chan struct{}
+
+	mutex sync.Mutex
+	state *modules.State
+	// generation is incremented by each action. The state retrieved before
+	// the last action is stale, and is refreshed before being served again.
+	generation      uint64
+	stateGeneration uint64
+	// actionPower is the power state of the server when the last action has
+	// been performed, at actionTime
+	actionPower modules.PowerState
+	actionTime  time.Time
+	// updated is closed, then replaced, each time a state is retrieved
+	updated chan struct{}
+}
+
+// NewStatePoller creates the poller of the state of target. The retrievals of
+// the state are bounded by timeout, and canceled along with ctx.
+func NewStatePoller(ctx context.Context, target *Target, config *PollingConfig, timeout time.Duration, logger *zerolog.Logger) *StatePoller {
+	return &StatePoller{
+		ctx:     ctx,
+		module:  target.Module,
+		config:  config,
+		timeout: timeout,
+		logger:  logger.With().Str("server", target.Name).Logger(),
+		wake:    make(chan struct{}, 1),
+		updated: make(chan struct{}),
+	}
+}
+
+// Run refreshes the state until the context of the poller is canceled.
+func (p *StatePoller) Run() {
+	for {
+		p.mutex.Lock()
+		generation := p.generation
+		p.mutex.Unlock()
+		<-p.read(generation)
+
+		p.mutex.Lock()
+		interval := p.interval()
+		p.mutex.Unlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-p.wake:
+			timer.Stop()
+		case <-p.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// read retrieves the state, or waits for the retrieval already running for
+// the same generation.
+func (p *StatePoller) read(generation uint64) <-chan singleflight.Result {
+	return p.group.DoChan(strconv.FormatUint(generation, 10), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+		defer cancel()
+		state := modules.ReadState(ctx, p.module)
+
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if p.state != nil && generation < p.stateGeneration {
+			return state, nil
+		}
+		if p.state == nil || !slices.Equal(state.Errors, p.state.Errors) {
+			logStateErrors(&p.logger, &state)
+		}
+		p.state = &state
+		p.stateGeneration = generation
+		close(p.updated)
+		p.updated = make(chan struct{})
+		return state, nil
+	})
+}
+
+// interval returns the delay before the next refresh. The mutex must be held.
+func (p *StatePoller) interval() time.Duration {
+	if p.state == nil {
+		return p.config.TransitionInterval
+	}
+	if p.state.Power.Transitioning() {
+		return p.config.TransitionInterval
+	}
+	if time.Since(p.actionTime) < settleDuration {
+		// The server hasn't left its state yet, or is still booting
+		booting := p.state.Power == modules.PowerOn && p.state.Reachable != nil && !*p.state.Reachable
+		if p.state.Power == p.actionPower || booting {
+			return p.config.TransitionInterval
+		}
+	}
+	return p.config.Interval
+}
+
+// fresh reports whether the cached state can be served. It is stale once an
+// action has been performed, or when the polling stalls. The mutex must be
+// held.
+func (p *StatePoller) fresh() bool {
+	if p.state == nil || p.stateGeneration != p.generation {
+		return false
+	}
+	return time.Since(p.state.CheckedAt) <= p.interval()+p.timeout
+}
+
+// State returns the cached state, or retrieves it when there isn't any fresh
+// one. It gives up once ctx is done.
+func (p *StatePoller) State(ctx context.Context) modules.State {
+	p.mutex.Lock()
+	if p.fresh() {
+		state := *p.state
+		p.mutex.Unlock()
+		return state
+	}
+	generation := p.generation
+	p.mutex.Unlock()
+
+	select {
+	case result := <-p.read(generation):
+		return result.Val.(modules.State)
+	case <-ctx.Done():
+		return modules.UnknownState(ctx.Err())
+	}
+}
+
+// Updated returns a channel closed once a newer state has been retrieved.
+func (p *StatePoller) Updated() <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.updated
+}
+
+// Refresh retrieves the state again, even when the cached one is fresh.
+func (p *StatePoller) Refresh(ctx context.Context) modules.State {
+	p.mutex.Lock()
+	p.generation++
+	p.mutex.Unlock()
+	return p.State(ctx)
+}
+
+// Invalidate discards the cached state once an action has been performed.
+// The state is then polled at the transition interval until the power state
+// changes and the server is reachable when switched on.
+func (p *StatePoller) Invalidate() {
+	p.mutex.Lock()
+	p.generation++
+	if p.state != nil {
+		p.actionPower = p.state.Power
+	}
+	p.actionTime = time.Now()
+	p.mutex.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stateAge returns the time elapsed since state has been retrieved.
+func stateAge(state *modules.State) time.Duration {
+	if state.CheckedAt.IsZero() {
+		return 0
+	}
+	return time.Since(state.CheckedAt)
+}
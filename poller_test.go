@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tr4cks/power/modules"
+)
+
+// testModule is a module whose state is set by the tests, and whose actions
+// don't change it. Its state retrievals and actions can be held until
+// released.
+type testModule struct {
+	modules.DefaultModule
+	capabilities modules.Capabilities
+
+	mutex      sync.Mutex
+	power      modules.PowerState
+	reachable  bool
+	stateCalls int
+	actions    []modules.Capability
+	// stateGate and actionGate, when set, hold the state retrievals and the
+	// actions until they are closed
+	stateGate  chan struct{}
+	actionGate chan struct{}
+}
+
+func newTestModule(power modules.PowerState, reachable bool) *testModule {
+	return &testModule{power: power, reachable: reachable}
+}
+
+// configure changes the module while it is used.
+func (m *testModule) configure(configure func(m *testModule)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	configure(m)
+}
+
+func (m *testModule) receivedStateCalls() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stateCalls
+}
+
+func (m *testModule) receivedActions() []modules.Capability {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return slices.Clone(m.actions)
+}
+
+func (m *testModule) Capabilities() modules.Capabilities {
+	if m.capabilities != nil {
+		return m.capabilities
+	}
+	return append(modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff}, modules.ExtendedActions...)
+}
+
+func (m *testModule) State(ctx context.Context) modules.State {
+	m.mutex.Lock()
+	m.stateCalls++
+	gate := m.stateGate
+	state := modules.State{Power: m.power}
+	state.SetReachable(m.reachable)
+	m.mutex.Unlock()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return modules.UnknownState(ctx.Err())
+		}
+	}
+	return state
+}
+
+func (m *testModule) act(ctx context.Context, capability modules.Capability) error {
+	m.mutex.Lock()
+	m.actions = append(m.actions, capability)
+	gate := m.actionGate
+	m.mutex.Unlock()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (m *testModule) PowerOn(ctx context.Context) error {
+	return m.act(ctx, modules.CapabilityPowerOn)
+}
+
+func (m *testModule) PowerOff(ctx context.Context) error {
+	return m.act(ctx, modules.CapabilityPowerOff)
+}
+
+func (m *testModule) Perform(ctx context.Context, action modules.Capability) error {
+	return m.act(ctx, action)
+}
+
+// waitUntil fails the test when condition isn't met within a few seconds.
+func waitUntil(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", description)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newTestPoller creates the poller of module, without running it, so that the
+// state is only retrieved on demand.
+func newTestPoller(t *testing.T, module modules.Module, polling *PollingConfig) *StatePoller {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	logger := zerolog.Nop()
+	return NewStatePoller(ctx, &Target{Name: "test", Module: module}, polling, 30*time.Second, &logger)
+}
+
+func TestPollerCollapsesReads(t *testing.T) {
+	gate := make(chan struct{})
+	module := newTestModule(modules.PowerOn, true)
+	module.stateGate = gate
+	poller := newTestPoller(t, module, &PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour})
+
+	var wg sync.WaitGroup
+	states := make([]modules.State, 10)
+	for i := range states {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			states[i] = poller.State(testContext(t))
+		}()
+	}
+	waitUntil(t, "the state is retrieved", func() bool { return module.receivedStateCalls() == 1 })
+	// Let the other requests join the retrieval running
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	if calls := module.receivedStateCalls(); calls != 1 {
+		t.Errorf("the module has been called %d times, want once", calls)
+	}
+	for i, state := range states {
+		if state.Power != modules.PowerOn || state.CheckedAt.IsZero() {
+			t.Errorf("request %d: state = %s checked at %s, want %s", i, state.Power, state.CheckedAt, modules.PowerOn)
+		}
+	}
+}
+
+func TestPollerCache(t *testing.T) {
+	module := newTestModule(modules.PowerOff, false)
+	poller := newTestPoller(t, module, &PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour})
+
+	tests := []struct {
+		name      string
+		read      func(ctx context.Context) modules.State
+		wantCalls int
+		// wantUpdated tells whether the module has been called, so that
+		// Updated() is closed
+		wantUpdated bool
+	}{
+		{"first read", poller.State, 1, true},
+		{"fresh state", poller.State, 1, false},
+		{"refreshed", poller.Refresh, 2, true},
+		{"fresh after refresh", poller.State, 2, false},
+		{"invalidated", func(ctx context.Context) modules.State {
+			poller.Invalidate()
+			return poller.State(ctx)
+		}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := poller.Updated()
+			tt.read(testContext(t))
+			if calls := module.receivedStateCalls(); calls != tt.wantCalls {
+				t.Errorf("the module has been called %d times, want %d", calls, tt.wantCalls)
+			}
+			select {
+			case <-updated:
+				if !tt.wantUpdated {
+					t.Error("Updated() closed without retrieving the state")
+				}
+			default:
+				if tt.wantUpdated {
+					t.Error("Updated() not closed once the state has been retrieved")
+				}
+			}
+		})
+	}
+}
+
+func TestPollerInvalidateDuringRead(t *testing.T) {
+	tests := []struct {
+		name string
+		// newerFirst tells whether the read started after the action ends
+		// before the read started before it
+		newerFirst bool
+	}{
+		{"older read ends first", false},
+		{"newer read ends first", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			olderGate, newerGate := make(chan struct{}), make(chan struct{})
+			module := newTestModule(modules.PowerOff, false)
+			module.stateGate = olderGate
+			poller := newTestPoller(t, module, &PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour})
+
+			older := make(chan modules.State)
+			go func() { older <- poller.State(testContext(t)) }()
+			waitUntil(t, "the older state is retrieved", func() bool { return module.receivedStateCalls() == 1 })
+
+			// The server is switched on while the state is retrieved
+			module.configure(func(m *testModule) {
+				m.power = modules.PowerOn
+				m.reachable = true
+				m.stateGate = newerGate
+			})
+			poller.Invalidate()
+			newer := make(chan modules.State)
+			go func() { newer <- poller.State(testContext(t)) }()
+			// The read of the new generation doesn't join the older one
+			waitUntil(t, "the newer state is retrieved", func() bool { return module.receivedStateCalls() == 2 })
+
+			var olderState, newerState modules.State
+			if tt.newerFirst {
+				close(newerGate)
+				newerState = <-newer
+				close(olderGate)
+				olderState = <-older
+			} else {
+				close(olderGate)
+				olderState = <-older
+				close(newerGate)
+				newerState = <-newer
+			}
+			if olderState.Power != modules.PowerOff || newerState.Power != modules.PowerOn {
+				t.Errorf("states = %s and %s, want %s and %s", olderState.Power, newerState.Power, modules.PowerOff, modules.PowerOn)
+			}
+
+			// The state retrieved before the action isn't served anymore
+			if state := poller.State(testContext(t)); state.Power != modules.PowerOn {
+				t.Errorf("cached state = %s, want %s", state.Power, modules.PowerOn)
+			}
+			if calls := module.receivedStateCalls(); calls != 2 {
+				t.Errorf("the module has been called %d times, want 2", calls)
+			}
+		})
+	}
+}
+
+func TestPollerInterval(t *testing.T) {
+	polling := PollingConfig{Interval: time.Minute, TransitionInterval: time.Second}
+	reachable, unreachable := true, false
+
+	tests := []struct {
+		name        string
+		state       *modules.State
+		actionPower modules.PowerState
+		actionAge   time.Duration
+		want        time.Duration
+	}{
+		{"no state", nil, "", time.Hour, time.Second},
+		{"switched off", &modules.State{Power: modules.PowerOff}, "", time.Hour, time.Minute},
+		{"powering on", &modules.State{Power: modules.PoweringOn}, "", time.Hour, time.Second},
+		{"powering off", &modules.State{Power: modules.PoweringOff}, "", time.Hour, time.Second},
+		{"state unchanged after an action", &modules.State{Power: modules.PowerOff}, modules.PowerOff, time.Second, time.Second},
+		{"state changed after an action", &modules.State{Power: modules.PowerOff}, modules.PowerOn, time.Second, time.Minute},
+		{"booting after an action", &modules.State{Power: modules.PowerOn, Reachable: &unreachable}, modules.PowerOff, time.Second, time.Second},
+		{"booted after an action", &modules.State{Power: modules.PowerOn, Reachable: &reachable}, modules.PowerOff, time.Second, time.Minute},
+		{"settled after an action", &modules.State{Power: modules.PowerOff}, modules.PowerOff, settleDuration + time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poller := newTestPoller(t, &testModule{}, &polling)
+			poller.state = tt.state
+			poller.actionPower = tt.actionPower
+			poller.actionTime = time.Now().Add(-tt.actionAge)
+			if got := poller.interval(); got != tt.want {
+				t.Errorf("interval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tr4cks/power/modules"
+)
+
+type ReconcileStatus string
+
+const (
+	// ReconcilePending is the status of a reconciliation still running
+	ReconcilePending  ReconcileStatus = "pending"
+	ReconcileReached  ReconcileStatus = "reached"
+	ReconcileFailed   ReconcileStatus = "failed"
+	ReconcileCanceled ReconcileStatus = "canceled"
+)
+
+// DesiredState is the progress of a reconciliation, as rendered by the
+// desired state API.
+type DesiredState struct {
+	Power  modules.PowerState `json:"power"`
+	Status ReconcileStatus    `json:"status"`
+	// Attempts is the number of times the action has been performed
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	SetAt     time.Time `json:"set_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Reconciliation brings a server to a desired power state, by performing the
+// action again until the server reaches the state, or until it gives up.
+type Reconciliation struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex sync.Mutex
+	state DesiredState
+}
+
+// Done is closed once the reconciliation is over.
+func (r *Reconciliation) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *Reconciliation) State() DesiredState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
+}
+
+func (r *Reconciliation) update(update func(state *DesiredState)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	update(&r.state)
+	r.state.UpdatedAt = time.Now()
+}
+
+// The action is performed again at most every minActionInterval, the
+// interval doubling after each attempt up to maxActionInterval.
+const (
+	minActionInterval = 10 * time.Second
+	maxActionInterval = time.Minute
+)
+
+// Reconciler runs the reconciliation of the desired state of a target. A
+// single reconciliation runs at a time.
+type Reconciler struct {
+	ctx      context.Context
+	target   *Target
+	timeouts *TimeoutsConfig
+	polling  *PollingConfig
+	logger   zerolog.Logger
+
+	mutex   sync.Mutex
+	current *Reconciliation
+}
+
+// NewReconciler creates the reconciler of target. The reconciliations are
+// canceled along with ctx.
+func NewReconciler(ctx context.Context, target *Target, timeouts *TimeoutsConfig, polling *PollingConfig, logger *zerolog.Logger) *Reconciler {
+	return &Reconciler{
+		ctx:      ctx,
+		target:   target,
+		timeouts: timeouts,
+		polling:  polling,
+		logger:   logger.With().Str("server", target.Name).Logger(),
+	}
+}
+
+// desiredCapability returns the action bringing a server to power.
+func desiredCapability(power modules.PowerState) modules.Capability {
+	if power == modules.PowerOn {
+		return modules.CapabilityPowerOn
+	}
+	return modules.CapabilityPowerOff
+}
+
+// reached reports whether a server in state is in the desired power state. A
+// switched on server must also be reachable, when its reachability is known,
+// and a sleeping server is considered switched off.
+func reached(power modules.PowerState, state *modules.State) bool {
+	if power == modules.PowerOn {
+		return state.Power == modules.PowerOn && (state.Reachable == nil || *state.Reachable)
+	}
+	return state.Power == modules.PowerOff || state.Power == modules.PowerSleeping
+}
+
+// Current returns the last reconciliation, or nil when no desired state has
+// been set.
+func (r *Reconciler) Current() *Reconciliation {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.current
+}
+
+// Set starts bringing the server to power, and cancels the previous
+// reconciliation. The reconciliation still running toward the same state is
+// returned as is.
+func (r *Reconciler) Set(power modules.PowerState) *Reconciliation {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.current != nil {
+		state := r.current.State()
+		if state.Power == power && state.Status == ReconcilePending {
+			return r.current
+		}
+		r.current.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	now := time.Now()
+	reconciliation := &Reconciliation{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		state:  DesiredState{Power: power, Status: ReconcilePending, SetAt: now, UpdatedAt: now},
+	}
+	r.current = reconciliation
+	go r.run(ctx, reconciliation)
+	return reconciliation
+}
+
+// Clear cancels the running reconciliation, and forgets the desired state.
+func (r *Reconciler) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.current != nil {
+		r.current.cancel()
+		r.current = nil
+	}
+}
+
+// cancelConflicting cancels the running reconciliation when capability
+// doesn't bring the server to its desired state.
+func (r *Reconciler) cancelConflicting(capability modules.Capability) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.current != nil && desiredCapability(r.current.State().Power) != capability {
+		r.current.cancel()
+	}
+}
+
+// run checks the state each time the poller retrieves it, and at least at
+// the transition interval, so that the desired state is noticed as soon as it
+// is reached. The action is performed again at increasing intervals.
+func (r *Reconciler) run(ctx context.Context, reconciliation *Reconciliation) {
+	defer close(reconciliation.done)
+	defer reconciliation.cancel()
+
+	power := reconciliation.State().Power
+	capability := desiredCapability(power)
+	logger := r.logger.With().Str("desired", string(power)).Logger()
+	logger.Info().Msg("Reconciling the server state")
+
+	start := time.Now()
+	deadline := time.NewTimer(r.timeouts.Reconcile)
+	defer deadline.Stop()
+	ticker := time.NewTicker(r.polling.TransitionInterval)
+	defer ticker.Stop()
+
+	var nextAction time.Time
+	actionInterval := minActionInterval
+	expired := false
+	for {
+		// Subscribed before reading the state, so that no update is missed
+		updated := r.target.Poller.Updated()
+		state := r.target.Poller.State(ctx)
+		if reached(power, &state) {
+			logger.Info().Msgf("Desired state reached after %s", time.Since(start).Round(time.Second))
+			reconciliation.update(func(state *DesiredState) {
+				state.Status = ReconcileReached
+				state.Error = ""
+			})
+			return
+		}
+		if expired {
+			break
+		}
+
+		if !time.Now().Before(nextAction) {
+			// The action is skipped while the server is moving toward the
+			// desired state, and tried again on the next check
+			_, err := r.target.perform(ctx, r.timeouts, capability)
+			switch {
+			case err == nil:
+				logger.Info().Msg(fmt.Sprintf("Server %s requested", capability))
+				reconciliation.update(func(state *DesiredState) {
+					state.Attempts++
+				})
+			case errors.Is(err, modules.ErrNotSupported):
+				reconciliation.update(func(state *DesiredState) {
+					state.Status = ReconcileFailed
+					state.Error = fmt.Sprintf("the %s action is not supported by the module", capability)
+				})
+				return
+			case errors.Is(err, errActionSkipped):
+			default:
+				if ctx.Err() != nil {
+					break
+				}
+				logger.Error().Err(err).Msg(fmt.Sprintf("Server %s error", capability))
+				reconciliation.update(func(state *DesiredState) {
+					state.Error = err.Error()
+				})
+			}
+			if !errors.Is(err, errActionSkipped) {
+				nextAction = time.Now().Add(actionInterval)
+				logger.Debug().
+					Dur("elapsed_time", time.Since(start).Round(time.Second)).
+					Dur("next_action", actionInterval).
+					Msg("Waiting for the server to reach the desired state")
+				actionInterval = min(2*actionInterval, maxActionInterval)
+			}
+		}
+
+		select {
+		case <-updated:
+		case <-ticker.C:
+		case <-deadline.C:
+			// The state is checked a last time
+			expired = true
+		case <-ctx.Done():
+			logger.Info().Msg("Reconciliation canceled")
+			reconciliation.update(func(state *DesiredState) {
+				state.Status = ReconcileCanceled
+			})
+			return
+		}
+	}
+
+	logger.Warn().Msg("The server did not reach the desired state within the timeout period")
+	reconciliation.update(func(state *DesiredState) {
+		state.Status = ReconcileFailed
+		if state.Error == "" {
+			state.Error = fmt.Sprintf("the server didn't reach the %s state within %s", power, r.timeouts.Reconcile)
+		}
+	})
+}
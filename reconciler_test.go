@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/sim"
+)
+
+// newTestTarget creates a target driving module, with its poller running
+// until the end of the test.
+func newTestTarget(t *testing.T, module modules.Module, polling *PollingConfig, timeouts *TimeoutsConfig) *Target {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := zerolog.Nop()
+	target := &Target{Name: "test", ModuleName: "test", Module: module, actions: make(chan struct{}, 1)}
+	target.Poller = NewStatePoller(ctx, target, polling, timeouts.State, &logger)
+	target.Reconciler = NewReconciler(ctx, target, timeouts, polling, &logger)
+	go target.Poller.Run()
+	return target
+}
+
+// newSimModule creates a sim module switched off, which is switched on and
+// reachable without any delay unless config tells otherwise.
+func newSimModule(t *testing.T, config map[string]interface{}) *sim.SimModule {
+	t.Helper()
+	moduleConfig := map[string]interface{}{
+		"boot-delay":         "0s",
+		"shutdown-delay":     "0s",
+		"reachability-delay": "0s",
+	}
+	for key, value := range config {
+		moduleConfig[key] = value
+	}
+	module := sim.New().(*sim.SimModule)
+	if err := module.Init(moduleConfig); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return module
+}
+
+func testTimeouts(reconcile time.Duration) *TimeoutsConfig {
+	timeouts := TimeoutsConfig{Reconcile: reconcile}
+	timeouts.applyDefaults()
+	return &timeouts
+}
+
+func waitReconciliation(t *testing.T, reconciliation *Reconciliation, timeout time.Duration) DesiredState {
+	t.Helper()
+	select {
+	case <-reconciliation.Done():
+	case <-time.After(timeout):
+		t.Fatalf("the reconciliation is still %s after %s", reconciliation.State().Status, timeout)
+	}
+	return reconciliation.State()
+}
+
+// stuckModule accepts the actions, but never leaves its power state.
+type stuckModule struct {
+	modules.DefaultModule
+	powerOns atomic.Int32
+}
+
+func (m *stuckModule) Init(config map[string]interface{}) error {
+	return nil
+}
+
+func (m *stuckModule) Capabilities() modules.Capabilities {
+	return modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff}
+}
+
+func (m *stuckModule) State(ctx context.Context) modules.State {
+	return modules.NewState(modules.Result[bool]{Value: false}, modules.Result[bool]{Value: false})
+}
+
+func (m *stuckModule) PowerOn(ctx context.Context) error {
+	m.powerOns.Add(1)
+	return nil
+}
+
+func (m *stuckModule) PowerOff(ctx context.Context) error {
+	return nil
+}
+
+func TestReconcileReachedWithoutWaiting(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		polling PollingConfig
+	}{
+		// The state refreshed after the action is checked right away
+		{"immediate", nil, PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour}},
+		// The state is checked again at the transition interval while the
+		// server boots, without performing the action again
+		{"booting", map[string]interface{}{"boot-delay": "200ms", "reachability-delay": "200ms"}, PollingConfig{Interval: time.Hour, TransitionInterval: 20 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t, newSimModule(t, tt.config), &tt.polling, testTimeouts(time.Minute))
+
+			reconciliation := target.Reconciler.Set(modules.PowerOn)
+			state := waitReconciliation(t, reconciliation, 5*time.Second)
+			if state.Status != ReconcileReached || state.Attempts != 1 {
+				t.Errorf("reconciliation = %s after %d attempts, want %s after 1 attempt (error: %s)", state.Status, state.Attempts, ReconcileReached, state.Error)
+			}
+		})
+	}
+}
+
+func TestReconcileSpacesActions(t *testing.T) {
+	module := &stuckModule{}
+	polling := PollingConfig{Interval: time.Hour, TransitionInterval: 10 * time.Millisecond}
+	target := newTestTarget(t, module, &polling, testTimeouts(500*time.Millisecond))
+
+	start := time.Now()
+	reconciliation := target.Reconciler.Set(modules.PowerOn)
+	state := waitReconciliation(t, reconciliation, 5*time.Second)
+
+	// The state is checked many times, but the action isn't performed again
+	// before minActionInterval
+	if state.Status != ReconcileFailed || state.Attempts != 1 || module.powerOns.Load() != 1 {
+		t.Errorf("reconciliation = %s after %d attempts and %d power-ons, want %s after 1", state.Status, state.Attempts, module.powerOns.Load(), ReconcileFailed)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the reconciliation failed after %s, want about the reconcile timeout", elapsed)
+	}
+}
+
+func TestReconcileCancelAndReplace(t *testing.T) {
+	tests := []struct {
+		name string
+		// next is called while the first reconciliation, switching the
+		// server on, is pending
+		next       func(t *testing.T, target *Target)
+		wantStatus ReconcileStatus
+		// wantCurrent reports whether the first reconciliation is still the
+		// current one
+		wantCurrent bool
+	}{
+		{
+			name:        "cleared",
+			next:        func(t *testing.T, target *Target) { target.Reconciler.Clear() },
+			wantStatus:  ReconcileCanceled,
+			wantCurrent: false,
+		},
+		{
+			name: "replaced by another power state",
+			next: func(t *testing.T, target *Target) {
+				reconciliation := target.Reconciler.Set(modules.PowerOff)
+				if state := waitReconciliation(t, reconciliation, 5*time.Second); state.Status != ReconcileReached {
+					t.Errorf("new reconciliation = %s, want %s", state.Status, ReconcileReached)
+				}
+			},
+			wantStatus:  ReconcileCanceled,
+			wantCurrent: false,
+		},
+		{
+			name: "conflicting action",
+			next: func(t *testing.T, target *Target) {
+				target.Perform(testContext(t), testTimeouts(0), modules.CapabilityPowerOff)
+			},
+			wantStatus:  ReconcileCanceled,
+			wantCurrent: true,
+		},
+		{
+			name: "same power state",
+			next: func(t *testing.T, target *Target) {
+				if reconciliation := target.Reconciler.Set(modules.PowerOn); reconciliation != target.Reconciler.Current() {
+					t.Error("Set() replaced the pending reconciliation")
+				}
+			},
+			wantStatus:  ReconcilePending,
+			wantCurrent: true,
+		},
+		{
+			name: "same action",
+			next: func(t *testing.T, target *Target) {
+				target.Perform(testContext(t), testTimeouts(0), modules.CapabilityPowerOn)
+			},
+			wantStatus:  ReconcilePending,
+			wantCurrent: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := newTestModule(modules.PowerOff, false)
+			polling := PollingConfig{Interval: time.Hour, TransitionInterval: 10 * time.Millisecond}
+			target := newTestTarget(t, module, &polling, testTimeouts(time.Minute))
+
+			reconciliation := target.Reconciler.Set(modules.PowerOn)
+			waitUntil(t, "the server is switched on", func() bool { return len(module.receivedActions()) == 1 })
+			tt.next(t, target)
+
+			if tt.wantStatus == ReconcilePending {
+				// Give the reconciliation the time to be canceled
+				time.Sleep(50 * time.Millisecond)
+				if state := reconciliation.State(); state.Status != ReconcilePending {
+					t.Errorf("reconciliation = %s, want %s", state.Status, ReconcilePending)
+				}
+			} else if state := waitReconciliation(t, reconciliation, 5*time.Second); state.Status != tt.wantStatus {
+				t.Errorf("reconciliation = %s, want %s", state.Status, tt.wantStatus)
+			}
+			if current := target.Reconciler.Current() == reconciliation; current != tt.wantCurrent {
+				t.Errorf("current = %t, want %t", current, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestReconcileNotSupported(t *testing.T) {
+	module := &testModule{power: modules.PowerOff, capabilities: modules.Capabilities{modules.CapabilityPowerOff}}
+	target := newTestTarget(t, module, &PollingConfig{Interval: time.Hour, TransitionInterval: time.Hour}, testTimeouts(time.Minute))
+
+	state := waitReconciliation(t, target.Reconciler.Set(modules.PowerOn), 5*time.Second)
+	if state.Status != ReconcileFailed || state.Attempts != 0 || state.Error == "" {
+		t.Errorf("reconciliation = %s after %d attempts (error: %q), want %s without any attempt", state.Status, state.Attempts, state.Error, ReconcileFailed)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+)
+
+// ServerConfig is an entry of the servers list of the configuration file.
+type ServerConfig struct {
+	Name   string `validate:"required"`
+	Module string `validate:"required"`
+	Config map[string]interface{}
+}
+
+// Target is a server managed by power, through one of the modules.
+type Target struct {
+	Name       string
+	ModuleName string
+	Module     modules.Module
+	// Poller and Reconciler are only set when power runs as a server
+	Poller     *StatePoller
+	Reconciler *Reconciler
+
+	// actions serializes the actions performed on the server, and guards the
+	// last action fields
+	actions        chan struct{}
+	lastAction     modules.Capability
+	lastPower      modules.PowerState
+	lastActionTime time.Time
+}
+
+// Targets contains the managed servers, in the order of the configuration
+// file. The first one is the default target.
+type Targets []*Target
+
+// Find returns the target named name, or nil when there isn't any.
+func (t Targets) Find(name string) *Target {
+	for _, target := range t {
+		if target.Name == name {
+			return target
+		}
+	}
+	return nil
+}
+
+func (t Targets) Names() []string {
+	names := make([]string, 0, len(t))
+	for _, target := range t {
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+// Capabilities returns the actions supported by at least one of the targets.
+func (t Targets) Capabilities() modules.Capabilities {
+	capabilities := modules.Capabilities{}
+	for _, target := range t {
+		for _, capability := range target.Module.Capabilities() {
+			if !capabilities.Has(capability) {
+				capabilities = append(capabilities, capability)
+			}
+		}
+	}
+	return capabilities
+}
+
+// targetNamePattern restricts the target names to the characters usable as
+// is in URLs and Discord command options.
+var targetNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func createModule(moduleName string, moduleConfig map[string]interface{}) (modules.Module, error) {
+	availableModules := availableModules()
+	newModule, ok := availableModules[moduleName]
+	if !ok {
+		moduleNames := make([]string, 0, len(availableModules))
+		for moduleName := range availableModules {
+			moduleNames = append(moduleNames, moduleName)
+		}
+		sort.Strings(moduleNames)
+		return nil, fmt.Errorf("can't find the %q module among the internal and external modules (available modules: %s)", moduleName, strings.Join(moduleNames, ", "))
+	}
+
+	module := newModule()
+	err := module.Init(moduleConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error during module initialization: %w", err)
+	}
+	return module, nil
+}
+
+// serverConfigs returns the servers list, or the single server controlled by
+// the module flag when the list is empty. The single server is named after
+// its module.
+func serverConfigs(config *Config) []ServerConfig {
+	if len(config.Servers) == 0 {
+		if moduleName == "" {
+			fmt.Fprintln(os.Stderr, "Error: required flag(s) \"module\" not set, or define the servers list in the configuration file")
+			os.Exit(1)
+		}
+		return []ServerConfig{{Name: moduleName, Module: moduleName, Config: config.Module}}
+	}
+
+	if moduleName != "" {
+		fmt.Fprintln(os.Stderr, "Error: the \"module\" flag can't be used along with the servers list of the configuration file")
+		os.Exit(1)
+	}
+	for _, server := range config.Servers {
+		if !targetNamePattern.MatchString(server.Name) {
+			fmt.Fprintf(os.Stderr, "Invalid server name %q, only letters, digits, '-' and '_' are allowed\n", server.Name)
+			os.Exit(1)
+		}
+	}
+	return config.Servers
+}
+
+func createTarget(server *ServerConfig) *Target {
+	module, err := createModule(server.Module, server.Config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the %q module of the %q server: %s\n", server.Module, server.Name, err)
+		os.Exit(1)
+	}
+	return &Target{Name: server.Name, ModuleName: server.Module, Module: module, actions: make(chan struct{}, 1)}
+}
+
+func createTargets(config *Config) Targets {
+	servers := serverConfigs(config)
+	targets := make(Targets, 0, len(servers))
+	for i := range servers {
+		targets = append(targets, createTarget(&servers[i]))
+	}
+	return targets
+}
+
+// selectTarget creates the target chosen by the target flag, or the default
+// one when the flag isn't set. The other servers aren't created.
+func selectTarget(config *Config) *Target {
+	servers := serverConfigs(config)
+	if targetName == "" {
+		return createTarget(&servers[0])
+	}
+
+	names := make([]string, 0, len(servers))
+	for i := range servers {
+		if servers[i].Name == targetName {
+			return createTarget(&servers[i])
+		}
+		names = append(names, servers[i].Name)
+	}
+	fmt.Fprintf(os.Stderr, "Can't find the %q server (available servers: %s)\n", targetName, strings.Join(names, ", "))
+	os.Exit(1)
+	return nil
+}
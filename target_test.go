@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tr4cks/power/modules"
+	"github.com/tr4cks/power/modules/sim"
+)
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// setFlags sets the module and target flags for the duration of the test,
+// with an empty plugin directory.
+func setFlags(t *testing.T, module string, target string) {
+	previousModule, previousTarget, previousPluginDir := moduleName, targetName, pluginDir
+	moduleName, targetName, pluginDir = module, target, t.TempDir()
+	t.Cleanup(func() {
+		moduleName, targetName, pluginDir = previousModule, previousTarget, previousPluginDir
+	})
+}
+
+func testServers() []ServerConfig {
+	return []ServerConfig{
+		{Name: "nas", Module: "sim", Config: map[string]interface{}{"on": true}},
+		{Name: "desktop", Module: "sim"},
+	}
+}
+
+func TestTargetsFind(t *testing.T) {
+	setFlags(t, "", "")
+	targets := createTargets(&Config{Servers: testServers()})
+
+	tests := []struct {
+		name string
+		want *Target
+	}{
+		{"nas", targets[0]},
+		{"desktop", targets[1]},
+		{"laptop", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targets.Find(tt.name); got != tt.want {
+				t.Errorf("Find(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+	if names := targets.Names(); !slices.Equal(names, []string{"nas", "desktop"}) {
+		t.Errorf("Names() = %v, want the order of the configuration file", names)
+	}
+}
+
+func TestSelectTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		module     string
+		target     string
+		servers    []ServerConfig
+		wantName   string
+		wantModule string
+	}{
+		{"default server", "", "", testServers(), "nas", "sim"},
+		{"selected server", "", "desktop", testServers(), "desktop", "sim"},
+		{"module flag", "sim", "", nil, "sim", "sim"},
+		{"module flag with target", "sim", "sim", nil, "sim", "sim"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.module, tt.target)
+			target := selectTarget(&Config{Servers: tt.servers})
+			if target.Name != tt.wantName || target.ModuleName != tt.wantModule {
+				t.Errorf("selectTarget() = %s (%s), want %s (%s)", target.Name, target.ModuleName, tt.wantName, tt.wantModule)
+			}
+			if _, ok := target.Module.(*sim.SimModule); !ok {
+				t.Errorf("selectTarget().Module = %T, want a sim module", target.Module)
+			}
+		})
+	}
+}
+
+func TestCreateTargets(t *testing.T) {
+	setFlags(t, "", "")
+	targets := createTargets(&Config{Servers: testServers()})
+	if len(targets) != 2 {
+		t.Fatalf("createTargets() returned %d targets, want 2", len(targets))
+	}
+	// Each server has its own module, configured separately
+	for i, wantPower := range []modules.PowerState{modules.PowerOn, modules.PowerOff} {
+		state := targets[i].Module.State(testContext(t))
+		if state.Power != wantPower {
+			t.Errorf("%s state = %s, want %s", targets[i].Name, state.Power, wantPower)
+		}
+		if cap(targets[i].actions) != 1 {
+			t.Errorf("%s action lock capacity = %d, want 1", targets[i].Name, cap(targets[i].actions))
+		}
+	}
+}
+
+func TestCreateModule(t *testing.T) {
+	setFlags(t, "", "")
+	tests := []struct {
+		name    string
+		module  string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"valid", "sim", map[string]interface{}{"on": true}, ""},
+		{"unknown module", "unknown", nil, `can't find the "unknown" module among the internal and external modules (available modules: amt, composite, docker,`},
+		{"invalid configuration", "sim", map[string]interface{}{"boot-delay": "-1s"}, "error during module initialization: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module, err := createModule(tt.module, tt.config)
+			if tt.wantErr == "" {
+				if err != nil || module == nil {
+					t.Fatalf("createModule() = %v, %v", module, err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("createModule() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// capabilitiesModule only advertises its capabilities.
+type capabilitiesModule struct {
+	modules.DefaultModule
+	capabilities modules.Capabilities
+}
+
+func (m *capabilitiesModule) Capabilities() modules.Capabilities {
+	return m.capabilities
+}
+
+func TestTargetsCapabilities(t *testing.T) {
+	targets := Targets{
+		{Name: "wol", Module: &capabilitiesModule{capabilities: modules.Capabilities{modules.CapabilityPowerOn}}},
+		{Name: "ipmi", Module: &capabilitiesModule{capabilities: modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityNmi}}},
+		{Name: "ssh", Module: &capabilitiesModule{capabilities: modules.Capabilities{modules.CapabilityPowerOff, modules.CapabilitySuspend}}},
+	}
+	want := modules.Capabilities{modules.CapabilityPowerOn, modules.CapabilityPowerOff, modules.CapabilityNmi, modules.CapabilitySuspend}
+	if got := targets.Capabilities(); !slices.Equal(got, want) {
+		t.Errorf("Capabilities() = %v, want %v", got, want)
+	}
+}